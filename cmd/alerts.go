@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	alertSpread       = "spread"
+	alertSwapSize     = "swap_size"
+	alertReservesDrop = "reserves_drop"
+
+	defaultAlertCooldown = 10 * time.Minute
+	alertDeliveryTimeout = 10 * time.Second
+	//delivered alerts are remembered this long to drop duplicates, e.g. of blocks read again after a reorg
+	alertDedupWindow = time.Hour
+)
+
+// alertRule is a condition checked on every followed block, see README for the meaning of thresholds
+type alertRule struct {
+	name string
+	kind string
+	//chain, pair and dex limit the rule to matching pools, empty values match all
+	chain    string
+	pair     string
+	dex      string
+	minBps   float64
+	blocks   int
+	minSize  float64
+	dropPct  float64
+	cooldown time.Duration
+	sinks    []alertSink
+}
+
+// alertSink delivers JSON payloads of fired alerts
+type alertSink struct {
+	name    string
+	deliver func(ctx context.Context, payload []byte) error
+}
+
+// webhookSink posts the payload to url
+func webhookSink(name, url string) alertSink {
+	return alertSink{name: name, deliver: func(ctx context.Context, payload []byte) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook answered %s", resp.Status)
+		}
+		return nil
+	}}
+}
+
+// commandSink runs a local command with the payload on its standard input
+func commandSink(name string, args []string) alertSink {
+	return alertSink{name: name, deliver: func(ctx context.Context, payload []byte) error {
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(payload)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("%s: %v %s", args[0], err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}}
+}
+
+// fileSink appends the payload to a JSON lines file
+func fileSink(name, path string) alertSink {
+	var mu sync.Mutex
+	return alertSink{name: name, deliver: func(ctx context.Context, payload []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := file.Write(append(payload, '\n')); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}}
+}
+
+// alert is the payload delivered to sinks
+type alert struct {
+	Rule          string  `json:"rule"`
+	Type          string  `json:"type"`
+	Chain         string  `json:"chain"`
+	Pair          string  `json:"pair"`
+	Dex           string  `json:"dex,omitempty"`
+	Block         uint64  `json:"block"`
+	Time          string  `json:"time"`
+	Confirmations uint64  `json:"confirmations"`
+	Value         float64 `json:"value"`
+	Threshold     float64 `json:"threshold"`
+	Tx            string  `json:"tx,omitempty"`
+	Message       string  `json:"message"`
+	//Retracted is set when the alert is delivered again because a reorg replaced its block
+	Retracted bool `json:"retracted,omitempty"`
+	//firedAt is when the alert started its cooldown
+	firedAt time.Time
+}
+
+// alertBlock is what rules see of one pair in one block
+type alertBlock struct {
+	chain         string
+	blockNum      uint64
+	blockTime     uint64
+	confirmations uint64
+	//pair holds trades of this block only
+	pair pairTrades
+	//reserves of every venue at the end of the block, known is false where they could not be read
+	reserves []poolReserves
+	known    []bool
+}
+
+type spreadRun struct {
+	lastBlock uint64
+	blocks    int
+}
+
+type blockReserves struct {
+	blockNum uint64
+	poolReserves
+}
+
+// alertEngine checks rules block by block and delivers fired alerts in the background.
+// A rule does not fire again for the same pool within its cooldown, and the same alert is never delivered twice
+type alertEngine struct {
+	rules    []alertRule
+	location *time.Location
+
+	mu sync.Mutex
+	//consecutive blocks with spread over the threshold by rule and pair
+	runs map[string]spreadRun
+	//reserves of the previous block by pool
+	reserves  map[string]blockReserves
+	lastFired map[string]time.Time
+	delivered map[string]time.Time
+	wg        sync.WaitGroup
+}
+
+func newAlertEngine(cfg *appConfig) *alertEngine {
+	if len(cfg.alertRules) == 0 {
+		return nil
+	}
+	return &alertEngine{
+		rules:     cfg.alertRules,
+		location:  cfg.location,
+		runs:      make(map[string]spreadRun),
+		reserves:  make(map[string]blockReserves),
+		lastFired: make(map[string]time.Time),
+		delivered: make(map[string]time.Time),
+	}
+}
+
+// needsReserves tells whether reserves of every block have to be read for the rules
+func (e *alertEngine) needsReserves() bool {
+	for _, rule := range e.rules {
+		if rule.kind == alertSpread || rule.kind == alertReservesDrop {
+			return true
+		}
+	}
+	return false
+}
+
+// check evaluates all rules on the block, delivers fired alerts and returns them for printing
+func (e *alertEngine) check(b alertBlock) []alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var fired []alert
+	for _, rule := range e.rules {
+		if rule.chain != "" && !strings.EqualFold(rule.chain, b.chain) || rule.pair != "" && !matchPairName(rule.pair, b.pair.tokens) {
+			continue
+		}
+		var candidates []alert
+		switch rule.kind {
+		case alertSpread:
+			candidates = e.checkSpread(rule, b)
+		case alertSwapSize:
+			candidates = e.checkSwapSize(rule, b)
+		case alertReservesDrop:
+			candidates = e.checkReservesDrop(rule, b)
+		}
+		for _, a := range candidates {
+			if e.fire(rule, &a) {
+				fired = append(fired, a)
+			}
+		}
+	}
+	//reserves drop compares with the previous block, so reserves are remembered after all rules are checked
+	for i, venue := range b.pair.venues {
+		if b.known != nil && b.known[i] {
+			e.reserves[poolKey(b.chain, b.pair.tokens, venue.name)] = blockReserves{blockNum: b.blockNum, poolReserves: b.reserves[i]}
+		}
+	}
+	return fired
+}
+
+func poolKey(chain string, tokens tokenStruct, dex string) string {
+	return chain + "|" + pairName(tokens) + "|" + dex
+}
+
+// newAlert fills fields common to all rules
+func (e *alertEngine) newAlert(rule alertRule, b alertBlock) alert {
+	return alert{
+		Rule:          rule.name,
+		Type:          rule.kind,
+		Chain:         b.chain,
+		Pair:          pairName(b.pair.tokens),
+		Block:         b.blockNum,
+		Time:          time.Unix(int64(b.blockTime), 0).In(e.location).Format(time.RFC3339),
+		Confirmations: b.confirmations,
+	}
+}
+
+// checkSpread fires when the mid-price spread between venues stays over minBps for the given number of consecutive blocks
+func (e *alertEngine) checkSpread(rule alertRule, b alertBlock) []alert {
+	var low, high int
+	priced := 0
+	prices := make([]float64, len(b.pair.venues))
+	for i := range b.pair.venues {
+		if b.known == nil || !b.known[i] {
+			continue
+		}
+		prices[i] = b.reserves[i].midPrice(b.pair.tokens)
+		if prices[i] <= 0 {
+			continue
+		}
+		if priced == 0 || prices[i] < prices[low] {
+			low = i
+		}
+		if priced == 0 || prices[i] > prices[high] {
+			high = i
+		}
+		priced++
+	}
+	if priced < 2 {
+		return nil
+	}
+
+	key := rule.name + "|" + poolKey(b.chain, b.pair.tokens, "")
+	bps := spreadBps(prices[low], prices[high])
+	if bps < rule.minBps {
+		delete(e.runs, key)
+		return nil
+	}
+	//a gap or a block read again after a reorg starts the run anew
+	run := e.runs[key]
+	if run.blocks == 0 || b.blockNum != run.lastBlock+1 {
+		run.blocks = 0
+	}
+	run.blocks++
+	run.lastBlock = b.blockNum
+	e.runs[key] = run
+	if run.blocks < rule.blocks {
+		return nil
+	}
+
+	a := e.newAlert(rule, b)
+	a.Value, a.Threshold = bps, rule.minBps
+	a.Message = fmt.Sprintf("%s spread %.1f bps between %s (%.2f) and %s (%.2f) for %d blocks", a.Pair, bps,
+		b.pair.venues[low].name, prices[low], b.pair.venues[high].name, prices[high], run.blocks)
+	return []alert{a}
+}
+
+// checkSwapSize fires for every swap at least minSize in the base token
+func (e *alertEngine) checkSwapSize(rule alertRule, b alertBlock) []alert {
+	base, _ := b.pair.tokens.baseQuote()
+	var alerts []alert
+	for _, venue := range b.pair.venues {
+		if rule.dex != "" && !strings.EqualFold(rule.dex, venue.name) {
+			continue
+		}
+		for _, trade := range venue.trades[b.blockNum] {
+			if trade.size < rule.minSize {
+				continue
+			}
+			a := e.newAlert(rule, b)
+			a.Dex, a.Tx = venue.name, trade.txHash.Hex()
+			a.Value, a.Threshold = trade.size, rule.minSize
+			side := trade.swapSide.String()
+			a.Message = fmt.Sprintf("%s of %.2f %s at %.2f on %s", strings.ToUpper(side[:1])+side[1:], trade.size, base, trade.price, venue.name)
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts
+}
+
+// checkReservesDrop fires when a reserve of a pool falls by dropPct or more since the previous block
+func (e *alertEngine) checkReservesDrop(rule alertRule, b alertBlock) []alert {
+	var alerts []alert
+	for i, venue := range b.pair.venues {
+		if rule.dex != "" && !strings.EqualFold(rule.dex, venue.name) || b.known == nil || !b.known[i] {
+			continue
+		}
+		prev, ok := e.reserves[poolKey(b.chain, b.pair.tokens, venue.name)]
+		if !ok || prev.blockNum+1 != b.blockNum {
+			continue
+		}
+		drop, symbol := reservesDrop(prev.poolReserves, b.reserves[i], b.pair.tokens)
+		if drop < rule.dropPct {
+			continue
+		}
+		a := e.newAlert(rule, b)
+		a.Dex = venue.name
+		a.Value, a.Threshold = drop, rule.dropPct
+		a.Message = fmt.Sprintf("%s %s reserve of %s dropped %.1f%% in block %d", venue.name, a.Pair, symbol, drop, b.blockNum)
+		alerts = append(alerts, a)
+	}
+	return alerts
+}
+
+func (a alert) dedupKey() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d|%s", a.Rule, a.Chain, a.Pair, a.Dex, a.Block, a.Tx)
+}
+
+// subject is what the cooldown of the rule applies to
+func (a alert) subject() string {
+	return fmt.Sprintf("%s|%s|%s|%s", a.Rule, a.Chain, a.Pair, a.Dex)
+}
+
+// fire applies cooldown and deduplication and starts delivery, it returns false for suppressed alerts
+func (e *alertEngine) fire(rule alertRule, a *alert) bool {
+	now := time.Now()
+	for key, at := range e.delivered {
+		if now.Sub(at) > alertDedupWindow {
+			delete(e.delivered, key)
+		}
+	}
+	if _, ok := e.delivered[a.dedupKey()]; ok {
+		return false
+	}
+	if last, ok := e.lastFired[a.subject()]; ok && now.Sub(last) < rule.cooldown {
+		return false
+	}
+	a.firedAt = now
+	e.delivered[a.dedupKey()] = now
+	e.lastFired[a.subject()] = now
+	e.deliver(rule, *a)
+	return true
+}
+
+// retract withdraws alerts of blocks from fromBlock on, which a reorg replaced. Sinks get every alert again marked
+// as retracted, and cooldowns, deduplication and reserves of those blocks are forgotten, so that the blocks
+// replacing them are evaluated afresh
+func (e *alertEngine) retract(chain string, fromBlock uint64, alerts []alert) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, a := range alerts {
+		delete(e.delivered, a.dedupKey())
+		if e.lastFired[a.subject()].Equal(a.firedAt) {
+			delete(e.lastFired, a.subject())
+		}
+		for _, rule := range e.rules {
+			if rule.name == a.Rule {
+				a.Retracted = true
+				a.Message = "retracted by a reorg: " + a.Message
+				e.deliver(rule, a)
+			}
+		}
+	}
+	//reserves drop compares with the previous block, reserves of replaced blocks must not be compared with
+	for key, reserves := range e.reserves {
+		if strings.HasPrefix(key, chain+"|") && reserves.blockNum >= fromBlock {
+			delete(e.reserves, key)
+		}
+	}
+}
+
+// deliver sends the alert to the sinks of the rule in the background
+func (e *alertEngine) deliver(rule alertRule, a alert) {
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	for _, sink := range rule.sinks {
+		e.wg.Add(1)
+		go func(sink alertSink) {
+			defer e.wg.Done()
+			//delivery is not tied to the run context, so that alerts fired just before shutdown still go out
+			ctx, cancel := context.WithTimeout(context.Background(), alertDeliveryTimeout)
+			defer cancel()
+			if err := sink.deliver(ctx, payload); err != nil {
+				fmt.Printf("[%s] Warning: alert %s not delivered to %s: %v\n", a.Chain, a.Rule, sink.name, err)
+			}
+		}(sink)
+	}
+}
+
+// wait blocks until deliveries in progress complete
+func (e *alertEngine) wait() {
+	e.wg.Wait()
+}
+
+// reservesDrop returns the largest relative fall of the pool reserves in percent and the token it happened to
+func reservesDrop(prev, cur poolReserves, tokens tokenStruct) (float64, string) {
+	drop0, drop1 := 0.0, 0.0
+	if prev.reserve0 > 0 {
+		drop0 = (prev.reserve0 - cur.reserve0) / prev.reserve0 * 100
+	}
+	if prev.reserve1 > 0 {
+		drop1 = (prev.reserve1 - cur.reserve1) / prev.reserve1 * 100
+	}
+	if drop1 > drop0 {
+		return drop1, tokens.tkn1Symbol
+	}
+	return math.Max(drop0, 0), tokens.tkn0Symbol
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// recordingSink keeps delivered alerts
+type recordingSink struct {
+	mu     sync.Mutex
+	alerts []alert
+}
+
+func (r *recordingSink) sink() alertSink {
+	return alertSink{name: "test", deliver: func(ctx context.Context, payload []byte) error {
+		var a alert
+		if err := json.Unmarshal(payload, &a); err != nil {
+			return err
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.alerts = append(r.alerts, a)
+		return nil
+	}}
+}
+
+// alertStep is one block of WETH/USDC on venues A and B
+type alertStep struct {
+	//retractFrom is the first block replaced by a reorg before the block is checked, 0 if there was no reorg
+	retractFrom uint64
+	blockNum    uint64
+	//reserves of A and B, a zero value is not known
+	reserves [2]poolReserves
+	//sizes of swaps made on A
+	sizes []float64
+	//want are parts of the messages of alerts fired on the block
+	want []string
+}
+
+func TestAlertRules(t *testing.T) {
+	tokens := tokenStruct{tkn0Symbol: "USDC", tkn0Decimals: 6, tkn1Symbol: "WETH", tkn1Decimals: 18}
+	//mid-prices of 1700 and 1717, a spread of 100 bps, and of 1701.7, a spread of 10 bps
+	low, high, near := poolReserves{reserve0: 1700000, reserve1: 1000}, poolReserves{reserve0: 1717000, reserve1: 1000}, poolReserves{reserve0: 1701700, reserve1: 1000}
+	spread := alertRule{name: "spread", kind: alertSpread, minBps: 50, blocks: 2}
+
+	tests := []struct {
+		name  string
+		rule  alertRule
+		steps []alertStep
+	}{
+		{name: "spread for consecutive blocks", rule: spread, steps: []alertStep{
+			{blockNum: 1, reserves: [2]poolReserves{low, high}},
+			{blockNum: 2, reserves: [2]poolReserves{low, high}, want: []string{"WETH/USDC spread 100.0 bps between A (1700.00) and B (1717.00) for 2 blocks"}},
+			{blockNum: 3, reserves: [2]poolReserves{high, low}, want: []string{"between B (1700.00) and A (1717.00) for 3 blocks"}},
+		}},
+		{name: "spread run broken by a gap", rule: spread, steps: []alertStep{
+			{blockNum: 1, reserves: [2]poolReserves{low, high}},
+			{blockNum: 3, reserves: [2]poolReserves{low, high}},
+			{blockNum: 4, reserves: [2]poolReserves{low, high}, want: []string{"for 2 blocks"}},
+		}},
+		{name: "spread run broken by a narrow block", rule: spread, steps: []alertStep{
+			{blockNum: 1, reserves: [2]poolReserves{low, high}},
+			{blockNum: 2, reserves: [2]poolReserves{low, near}},
+			{blockNum: 3, reserves: [2]poolReserves{low, high}},
+		}},
+		{name: "spread of one known pool", rule: spread, steps: []alertStep{
+			{blockNum: 1, reserves: [2]poolReserves{low}},
+			{blockNum: 2, reserves: [2]poolReserves{low}},
+		}},
+		{name: "cooldown", rule: alertRule{name: "spread", kind: alertSpread, minBps: 50, blocks: 1, cooldown: time.Hour}, steps: []alertStep{
+			{blockNum: 1, reserves: [2]poolReserves{low, high}, want: []string{"for 1 blocks"}},
+			{blockNum: 2, reserves: [2]poolReserves{low, high}},
+		}},
+		{name: "block read again", rule: alertRule{name: "spread", kind: alertSpread, minBps: 50, blocks: 1}, steps: []alertStep{
+			{blockNum: 1, reserves: [2]poolReserves{low, high}, want: []string{"for 1 blocks"}},
+			{blockNum: 1, reserves: [2]poolReserves{low, high}},
+		}},
+		{name: "swap size", rule: alertRule{name: "whale", kind: alertSwapSize, minSize: 5}, steps: []alertStep{
+			{blockNum: 1, sizes: []float64{1, 10, 5}, want: []string{"Buy of 10.00 WETH at 1700.00 on A", "Buy of 5.00 WETH at 1700.00 on A"}},
+		}},
+		{name: "swap size of another dex", rule: alertRule{name: "whale", kind: alertSwapSize, minSize: 5, dex: "B"}, steps: []alertStep{
+			{blockNum: 1, sizes: []float64{10}},
+		}},
+		{name: "reserves drop", rule: alertRule{name: "drain", kind: alertReservesDrop, dropPct: 20}, steps: []alertStep{
+			{blockNum: 1, reserves: [2]poolReserves{low, high}},
+			{blockNum: 2, reserves: [2]poolReserves{{reserve0: 1700000, reserve1: 700}, high}, want: []string{"A WETH/USDC reserve of WETH dropped 30.0% in block 2"}},
+			{blockNum: 4, reserves: [2]poolReserves{{reserve0: 1700000, reserve1: 100}, high}},
+		}},
+		{name: "cooldown of a reorged block", rule: alertRule{name: "spread", kind: alertSpread, minBps: 50, blocks: 1, cooldown: time.Hour}, steps: []alertStep{
+			{blockNum: 1, reserves: [2]poolReserves{low, high}, want: []string{"for 1 blocks"}},
+			{blockNum: 1, reserves: [2]poolReserves{low, high}, want: []string{"for 1 blocks"}, retractFrom: 1},
+			{blockNum: 2, reserves: [2]poolReserves{low, high}},
+		}},
+		{name: "reserves drop of a reorged block", rule: alertRule{name: "drain", kind: alertReservesDrop, dropPct: 20}, steps: []alertStep{
+			{blockNum: 1, reserves: [2]poolReserves{low, high}},
+			{blockNum: 2, reserves: [2]poolReserves{{reserve0: 1700000, reserve1: 700}, high}, want: []string{"dropped 30.0% in block 2"}},
+			//reserves of the replaced block are not compared with
+			{blockNum: 2, reserves: [2]poolReserves{{reserve0: 1700000, reserve1: 1000}, high}, retractFrom: 2},
+			{blockNum: 3, reserves: [2]poolReserves{{reserve0: 1700000, reserve1: 700}, high}, want: []string{"dropped 30.0% in block 3"}},
+		}},
+		{name: "reorg after the alert", rule: alertRule{name: "spread", kind: alertSpread, minBps: 50, blocks: 1}, steps: []alertStep{
+			{blockNum: 1, reserves: [2]poolReserves{low, high}, want: []string{"for 1 blocks"}},
+			{blockNum: 2, reserves: [2]poolReserves{low, near}, retractFrom: 2},
+		}},
+		{name: "other chain", rule: alertRule{name: "whale", kind: alertSwapSize, minSize: 5, chain: "arbitrum"}, steps: []alertStep{
+			{blockNum: 1, sizes: []float64{10}},
+		}},
+		{name: "other pair", rule: alertRule{name: "whale", kind: alertSwapSize, minSize: 5, pair: "WBTC/USDC"}, steps: []alertStep{
+			{blockNum: 1, sizes: []float64{10}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sink recordingSink
+			tt.rule.sinks = []alertSink{sink.sink()}
+			engine := newAlertEngine(&appConfig{alertRules: []alertRule{tt.rule}, location: time.UTC})
+			var want []string
+			var fired, retracted []alert
+			for _, step := range tt.steps {
+				if step.retractFrom > 0 {
+					var kept, replaced []alert
+					for _, a := range fired {
+						if a.Block >= step.retractFrom {
+							replaced = append(replaced, a)
+						} else {
+							kept = append(kept, a)
+						}
+					}
+					engine.retract("ethereum", step.retractFrom, replaced)
+					fired, retracted = kept, append(retracted, replaced...)
+				}
+				b := alertBlock{chain: "ethereum", blockNum: step.blockNum, blockTime: 1700000000 + step.blockNum*12,
+					pair: pairTrades{tokens: tokens}, reserves: step.reserves[:], known: make([]bool, 2)}
+				for i, name := range []string{"A", "B"} {
+					b.known[i] = step.reserves[i] != poolReserves{}
+					b.pair.venues = append(b.pair.venues, venueTrades{name: name, trades: make(map[uint64][]tradeStruct)})
+				}
+				for i, size := range step.sizes {
+					trade := tradeStruct{price: 1700, size: size, swapSide: buy, txHash: common.BigToHash(big.NewInt(int64(i + 1)))}
+					b.pair.venues[0].trades[step.blockNum] = append(b.pair.venues[0].trades[step.blockNum], trade)
+				}
+				blockFired := engine.check(b)
+				if len(blockFired) != len(step.want) {
+					t.Fatalf("block %d: want %d alerts, got %+v", step.blockNum, len(step.want), blockFired)
+				}
+				for i, part := range step.want {
+					if !strings.Contains(blockFired[i].Message, part) || blockFired[i].Block != step.blockNum || blockFired[i].Rule != tt.rule.name {
+						t.Errorf("block %d: want %q, got %+v", step.blockNum, part, blockFired[i])
+					}
+				}
+				fired = append(fired, blockFired...)
+				want = append(want, step.want...)
+			}
+			engine.wait()
+			if len(sink.alerts) != len(want)+len(retracted) {
+				t.Errorf("want %d alerts and %d retractions delivered, got %+v", len(want), len(retracted), sink.alerts)
+			}
+			delivered := 0
+			for _, a := range sink.alerts {
+				if a.Retracted {
+					delivered++
+					if !strings.HasPrefix(a.Message, "retracted by a reorg: ") {
+						t.Errorf("want a retraction message, got %q", a.Message)
+					}
+				}
+			}
+			if delivered != len(retracted) {
+				t.Errorf("want %d retractions delivered, got %+v", len(retracted), sink.alerts)
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	defaultLatencyBlocks = 1
+	//defaultGasUnits is gas of a transaction making two Uniswap V2 swaps
+	defaultGasUnits = 250000
+)
+
+// backtestParams is the arbitrage strategy replayed by backtest, its minimum spread is analysis.min_spread_bps
+type backtestParams struct {
+	//maxSize limits base token bought by one arbitrage, 0 is unlimited
+	maxSize       float64
+	latencyBlocks uint64
+	gasUnits      uint64
+	gasPriceGwei  float64
+	//nativePrice is the price of the gas token in the quote token, required when gas has a price
+	nativePrice float64
+}
+
+// poolHistory is the state of a pool at the start of the backtest and at the end of every block changing it
+type poolHistory struct {
+	initial poolReserves
+	changes map[uint64]poolReserves
+	swaps   int
+}
+
+// simPool is a pool as seen by the simulation, reserves of the chain with the simulated trades applied.
+// Simulated trades are forgotten when the pool changes on-chain, as the chain never saw them
+type simPool struct {
+	name  string
+	fee   float64
+	base  float64
+	quote float64
+}
+
+// amountOut is the output of a constant product swap of amountIn after the fee
+func amountOut(amountIn, reserveIn, reserveOut, fee float64) float64 {
+	amountIn *= 1 - fee
+	return amountIn * reserveOut / (reserveIn + amountIn)
+}
+
+// swapCurve is the output of one or more constant product swaps made one after another, out = a*in/(b+c*in)
+type swapCurve struct {
+	a, b, c float64
+}
+
+// newSwapCurve is the curve of a single swap of a pool with the given reserves and fee
+func newSwapCurve(reserveIn, reserveOut, fee float64) swapCurve {
+	return swapCurve{a: (1 - fee) * reserveOut, b: reserveIn, c: 1 - fee}
+}
+
+// then chains the next swap after the curve, the result is again a constant product curve
+func (s swapCurve) then(next swapCurve) swapCurve {
+	return swapCurve{a: s.a * next.a, b: s.b * next.b, c: next.b*s.c + next.c*s.a}
+}
+
+func (s swapCurve) out(amountIn float64) float64 {
+	return s.a * amountIn / (s.b + s.c*amountIn)
+}
+
+// in is the input needed to get amountOut, ok is false if the pools can not give that much
+func (s swapCurve) in(amountOut float64) (amountIn float64, ok bool) {
+	if s.a <= s.c*amountOut {
+		return 0, false
+	}
+	return s.b * amountOut / (s.a - s.c*amountOut), true
+}
+
+// optimalInput is the input making out(in)-in the highest, 0 if no input is profitable
+func (s swapCurve) optimalInput() float64 {
+	if s.a <= s.b || s.c <= 0 {
+		return 0
+	}
+	return (math.Sqrt(s.a*s.b) - s.b) / s.c
+}
+
+// arbPlan is an arbitrage decided at one block and executed latency blocks later:
+// quote token is swapped into base on the cheap pool and the base is sold on the expensive one
+type arbPlan struct {
+	decided, executed uint64
+	buy, sell         int
+	bps               float64
+	quoteIn           float64
+	expectedProfit    float64
+}
+
+// backtestTrade is an executed arbitrage of the trade log
+type backtestTrade struct {
+	arbPlan
+	buyOn, sellOn string
+	baseOut       float64
+	quoteOut      float64
+	gas           float64
+	pnl           float64
+	cumulative    float64
+}
+
+// backtestResult summarizes the replay of one pair
+type backtestResult struct {
+	trades      []backtestTrade
+	blocks      int
+	swaps       int
+	wins        int
+	pnl         float64
+	gas         float64
+	maxDrawdown float64
+}
+
+// gasCost converts gas of one arbitrage to the quote token
+func (p backtestParams) gasCost() float64 {
+	return float64(p.gasUnits) * p.gasPriceGwei * 1e-9 * p.nativePrice
+}
+
+// planArbitrage finds the most profitable arbitrage between two pools whose mid-prices differ by at least the
+// minimum spread. The input is the optimum of the two constant product curves, limited by the maximum size
+func planArbitrage(pools []simPool, params backtestParams, minSpreadBps float64) (arbPlan, bool) {
+	var (
+		best  arbPlan
+		found bool
+	)
+	for i, cheap := range pools {
+		for j, dear := range pools {
+			if i == j || cheap.base <= 0 || dear.base <= 0 {
+				continue
+			}
+			low, high := cheap.quote/cheap.base, dear.quote/dear.base
+			bps := spreadBps(low, high)
+			if low >= high || bps < minSpreadBps {
+				continue
+			}
+			quoteIn := newSwapCurve(cheap.quote, cheap.base, cheap.fee).then(newSwapCurve(dear.base, dear.quote, dear.fee)).optimalInput()
+			if quoteIn <= 0 {
+				continue
+			}
+			if params.maxSize > 0 && amountOut(quoteIn, cheap.quote, cheap.base, cheap.fee) > params.maxSize {
+				if params.maxSize >= cheap.base {
+					continue
+				}
+				quoteIn = params.maxSize * cheap.quote / ((1 - cheap.fee) * (cheap.base - params.maxSize))
+			}
+			baseOut := amountOut(quoteIn, cheap.quote, cheap.base, cheap.fee)
+			profit := amountOut(baseOut, dear.base, dear.quote, dear.fee) - quoteIn - params.gasCost()
+			if profit > 0 && (!found || profit > best.expectedProfit) {
+				best = arbPlan{buy: i, sell: j, bps: bps, quoteIn: quoteIn, expectedProfit: profit}
+				found = true
+			}
+		}
+	}
+	return best, found
+}
+
+// execute makes the planned swaps against the current pools and applies them to their reserves
+func (plan arbPlan) execute(pools []simPool, params backtestParams) backtestTrade {
+	cheap, dear := &pools[plan.buy], &pools[plan.sell]
+	trade := backtestTrade{arbPlan: plan, buyOn: cheap.name, sellOn: dear.name, gas: params.gasCost()}
+	trade.baseOut = amountOut(plan.quoteIn, cheap.quote, cheap.base, cheap.fee)
+	cheap.quote, cheap.base = cheap.quote+plan.quoteIn, cheap.base-trade.baseOut
+	trade.quoteOut = amountOut(trade.baseOut, dear.base, dear.quote, dear.fee)
+	dear.base, dear.quote = dear.base+trade.baseOut, dear.quote-trade.quoteOut
+	trade.pnl = trade.quoteOut - plan.quoteIn - trade.gas
+	return trade
+}
+
+// replayPair runs the strategy over reserves of the pair block by block. A spread seen at the end of a block
+// is traded latency blocks later, against reserves at the start of that block, one arbitrage at a time
+func replayPair(tokens tokenStruct, names []string, fees []float64, histories []poolHistory, params backtestParams,
+	minSpreadBps float64) backtestResult {
+
+	var result backtestResult
+	pools := make([]simPool, len(histories))
+	blocks := make(map[uint64]bool)
+	for i, history := range histories {
+		base, quote := history.initial.baseQuote(tokens)
+		pools[i] = simPool{name: names[i], fee: fees[i], base: base, quote: quote}
+		for blockNum := range history.changes {
+			blocks[blockNum] = true
+		}
+		result.swaps += history.swaps
+	}
+	blockNums := make([]uint64, 0, len(blocks))
+	for blockNum := range blocks {
+		blockNums = append(blockNums, blockNum)
+	}
+	sort.Slice(blockNums, func(i, j int) bool { return blockNums[i] < blockNums[j] })
+	result.blocks = len(blockNums)
+
+	var (
+		pending *arbPlan
+		peak    float64
+	)
+	execute := func() {
+		trade := pending.execute(pools, params)
+		pending = nil
+		result.pnl += trade.pnl
+		result.gas += trade.gas
+		trade.cumulative = result.pnl
+		if trade.pnl > 0 {
+			result.wins++
+		}
+		peak = math.Max(peak, result.pnl)
+		result.maxDrawdown = math.Max(result.maxDrawdown, peak-result.pnl)
+		result.trades = append(result.trades, trade)
+	}
+	for _, blockNum := range blockNums {
+		if pending != nil && pending.executed <= blockNum {
+			execute()
+		}
+		for i, history := range histories {
+			if reserves, ok := history.changes[blockNum]; ok {
+				pools[i].base, pools[i].quote = reserves.baseQuote(tokens)
+			}
+		}
+		if pending != nil {
+			continue
+		}
+		if plan, ok := planArbitrage(pools, params, minSpreadBps); ok {
+			plan.decided, plan.executed = blockNum, blockNum+params.latencyBlocks
+			pending = &plan
+		}
+	}
+	//nothing changes the pools after the last block, so the last plan lands on the final state
+	if pending != nil {
+		execute()
+	}
+	return result
+}
+
+// readPoolHistory reads swaps of the venue and its reserves at the end of every block changing them.
+// Venues logging their reserves are read from logs, reserves of other venues are read at blocks with swaps
+func readPoolHistory(ctx context.Context, cfg *appConfig, chain *chainStruct, chainCfg chainConfig, venue venueStruct,
+	scan blockRange, initial poolReserves) (poolHistory, error) {
+
+	history := poolHistory{initial: initial, changes: make(map[uint64]poolReserves)}
+	logger, hasLogs := venue.adapter.(reservesLogger)
+	var swapBlocks []uint64
+	for chunkStart := scan.start; chunkStart <= scan.end; chunkStart += cfg.chunkBlocks {
+		chunkEnd := chunkStart + cfg.chunkBlocks - 1
+		if chunkEnd > scan.end {
+			chunkEnd = scan.end
+		}
+		fromBlock, toBlock := new(big.Int).SetUint64(chunkStart), new(big.Int).SetUint64(chunkEnd)
+		trades, err := getLogs(ctx, chain.client, venue.adapter, fromBlock, toBlock)
+		if err != nil {
+			return history, fmt.Errorf("%s blocks %d-%d: %w", venue.name, chunkStart, chunkEnd, err)
+		}
+		for blockNum, blockTrades := range trades {
+			history.swaps += len(blockTrades)
+			swapBlocks = append(swapBlocks, blockNum)
+		}
+		if !hasLogs {
+			continue
+		}
+
+		logs, err := chain.client.FilterLogs(ctx, logger.reservesFilter(fromBlock, toBlock))
+		if err != nil {
+			return history, fmt.Errorf("%s reserves of blocks %d-%d: %w", venue.name, chunkStart, chunkEnd, err)
+		}
+		//logs come in chain order, so the last log of a block holds its final reserves
+		for _, vLog := range logs {
+			if vLog.Removed {
+				continue
+			}
+			reserves, err := logger.decodeReserves(vLog)
+			if err != nil {
+				return history, err
+			}
+			history.changes[vLog.BlockNumber] = reserves
+		}
+	}
+	if hasLogs {
+		return history, nil
+	}
+
+	reserves, err := batchReserves(ctx, chain.rpcClient, venue.adapter, swapBlocks, chainCfg.fetch)
+	if err != nil {
+		return history, fmt.Errorf("%s reserves: %w", venue.name, err)
+	}
+	history.changes = reserves
+	return history, nil
+}
+
+// constantProductVenues splits venues of a pair into those priced by constant product curves and weighted pools
+// weighing the tokens unequally, which the backtest leaves out
+func constantProductVenues(ctx context.Context, venues []venueStruct) (kept, excluded []venueStruct, err error) {
+	for _, venue := range venues {
+		if weighted, ok := venue.adapter.(weightedPool); ok {
+			equal, err := weighted.equalWeights(ctx)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s weights: %w", venue.name, err)
+			}
+			if !equal {
+				excluded = append(excluded, venue)
+				continue
+			}
+		}
+		kept = append(kept, venue)
+	}
+	return kept, excluded, nil
+}
+
+// backtestChain replays every pair of the chain since targetTimestamp and writes trade logs and results to out
+func backtestChain(ctx context.Context, cfg *appConfig, chainCfg chainConfig, targetTimestamp uint64, out io.Writer) error {
+	profile := chainCfg.profile
+	fmt.Printf("[%s] Initializing DEX and tokens data\n", profile.name)
+	chain, err := initParams(ctx, chainCfg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[%s] Finding block number by timestamp\n", profile.name)
+	startBlock, err := getBlockByTimestamp(ctx, chain.client, chain.blockTimes, targetTimestamp)
+	if err != nil {
+		return err
+	}
+	head, err := chain.headBlock(ctx)
+	if err != nil {
+		return err
+	}
+	scan := blockRange{start: startBlock.Uint64(), end: finalBlock(head, cfg.confirmations)}
+
+	for _, pair := range chain.pairs {
+		base, quote := pair.tokens.baseQuote()
+		venues, excluded, err := constantProductVenues(ctx, pair.venues)
+		if err != nil {
+			return err
+		}
+		for _, venue := range excluded {
+			fmt.Printf("[%s] Warning: %s/%s on %s is left out, its pool does not weigh both tokens equally\n", profile.name, base, quote, venue.name)
+		}
+		fmt.Printf("[%s] Reading %s/%s swaps and reserves\n", profile.name, base, quote)
+		adapters := make([]venueAdapter, len(venues))
+		names := make([]string, len(venues))
+		fees := make([]float64, len(venues))
+		for i, venue := range venues {
+			adapters[i], names[i], fees[i] = venue.adapter, venue.name, venue.adapter.fee()
+		}
+		//the replay starts from reserves at the end of the block before the first one
+		initial, errs, err := poolsReserves(ctx, chain.rpcClient, chainCfg.fetch, adapters, new(big.Int).SetUint64(scan.start-1))
+		if err != nil {
+			return err
+		}
+		histories := make([]poolHistory, len(venues))
+		for i, venue := range venues {
+			if errs[i] != nil {
+				return fmt.Errorf("%s reserves at block %d: %w", venue.name, scan.start-1, errs[i])
+			}
+			histories[i], err = readPoolHistory(ctx, cfg, chain, chainCfg, venue, scan, initial[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		result := replayPair(pair.tokens, names, fees, histories, cfg.backtest, cfg.minSpreadBps)
+		var blockNums []uint64
+		for _, trade := range result.trades {
+			blockNums = append(blockNums, trade.decided)
+		}
+		blocksTime, err := getBlocksTime(ctx, chain.rpcClient, chain.blockTimes, blockNums, chainCfg.fetch)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "== %s: %s/%s on %s ==\n", profile.name, base, quote, strings.Join(names, ", "))
+		writeBacktest(out, cfg, scan, pair.tokens, result, blocksTime)
+	}
+	return nil
+}
+
+// writeBacktest prints the strategy, the trade log, the PnL curve and the summary of one pair
+func writeBacktest(out io.Writer, cfg *appConfig, scan blockRange, tokens tokenStruct, result backtestResult, blocksTime map[uint64]uint64) {
+	base, quote := tokens.baseQuote()
+	params := cfg.backtest
+	maxSize := "unlimited"
+	if params.maxSize > 0 {
+		maxSize = fmt.Sprintf("%.2f %s", params.maxSize, base)
+	}
+	fmt.Fprintf(out, "Blocks %d-%d: %d blocks with reserve changes, %d swaps\n", scan.start, scan.end, result.blocks, result.swaps)
+	fmt.Fprintf(out, "Strategy: min spread %.1f bps, max size %s, latency %d blocks, gas %d units at %.2f gwei\n",
+		cfg.minSpreadBps, maxSize, params.latencyBlocks, params.gasUnits, params.gasPriceGwei)
+	if len(result.trades) == 0 {
+		fmt.Fprintln(out, "No trades")
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	fmt.Fprintf(w, "Block\tTime\tLanded\tBuy on\tSell on\tSpread bps\tSize %s\tIn %s\tOut %s\tGas\tPnL\tCumulative\t\n", base, quote, quote)
+	for _, trade := range result.trades {
+		blockTime := time.Unix(int64(blocksTime[trade.decided]), 0).In(cfg.location)
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%.1f\t%.4f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n", trade.decided, blockTime.Format("2006-01-02 15:04:05 MST"),
+			trade.executed, trade.buyOn, trade.sellOn, trade.bps, trade.baseOut, trade.quoteIn, trade.quoteOut, trade.gas, trade.pnl, trade.cumulative)
+	}
+	w.Flush()
+
+	curve := make([]float64, 0, len(result.trades)+1)
+	curve = append(curve, 0)
+	low, high := 0.0, 0.0
+	for _, trade := range result.trades {
+		curve = append(curve, trade.cumulative)
+		low, high = math.Min(low, trade.cumulative), math.Max(high, trade.cumulative)
+	}
+	fmt.Fprintf(out, "PnL curve: %s\n", sparkline(curve, low, high))
+	fmt.Fprintf(out, "Trades: %d, wins: %d (%.1f%%), PnL: %.2f %s, gas: %.2f %s, max drawdown: %.2f %s\n",
+		len(result.trades), result.wins, float64(result.wins)/float64(len(result.trades))*100,
+		result.pnl, quote, result.gas, quote, result.maxDrawdown, quote)
+}
+
+// runBacktest replays every configured chain since the analysis start and prints results of the strategy
+func runBacktest(ctx context.Context, cfg *appConfig, skipValidate bool) {
+	if cfg.offline {
+		fatal("backtest reads reserves from RPC and can not run offline")
+	}
+	if !skipValidate {
+		fmt.Println("Validating configuration")
+		if !validateConfig(ctx, cfg, os.Stdout) {
+			fatal("Fix the problems above or run with -skip-validate")
+		}
+	}
+	targetTimestamp := analysisStart(cfg)
+	out, err := reportOutput(cfg)
+	if err != nil {
+		fatal(err)
+	}
+	if out != os.Stdout {
+		defer out.Close()
+	}
+
+	//chains are replayed concurrently, each one into its own buffer so that reports do not interleave
+	var wg sync.WaitGroup
+	reports := make([]bytes.Buffer, len(cfg.chains))
+	errs := make([]error, len(cfg.chains))
+	for i, chainCfg := range cfg.chains {
+		wg.Add(1)
+		go func(i int, chainCfg chainConfig) {
+			defer wg.Done()
+			errs[i] = backtestChain(ctx, cfg, chainCfg, targetTimestamp, &reports[i])
+		}(i, chainCfg)
+	}
+	wg.Wait()
+
+	failed := false
+	for i, chainCfg := range cfg.chains {
+		reports[i].WriteTo(out)
+		if errs[i] != nil && !interrupted(errs[i]) {
+			log.Printf("[%s] %v", chainCfg.profile.name, errs[i])
+			failed = true
+		}
+	}
+	if ctx.Err() != nil {
+		log.Print("Interrupted, results above are partial")
+		exit(130)
+	}
+	if failed {
+		exit(1)
+	}
+	if cfg.outputPath != "" {
+		fmt.Printf("Report written to %s\n", cfg.outputPath)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// near tells whether got equals want up to rounding of float arithmetic
+func near(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-9*math.Max(1, math.Abs(want))
+}
+
+func TestSwapCurve(t *testing.T) {
+	//USDC/WETH pools of 1700 and 1717 USDC per WETH
+	cheap, dear := simPool{fee: 0.003, base: 1000, quote: 1700000}, simPool{fee: 0.0025, base: 500, quote: 858500}
+	buy := newSwapCurve(cheap.quote, cheap.base, cheap.fee)
+	sell := newSwapCurve(dear.base, dear.quote, dear.fee)
+
+	tests := []struct {
+		name  string
+		curve swapCurve
+		//swap is the same trade made with amountOut, one pool at a time
+		swap func(amountIn float64) float64
+	}{
+		{name: "single swap", curve: buy, swap: func(amountIn float64) float64 {
+			return amountOut(amountIn, cheap.quote, cheap.base, cheap.fee)
+		}},
+		{name: "two swaps", curve: buy.then(sell), swap: func(amountIn float64) float64 {
+			baseOut := amountOut(amountIn, cheap.quote, cheap.base, cheap.fee)
+			return amountOut(baseOut, dear.base, dear.quote, dear.fee)
+		}},
+		{name: "round trip", curve: buy.then(newSwapCurve(cheap.base, cheap.quote, cheap.fee)), swap: func(amountIn float64) float64 {
+			baseOut := amountOut(amountIn, cheap.quote, cheap.base, cheap.fee)
+			return amountOut(baseOut, cheap.base, cheap.quote, cheap.fee)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, amountIn := range []float64{1, 1700, 250000, 1e9} {
+				out := tt.curve.out(amountIn)
+				if want := tt.swap(amountIn); !near(out, want) {
+					t.Errorf("out(%v): want %v, got %v", amountIn, want, out)
+				}
+				in, ok := tt.curve.in(out)
+				if !ok || !near(in, amountIn) {
+					t.Errorf("in(%v): want %v, got %v %v", out, amountIn, in, ok)
+				}
+			}
+			//the curve approaches a/c as the input grows, no input gives that much
+			if in, ok := tt.curve.in(tt.curve.a / tt.curve.c); ok {
+				t.Errorf("in of the limit: want not ok, got %v", in)
+			}
+		})
+	}
+}
+
+func TestPlanArbitrage(t *testing.T) {
+	low := simPool{name: "low", fee: 0.003, base: 1000, quote: 1700000}
+	high := simPool{name: "high", fee: 0.003, base: 1000, quote: 1750000}
+	profit := func(pools []simPool, plan arbPlan, quoteIn float64) float64 {
+		cheap, dear := pools[plan.buy], pools[plan.sell]
+		baseOut := amountOut(quoteIn, cheap.quote, cheap.base, cheap.fee)
+		return amountOut(baseOut, dear.base, dear.quote, dear.fee) - quoteIn
+	}
+
+	tests := []struct {
+		name         string
+		pools        []simPool
+		params       backtestParams
+		minSpreadBps float64
+		found        bool
+		buy, sell    int
+		//baseOut is the expected size of a limited arbitrage, 0 when the optimum is expected
+		baseOut float64
+	}{
+		{name: "equal prices", pools: []simPool{low, low}},
+		{name: "spread under the fees", pools: []simPool{low, {fee: 0.003, base: 1000, quote: 1705000}}},
+		{name: "spread under the minimum", pools: []simPool{low, high}, minSpreadBps: 300},
+		{name: "buy on the first pool", pools: []simPool{low, high}, found: true, buy: 0, sell: 1},
+		{name: "buy on the second pool", pools: []simPool{high, low}, found: true, buy: 1, sell: 0},
+		{name: "widest of three pools", pools: []simPool{high, {fee: 0.003, base: 1000, quote: 1730000}, low}, found: true, buy: 2, sell: 0},
+		{name: "limited size", pools: []simPool{low, high}, params: backtestParams{maxSize: 1}, found: true, buy: 0, sell: 1, baseOut: 1},
+		{name: "limit over the optimum", pools: []simPool{low, high}, params: backtestParams{maxSize: 100}, found: true, buy: 0, sell: 1},
+		{name: "gas over the profit", pools: []simPool{low, high}, params: backtestParams{gasUnits: 200000, gasPriceGwei: 1000, nativePrice: 1700}},
+		{name: "empty pool", pools: []simPool{low, {fee: 0.003}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, found := planArbitrage(tt.pools, tt.params, tt.minSpreadBps)
+			if found != tt.found {
+				t.Fatalf("want found %v, got %+v %v", tt.found, plan, found)
+			}
+			if !found {
+				return
+			}
+			if plan.buy != tt.buy || plan.sell != tt.sell {
+				t.Errorf("want buy on %d and sell on %d, got %+v", tt.buy, tt.sell, plan)
+			}
+			if want := profit(tt.pools, plan, plan.quoteIn) - tt.params.gasCost(); !near(plan.expectedProfit, want) {
+				t.Errorf("want expected profit %v, got %v", want, plan.expectedProfit)
+			}
+			cheap := tt.pools[plan.buy]
+			if tt.baseOut > 0 {
+				if baseOut := amountOut(plan.quoteIn, cheap.quote, cheap.base, cheap.fee); !near(baseOut, tt.baseOut) {
+					t.Errorf("want %v base bought, got %v", tt.baseOut, baseOut)
+				}
+				return
+			}
+			//a slightly smaller or larger input makes less
+			for _, quoteIn := range []float64{plan.quoteIn * 0.99, plan.quoteIn * 1.01} {
+				if p := profit(tt.pools, plan, quoteIn); p > profit(tt.pools, plan, plan.quoteIn) {
+					t.Errorf("input %v is not optimal, %v makes %v", plan.quoteIn, quoteIn, p)
+				}
+			}
+		})
+	}
+}
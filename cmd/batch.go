@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"dex-price-reader/contract-api/erc20"
+)
+
+const defaultBatchSize = 100
+
+// batchCall sends requests in batches of opts.batchSize, at most opts.workers batches at a time.
+// Failed requests are retried, errors of requests that still fail are left in their Error field.
+// Only cancellation of ctx is returned as an error
+func batchCall(ctx context.Context, client *rpc.Client, opts fetchOptions, elems []rpc.BatchElem) error {
+	batchSize := opts.batchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	var wg sync.WaitGroup
+	batches := make(chan []rpc.BatchElem)
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				sendBatch(ctx, client, opts, batch)
+			}
+		}()
+	}
+
+feed:
+	for start := 0; start < len(elems); start += batchSize {
+		end := start + batchSize
+		if end > len(elems) {
+			end = len(elems)
+		}
+		select {
+		case batches <- elems[start:end]:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(batches)
+	wg.Wait()
+	return ctx.Err()
+}
+
+// sendBatch sends the batch and then resends only the requests that failed with their own error,
+// a batch the RPC transport gave up on is not sent again
+func sendBatch(ctx context.Context, client *rpc.Client, opts fetchOptions, batch []rpc.BatchElem) {
+	pending := make([]*rpc.BatchElem, len(batch))
+	for i := range batch {
+		pending[i] = &batch[i]
+	}
+	withRetry(ctx, opts, func() error {
+		elems := make([]rpc.BatchElem, len(pending))
+		for i, elem := range pending {
+			elems[i] = *elem
+			elems[i].Error = nil
+		}
+		err := client.BatchCallContext(ctx, elems)
+		var failed []*rpc.BatchElem
+		for i, elem := range pending {
+			elem.Error = elems[i].Error
+			if err != nil {
+				//nothing has been answered when the batch itself fails
+				elem.Error = err
+			}
+			if elem.Error != nil {
+				failed = append(failed, elem)
+			}
+		}
+		pending = failed
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return fmt.Errorf("%d of the batch requests failed", len(pending))
+		}
+		return nil
+	})
+}
+
+// callElem is an eth_call request of the batch, nil blockNum reads the latest state
+func callElem(msg ethereum.CallMsg, blockNum *big.Int, result *hexutil.Bytes) rpc.BatchElem {
+	block := "latest"
+	if blockNum != nil {
+		block = hexutil.EncodeBig(blockNum)
+	}
+	arg := map[string]interface{}{"to": msg.To, "data": hexutil.Bytes(msg.Data)}
+	return rpc.BatchElem{Method: "eth_call", Args: []interface{}{arg, block}, Result: result}
+}
+
+// blockRef identifies a block by number and hash as reported by the node
+type blockRef struct {
+	Number     hexutil.Uint64 `json:"number"`
+	Hash       common.Hash    `json:"hash"`
+	ParentHash common.Hash    `json:"parentHash"`
+	Time       hexutil.Uint64 `json:"timestamp"`
+}
+
+// getBlockRefs reads number, hash and timestamp of the blocks in batches. Blocks read successfully
+// are returned even when some blocks failed, the error then lists the failed blocks
+func getBlockRefs(ctx context.Context, client *rpc.Client, blockNums []uint64, opts fetchOptions) (map[uint64]blockRef, error) {
+	refs := make([]*blockRef, len(blockNums))
+	elems := make([]rpc.BatchElem, len(blockNums))
+	for i, blockNum := range blockNums {
+		elems[i] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(blockNum), false},
+			Result: &refs[i],
+		}
+	}
+	if err := batchCall(ctx, client, opts, elems); err != nil {
+		return nil, err
+	}
+
+	blocks := make(map[uint64]blockRef, len(blockNums))
+	errs := make(blockErrors)
+	for i, blockNum := range blockNums {
+		switch {
+		case elems[i].Error != nil:
+			errs[blockNum] = elems[i].Error
+		case refs[i] == nil:
+			errs[blockNum] = ethereum.NotFound
+		default:
+			blocks[blockNum] = *refs[i]
+		}
+	}
+	if len(errs) > 0 {
+		return blocks, fmt.Errorf("block headers: %w", errs)
+	}
+	return blocks, nil
+}
+
+// getBlocksTime reads timestamps of the blocks that are not cached yet in batches.
+// Timestamps read successfully are returned even when some blocks failed, the error then lists the failed blocks
+func getBlocksTime(ctx context.Context, client *rpc.Client, cache *blockTimeCache, blockNums []uint64,
+	opts fetchOptions) (map[uint64]uint64, error) {
+
+	blocksTime := make(map[uint64]uint64, len(blockNums))
+	var missing []uint64
+	for _, blockNum := range blockNums {
+		if cached, ok := cache.get(blockNum); ok {
+			blocksTime[blockNum] = cached
+		} else {
+			missing = append(missing, blockNum)
+		}
+	}
+	if len(missing) == 0 {
+		return blocksTime, nil
+	}
+
+	refs, err := getBlockRefs(ctx, client, missing, opts)
+	for blockNum, ref := range refs {
+		cache.put(blockNum, uint64(ref.Time))
+		blocksTime[blockNum] = uint64(ref.Time)
+	}
+	return blocksTime, err
+}
+
+// poolReserves are balances of the pool tokens at some block
+type poolReserves struct {
+	reserve0 float64
+	reserve1 float64
+}
+
+// midPrice is the marginal price of the base token in the quote token, as returned by baseQuote.
+// It assumes equal token weights, which holds for constant product pools and 50/50 Balancer pools
+func (r poolReserves) midPrice(tokens tokenStruct) float64 {
+	base, quote := r.baseQuote(tokens)
+	if base == 0 {
+		return 0
+	}
+	return quote / base
+}
+
+// baseQuote returns reserves of the base and the quote token as ordered by tokenStruct.baseQuote
+func (r poolReserves) baseQuote(tokens tokenStruct) (base, quote float64) {
+	if tokens.tkn0Decimals > tokens.tkn1Decimals {
+		return r.reserve0, r.reserve1
+	}
+	return r.reserve1, r.reserve0
+}
+
+// batchReserves reads reserves of the venue pool at every given block
+func batchReserves(ctx context.Context, client *rpc.Client, venue venueAdapter, blockNums []uint64,
+	opts fetchOptions) (map[uint64]poolReserves, error) {
+
+	msg, decode := venue.reservesCall()
+	outputs := make([]hexutil.Bytes, len(blockNums))
+	elems := make([]rpc.BatchElem, len(blockNums))
+	for i, blockNum := range blockNums {
+		elems[i] = callElem(msg, new(big.Int).SetUint64(blockNum), &outputs[i])
+	}
+	if err := batchCall(ctx, client, opts, elems); err != nil {
+		return nil, err
+	}
+
+	reserves := make(map[uint64]poolReserves, len(blockNums))
+	errs := make(blockErrors)
+	for i, blockNum := range blockNums {
+		if elems[i].Error != nil {
+			errs[blockNum] = elems[i].Error
+			continue
+		}
+		reserve0, reserve1, err := decode(outputs[i])
+		if err != nil {
+			errs[blockNum] = err
+			continue
+		}
+		reserves[blockNum] = poolReserves{reserve0: reserve0, reserve1: reserve1}
+	}
+	if len(errs) > 0 {
+		return reserves, errs
+	}
+	return reserves, nil
+}
+
+// tokenMeta is what is needed to display token amounts
+type tokenMeta struct {
+	symbol   string
+	decimals uint8
+}
+
+// readTokenMeta reads symbols and decimals of all tokens in as few requests as possible
+func readTokenMeta(ctx context.Context, client *rpc.Client, tokenAddrs []common.Address,
+	opts fetchOptions) (map[common.Address]tokenMeta, error) {
+
+	erc20Abi, err := erc20.Erc20MetaData.GetAbi()
+	if err != nil {
+		return nil, err
+	}
+	methods := []string{"symbol", "decimals"}
+	calls := make([]*contractCall, 0, len(tokenAddrs)*len(methods))
+	for _, tokenAddr := range tokenAddrs {
+		for _, method := range methods {
+			data, err := erc20Abi.Pack(method)
+			if err != nil {
+				return nil, err
+			}
+			calls = append(calls, &contractCall{target: tokenAddr, data: data})
+		}
+	}
+	if err := readContracts(ctx, client, opts, nil, calls); err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[common.Address]tokenMeta, len(tokenAddrs))
+	for i, tokenAddr := range tokenAddrs {
+		var values [2][]interface{}
+		for j, method := range methods {
+			call := calls[i*len(methods)+j]
+			if call.err != nil {
+				return nil, fmt.Errorf("%s() of token %s: %w", method, tokenAddr.Hex(), call.err)
+			}
+			values[j], err = erc20Abi.Unpack(method, call.output)
+			if err != nil || len(values[j]) != 1 {
+				return nil, fmt.Errorf("%s() of token %s: cannot decode %s", method, tokenAddr.Hex(), hexutil.Encode(call.output))
+			}
+		}
+		symbol, ok0 := values[0][0].(string)
+		decimals, ok1 := values[1][0].(uint8)
+		if !ok0 || !ok1 {
+			return nil, errors.New("unexpected types of symbol() or decimals() of token " + tokenAddr.Hex())
+		}
+		tokens[tokenAddr] = tokenMeta{symbol: symbol, decimals: decimals}
+	}
+	return tokens, nil
+}
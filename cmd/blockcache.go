@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// blockTimeCache maps block numbers of one chain to their timestamps. It is kept in an append-only
+// file, one "number timestamp" line per block, so it is shared by all runs of the tool.
+// Only final blocks are cached, a reorg may replace newer blocks with ones of another timestamp
+type blockTimeCache struct {
+	mu    sync.Mutex
+	times map[uint64]uint64
+	file  *os.File
+	//confirmations makes blocks final, final is the latest final block of the highest head seen
+	confirmations uint64
+	final         uint64
+}
+
+// defaultCacheDir is the user cache directory, e.g. ~/.cache/dex-price-reader on Linux
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ".dex-price-reader-cache"
+	}
+	return filepath.Join(dir, "dex-price-reader")
+}
+
+// openBlockTimeCache loads cached timestamps of the chain from dir, empty dir disables persistence
+func openBlockTimeCache(dir string, chain string, confirmations uint64) (*blockTimeCache, error) {
+	cache := &blockTimeCache{times: make(map[uint64]uint64), confirmations: confirmations}
+	if dir == "" {
+		return cache, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, chain+".blocktimes"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var blockNum, blockTime uint64
+		//a line cut by a crash is simply skipped
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d", &blockNum, &blockTime); err == nil && blockTime > 0 {
+			cache.times[blockNum] = blockTime
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	cache.file = file
+	return cache, nil
+}
+
+func (c *blockTimeCache) get(blockNum uint64) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	blockTime, ok := c.times[blockNum]
+	return blockTime, ok
+}
+
+// observeHead makes blocks with enough confirmations below the head final, so that their timestamps are cached
+func (c *blockTimeCache) observeHead(head uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if final := finalBlock(head, c.confirmations); final > c.final {
+		c.final = final
+	}
+}
+
+// put caches the timestamp of a final block, timestamps of newer blocks are left out
+func (c *blockTimeCache) put(blockNum uint64, blockTime uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if blockNum > c.final {
+		return
+	}
+	if _, ok := c.times[blockNum]; ok {
+		return
+	}
+	c.times[blockNum] = blockTime
+	if c.file != nil {
+		//cache is an optimisation only, a failed write just means the header is fetched again next run
+		fmt.Fprintf(c.file, "%d %d\n", blockNum, blockTime)
+	}
+}
+
+// blockTime returns timestamp of the block from the cache or from its header. Headers are used
+// instead of full blocks as they do not carry transactions
+func blockTime(ctx context.Context, client *ethclient.Client, cache *blockTimeCache, blockNum uint64) (uint64, error) {
+	if blockTime, ok := cache.get(blockNum); ok {
+		return blockTime, nil
+	}
+	header, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(blockNum))
+	if err != nil {
+		return 0, err
+	}
+	cache.put(blockNum, header.Time)
+	return header.Time, nil
+}
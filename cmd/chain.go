@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// chainProfile describes a chain the analysis can run on. Chain specific settings
+// (RPC url, factories, tokens) are read from env variables starting with envPrefix
+type chainProfile struct {
+	name      string
+	envPrefix string
+	chainID   int64
+	//explorer link to a transaction, %s is replaced with the tx hash
+	explorerTxURL string
+}
+
+var chainProfiles = map[string]chainProfile{
+	"ethereum": {name: "ethereum", envPrefix: "ETH", chainID: 1, explorerTxURL: "https://etherscan.io/tx/%s"},
+	"arbitrum": {name: "arbitrum", envPrefix: "ARB", chainID: 42161, explorerTxURL: "https://arbiscan.io/tx/%s"},
+	"optimism": {name: "optimism", envPrefix: "OP", chainID: 10, explorerTxURL: "https://optimistic.etherscan.io/tx/%s"},
+	"polygon":  {name: "polygon", envPrefix: "POLYGON", chainID: 137, explorerTxURL: "https://polygonscan.com/tx/%s"},
+	"bsc":      {name: "bsc", envPrefix: "BSC", chainID: 56, explorerTxURL: "https://bscscan.com/tx/%s"},
+	"base":     {name: "base", envPrefix: "BASE", chainID: 8453, explorerTxURL: "https://basescan.org/tx/%s"},
+}
+
+var chainAliases = map[string]string{
+	"eth":     "ethereum",
+	"mainnet": "ethereum",
+	"arb":     "arbitrum",
+	"op":      "optimism",
+	"matic":   "polygon",
+	"bnb":     "bsc",
+}
+
+func chainNames() []string {
+	names := make([]string, 0, len(chainProfiles))
+	for name := range chainProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseChains converts comma separated list of chain names to profiles keeping the order given by user
+func parseChains(list string) ([]chainProfile, error) {
+	var profiles []chainProfile
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if alias, ok := chainAliases[name]; ok {
+			name = alias
+		}
+		profile, ok := chainProfiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown chain %q, supported chains: %s", name, strings.Join(chainNames(), ", "))
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		profiles = append(profiles, profile)
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no chain selected")
+	}
+	return profiles, nil
+}
+
+// getenv reads chain specific variable, e.g. getenv("TOKEN0") reads ARB_TOKEN0 for arbitrum
+func (p chainProfile) getenv(key string) string {
+	return os.Getenv(p.envPrefix + "_" + key)
+}
+
+func (p chainProfile) txLink(txHash string) string {
+	//synthetic route prices are not made by a transaction, they have no link
+	if txHash == (common.Hash{}).Hex() {
+		return ""
+	}
+	if p.explorerTxURL == "" {
+		return txHash
+	}
+	return fmt.Sprintf(p.explorerTxURL, txHash)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const defaultCheckpointPath = "dex-price-reader.checkpoint"
+
+// checkpointLine is one line of the checkpoint file. A line with start and end fixes the block range of a chain,
+// a line with pool records trades of a completed range of blocks of that pool
+type checkpointLine struct {
+	Chain  string            `json:"chain"`
+	Start  uint64            `json:"start,omitempty"`
+	End    uint64            `json:"end,omitempty"`
+	Pool   string            `json:"pool,omitempty"`
+	Done   uint64            `json:"done,omitempty"`
+	Trades []checkpointTrade `json:"trades,omitempty"`
+}
+
+type checkpointTrade struct {
+	Block     uint64      `json:"block"`
+	Price     float64     `json:"price"`
+	Size      float64     `json:"size"`
+	Side      swapSides   `json:"side"`
+	TxHash    common.Hash `json:"tx"`
+	LogIndex  uint        `json:"log"`
+	BlockHash common.Hash `json:"blockHash"`
+}
+
+// blockRange is the inclusive range of blocks scanned on a chain
+type blockRange struct {
+	start uint64
+	end   uint64
+}
+
+// poolProgress is what a resumed scan takes over from the checkpoint
+type poolProgress struct {
+	done   uint64
+	trades map[uint64][]tradeStruct
+}
+
+// scanCheckpoint is an append-only log of completed block ranges of a long scan,
+// so that an interrupted scan can be resumed without reading those blocks again
+type scanCheckpoint struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	ranges map[string]blockRange
+	pools  map[string]*poolProgress
+}
+
+// openCheckpoint starts a new checkpoint file or, with resume, continues the existing one. A checkpoint left by
+// an unfinished scan is only overwritten with fresh, so that a run without -resume does not lose its progress
+func openCheckpoint(path string, resume, fresh bool) (*scanCheckpoint, error) {
+	checkpoint := &scanCheckpoint{path: path, ranges: make(map[string]blockRange), pools: make(map[string]*poolProgress)}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_EXCL
+	//a scan failing before its first chunk leaves an empty checkpoint, which has no progress to keep
+	info, err := os.Stat(path)
+	empty := err == nil && info.Size() == 0
+	switch {
+	case fresh || (empty && !resume):
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	case resume:
+		if err := checkpoint.load(); err != nil {
+			return nil, err
+		}
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	//completed scans remove their checkpoint, so an existing one belongs to a scan that did not finish
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("checkpoint of an unfinished scan exists at %s, run with -resume to continue it or -fresh to start over", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.file = file
+	return checkpoint, nil
+}
+
+func (c *scanCheckpoint) load() error {
+	file, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no checkpoint to resume at %s", c.path)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var line checkpointLine
+		//the last line may be cut if the process was killed while writing it, that range is read again
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			break
+		}
+		if line.Pool == "" {
+			c.ranges[line.Chain] = blockRange{start: line.Start, end: line.End}
+			continue
+		}
+		progress := c.progress(line.Chain, line.Pool)
+		progress.done = line.Done
+		for _, trade := range line.Trades {
+			progress.trades[trade.Block] = append(progress.trades[trade.Block], tradeStruct{
+				price: trade.Price, size: trade.Size, swapSide: trade.Side,
+				txHash: trade.TxHash, logIndex: trade.LogIndex, blockHash: trade.BlockHash,
+			})
+		}
+	}
+	return scanner.Err()
+}
+
+func (c *scanCheckpoint) progress(chain, pool string) *poolProgress {
+	key := chain + " " + pool
+	progress, ok := c.pools[key]
+	if !ok {
+		progress = &poolProgress{trades: make(map[uint64][]tradeStruct)}
+		c.pools[key] = progress
+	}
+	return progress
+}
+
+// chainRange returns the block range of the chain fixed by an earlier run
+func (c *scanCheckpoint) chainRange(chain string) (blockRange, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rng, ok := c.ranges[chain]
+	return rng, ok
+}
+
+// startChain fixes the block range of the chain, a resumed scan must end at the same block to give the same output
+func (c *scanCheckpoint) startChain(chain string, rng blockRange) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ranges[chain] = rng
+	return c.write(checkpointLine{Chain: chain, Start: rng.start, End: rng.end})
+}
+
+// poolProgress returns the last completed block of the pool and trades read up to it
+func (c *scanCheckpoint) poolProgress(chain, pool string) poolProgress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.progress(chain, pool)
+}
+
+// saveRange records trades of the pool read up to block done
+func (c *scanCheckpoint) saveRange(chain, pool string, done uint64, trades map[uint64][]tradeStruct) error {
+	line := checkpointLine{Chain: chain, Pool: pool, Done: done}
+	for blockNum, blockTrades := range trades {
+		for _, trade := range blockTrades {
+			line.Trades = append(line.Trades, checkpointTrade{
+				Block: blockNum, Price: trade.price, Size: trade.size, Side: trade.swapSide,
+				TxHash: trade.txHash, LogIndex: trade.logIndex, BlockHash: trade.blockHash,
+			})
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(line)
+}
+
+func (c *scanCheckpoint) write(line checkpointLine) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return c.file.Sync()
+}
+
+func (c *scanCheckpoint) close() error {
+	return c.file.Close()
+}
+
+// remove deletes the checkpoint of a completed scan
+func (c *scanCheckpoint) remove() error {
+	c.close()
+	return os.Remove(c.path)
+}
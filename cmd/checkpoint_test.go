@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// writeCheckpoint records a scan of blocks 100-300 of ethereum interrupted after block 200 of pool 0x1
+func writeCheckpoint(t *testing.T, path string) {
+	checkpoint, err := openCheckpoint(path, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoint.close()
+	if err := checkpoint.startChain("ethereum", blockRange{start: 100, end: 300}); err != nil {
+		t.Fatal(err)
+	}
+	saves := []struct {
+		done   uint64
+		trades map[uint64][]tradeStruct
+	}{
+		{done: 150, trades: map[uint64][]tradeStruct{120: {{price: 1700, size: 2, swapSide: buy, txHash: common.HexToHash("0x1"), logIndex: 3}}}},
+		{done: 200, trades: map[uint64][]tradeStruct{180: {{price: 1710, size: 1, swapSide: sell, txHash: common.HexToHash("0x2")}}}},
+	}
+	for _, save := range saves {
+		if err := checkpoint.saveRange("ethereum", "0x1", save.done, save.trades); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	tests := []struct {
+		name string
+		//setup writes the checkpoint file, if any
+		setup   func(t *testing.T, path string)
+		resume  bool
+		fresh   bool
+		wantErr bool
+		//ranged tells whether the chain range is taken over, done and blocks are the pool progress
+		ranged bool
+		done   uint64
+		blocks []uint64
+	}{
+		{name: "resumed", setup: writeCheckpoint, resume: true, ranged: true, done: 200, blocks: []uint64{120, 180}},
+		{name: "last line cut", setup: func(t *testing.T, path string) {
+			writeCheckpoint(t, path)
+			file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			file.WriteString(`{"chain":"ethereum","pool":"0x1","done":250,"tra`)
+		}, resume: true, ranged: true, done: 200, blocks: []uint64{120, 180}},
+		{name: "resumed twice", setup: func(t *testing.T, path string) {
+			writeCheckpoint(t, path)
+			checkpoint, err := openCheckpoint(path, true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer checkpoint.close()
+			checkpoint.saveRange("ethereum", "0x1", 300, map[uint64][]tradeStruct{250: {{price: 1720, size: 1}}})
+		}, resume: true, ranged: true, done: 300, blocks: []uint64{120, 180, 250}},
+		{name: "nothing to resume", resume: true, wantErr: true},
+		{name: "unfinished scan not overwritten", setup: writeCheckpoint, wantErr: true},
+		{name: "started over", setup: writeCheckpoint, fresh: true},
+		{name: "first scan"},
+		{name: "empty checkpoint of a failed scan", setup: func(t *testing.T, path string) {
+			if err := os.WriteFile(path, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "checkpoint")
+			if tt.setup != nil {
+				tt.setup(t, path)
+			}
+			checkpoint, err := openCheckpoint(path, tt.resume, tt.fresh)
+			if tt.wantErr {
+				if err == nil {
+					checkpoint.close()
+					t.Fatal("want error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer checkpoint.close()
+
+			rng, ok := checkpoint.chainRange("ethereum")
+			if ok != tt.ranged || (ok && rng != blockRange{start: 100, end: 300}) {
+				t.Errorf("want range 100-300 %v, got %+v %v", tt.ranged, rng, ok)
+			}
+			progress := checkpoint.poolProgress("ethereum", "0x1")
+			if progress.done != tt.done || len(progress.trades) != len(tt.blocks) {
+				t.Fatalf("want done %d with trades of blocks %v, got %+v", tt.done, tt.blocks, progress)
+			}
+			for _, blockNum := range tt.blocks {
+				if len(progress.trades[blockNum]) != 1 {
+					t.Errorf("want a trade of block %d, got %+v", blockNum, progress.trades)
+				}
+			}
+			if tt.resume {
+				if trade := progress.trades[120][0]; trade.price != 1700 || trade.swapSide != buy || trade.logIndex != 3 || trade.txHash != common.HexToHash("0x1") {
+					t.Errorf("want the trade as it was saved, got %+v", trade)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,961 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	//time zones do not depend on the zoneinfo database of the host
+	_ "time/tzdata"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultConfigPath = "dex-price-reader.yaml"
+
+// fileConfig is the layout of the YAML configuration file. Values are kept as strings
+// and checked by validate, so that errors can name the exact field
+type fileConfig struct {
+	Chains   map[string]fileChainConfig `yaml:"chains"`
+	Analysis fileAnalysisConfig         `yaml:"analysis"`
+	Output   fileOutputConfig           `yaml:"output"`
+	Store    fileStoreConfig            `yaml:"store"`
+	Cache    fileCacheConfig            `yaml:"cache"`
+	RPC      fileRPCConfig              `yaml:"rpc"`
+	Follow   fileFollowConfig           `yaml:"follow"`
+	Serve    fileServeConfig            `yaml:"serve"`
+	Alerts   fileAlertsConfig           `yaml:"alerts"`
+	Backtest fileBacktestConfig         `yaml:"backtest"`
+	Cycles   fileCyclesConfig           `yaml:"cycles"`
+}
+
+type fileChainConfig struct {
+	RPC      string           `yaml:"rpc"`
+	Explorer string           `yaml:"explorer"`
+	Dexes    []fileDexConfig  `yaml:"dexes"`
+	Pairs    []filePairConfig `yaml:"pairs"`
+	//endpoints used in turn when rpc fails or rate limits requests
+	FallbackRPCs []string `yaml:"fallback_rpcs"`
+	//address of Multicall3 if it is not deployed at the canonical address
+	Multicall string `yaml:"multicall"`
+}
+
+type fileDexConfig struct {
+	Name    string  `yaml:"name"`
+	Type    string  `yaml:"type"`
+	Factory string  `yaml:"factory"`
+	Vault   string  `yaml:"vault"`
+	PoolID  string  `yaml:"pool_id"`
+	Fee     float64 `yaml:"fee"`
+}
+
+type filePairConfig struct {
+	Token0 string `yaml:"token0"`
+	Token1 string `yaml:"token1"`
+	//pool ids of pool based venues (Balancer) by dex name, override pool_id of the dex
+	PoolIDs map[string]string `yaml:"pool_ids"`
+	//Routes price the pair on dexes without its pool, each lists tokens traded through on the way from token0 to token1
+	Routes [][]string `yaml:"routes"`
+}
+
+type fileAnalysisConfig struct {
+	Chains       []string `yaml:"chains"`
+	Hours        int64    `yaml:"hours"`
+	CrossChain   bool     `yaml:"crosschain"`
+	Bucket       string   `yaml:"bucket"`
+	MinSpreadBps float64  `yaml:"min_spread_bps"`
+	Offline      bool     `yaml:"offline"`
+	//blocks are final after this many confirmations, newer blocks may still be reorged
+	Confirmations *uint64 `yaml:"confirmations"`
+	Checkpoint    string  `yaml:"checkpoint"`
+	Resume        bool    `yaml:"resume"`
+	Fresh         bool    `yaml:"fresh"`
+}
+
+type fileFollowConfig struct {
+	Poll string `yaml:"poll"`
+	//address of the Prometheus /metrics endpoint, empty disables it
+	Metrics string `yaml:"metrics"`
+}
+
+type fileServeConfig struct {
+	//address of the HTTP API, port 0 picks a free one
+	Listen string `yaml:"listen"`
+}
+
+type fileBacktestConfig struct {
+	//MaxSize limits base token bought by one arbitrage, 0 is unlimited
+	MaxSize float64 `yaml:"max_size"`
+	//LatencyBlocks is how many blocks after the spread is seen the trade lands
+	LatencyBlocks *uint64 `yaml:"latency_blocks"`
+	GasUnits      uint64  `yaml:"gas_units"`
+	GasPriceGwei  float64 `yaml:"gas_price_gwei"`
+	//NativePrice converts gas to the quote token, it is required when gas has a price
+	NativePrice float64 `yaml:"native_price"`
+}
+
+type fileCyclesConfig struct {
+	//MaxHops is the longest cycle searched, 3 finds triangular cycles
+	MaxHops int `yaml:"max_hops"`
+}
+
+type fileAlertsConfig struct {
+	//cooldown of rules not setting their own
+	Cooldown string          `yaml:"cooldown"`
+	Rules    []fileAlertRule `yaml:"rules"`
+	Sinks    []fileAlertSink `yaml:"sinks"`
+}
+
+type fileAlertRule struct {
+	Name     string  `yaml:"name"`
+	Type     string  `yaml:"type"`
+	Chain    string  `yaml:"chain"`
+	Pair     string  `yaml:"pair"`
+	Dex      string  `yaml:"dex"`
+	MinBps   float64 `yaml:"min_bps"`
+	Blocks   int     `yaml:"blocks"`
+	MinSize  float64 `yaml:"min_size"`
+	DropPct  float64 `yaml:"drop_pct"`
+	Cooldown string  `yaml:"cooldown"`
+	//names of sinks, all sinks when empty
+	Sinks []string `yaml:"sinks"`
+}
+
+type fileAlertSink struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	URL     string   `yaml:"url"`
+	Command []string `yaml:"command"`
+	Path    string   `yaml:"path"`
+}
+
+type fileStoreConfig struct {
+	Path        string `yaml:"path"`
+	ChunkBlocks uint64 `yaml:"chunk_blocks"`
+}
+
+type fileRPCConfig struct {
+	Concurrency int    `yaml:"concurrency"`
+	Retries     *int   `yaml:"retries"`
+	Backoff     string `yaml:"backoff"`
+	BatchSize   int    `yaml:"batch_size"`
+	Multicall   *bool  `yaml:"multicall"`
+	//requests per second per endpoint, 0 is unlimited
+	RateLimit float64 `yaml:"rate_limit"`
+	Burst     int     `yaml:"burst"`
+}
+
+type fileCacheConfig struct {
+	//pointer tells not configured directory from explicitly disabled (empty) one
+	Dir *string `yaml:"dir"`
+}
+
+type fileOutputConfig struct {
+	Format string `yaml:"format"`
+	//IANA time zone of report timestamps, e.g. Europe/London
+	Timezone string `yaml:"timezone"`
+	Links    *bool  `yaml:"links"`
+	//File receives the report instead of stdout
+	File string `yaml:"file"`
+}
+
+// appConfig is validated configuration used by the rest of the tool
+type appConfig struct {
+	chains       []chainConfig
+	hours        int64
+	crossChain   bool
+	bucket       time.Duration
+	minSpreadBps float64
+	format       string
+	outputPath   string
+	links        bool
+	location     *time.Location
+	offline      bool
+	storePath    string
+	chunkBlocks  uint64
+	//confirmations makes blocks final, see fileAnalysisConfig
+	confirmations  uint64
+	pollInterval   time.Duration
+	checkpointPath string
+	resume         bool
+	fresh          bool
+	listenAddr     string
+	metricsAddr    string
+	alertRules     []alertRule
+	backtest       backtestParams
+	maxHops        int
+}
+
+type chainConfig struct {
+	profile      chainProfile
+	rpcURL       string
+	fallbackURLs []string
+	rateLimit    float64
+	rateBurst    int
+	cacheDir     string
+	//confirmations makes blocks final, timestamps of newer blocks are not cached
+	confirmations uint64
+	fetch         fetchOptions
+	dexes         []venueConfig
+	pairs         []pairConfig
+}
+
+type pairConfig struct {
+	token0  common.Address
+	token1  common.Address
+	poolIDs map[string]common.Hash
+	//routes are full paths from one token of the pair to the other, as configured
+	routes [][]common.Address
+}
+
+// venueFor returns settings of the dex for the given pair, pair level pool id takes precedence
+func (p pairConfig) venueFor(dex venueConfig) venueConfig {
+	if poolID, ok := p.poolIDs[dex.name]; ok {
+		dex.poolID = poolID
+	}
+	return dex
+}
+
+// configError lists all problems found in configuration, one per line
+type configError []string
+
+func (e configError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e, "\n  ")
+}
+
+// loadConfig reads configuration with the following precedence (highest first):
+// command line flags, environment variables, config file, legacy .env file
+func loadConfig(flags *flag.FlagSet, configPath string) (*appConfig, error) {
+	var (
+		fc  fileConfig
+		err error
+	)
+	setFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	//.env is still loaded when config file exists as it is a convenient place for RPC keys
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("reading .env: %w", err)
+	}
+
+	data, err := os.ReadFile(configPath)
+	switch {
+	case err == nil:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&fc); err != nil {
+			return nil, fmt.Errorf("%s: %w", configPath, err)
+		}
+	case errors.Is(err, fs.ErrNotExist) && !setFlags["config"]:
+		//no config file, fall back to flat ETH_* style variables
+		fc = legacyEnvConfig()
+		if len(fc.Chains) == 0 {
+			return nil, fmt.Errorf("no configuration found: create %s or a .env file", defaultConfigPath)
+		}
+	default:
+		return nil, err
+	}
+
+	envProblems := applyEnvOverrides(&fc)
+	applyFlagOverrides(&fc, flags, setFlags)
+
+	cfg, err := fc.validate()
+	//variables that can not be parsed are reported together with problems of the config
+	if len(envProblems) > 0 {
+		var problems configError
+		errors.As(err, &problems)
+		problems = append(problems, envProblems...)
+		sort.Strings(problems)
+		return nil, problems
+	}
+	return cfg, err
+}
+
+// legacyEnvConfig builds configuration from <PREFIX>_* variables of every chain having an RPC address
+func legacyEnvConfig() fileConfig {
+	fc := fileConfig{Chains: make(map[string]fileChainConfig)}
+	for _, name := range chainNames() {
+		profile := chainProfiles[name]
+		if profile.getenv("APIADDRESS") == "" {
+			continue
+		}
+		chain := fileChainConfig{
+			Pairs: []filePairConfig{{Token0: profile.getenv("TOKEN0"), Token1: profile.getenv("TOKEN1")}},
+		}
+		//dexes are numbered from 0 without gaps: DEX0_, DEX1_, DEX2_...
+		for i := 0; ; i++ {
+			prefix := fmt.Sprintf("DEX%d_", i)
+			if profile.getenv(prefix+"NAME") == "" && profile.getenv(prefix+"FACTORY") == "" && profile.getenv(prefix+"TYPE") == "" {
+				break
+			}
+			dex := fileDexConfig{
+				Name:    profile.getenv(prefix + "NAME"),
+				Type:    profile.getenv(prefix + "TYPE"),
+				Factory: profile.getenv(prefix + "FACTORY"),
+				Vault:   profile.getenv(prefix + "VAULT"),
+				PoolID:  profile.getenv(prefix + "POOLID"),
+			}
+			if fee := profile.getenv(prefix + "FEE"); fee != "" {
+				//invalid fee is reported by validate as a negative value can never be set by user
+				dex.Fee = -1
+				if value, err := strconv.ParseFloat(fee, 64); err == nil {
+					dex.Fee = value
+				}
+			}
+			chain.Dexes = append(chain.Dexes, dex)
+		}
+		fc.Chains[name] = chain
+	}
+	if len(fc.Chains) == 1 {
+		for name := range fc.Chains {
+			fc.Analysis.Chains = []string{name}
+		}
+	}
+	return fc
+}
+
+// applyEnvOverrides lets environment replace individual fields of the config file.
+// It returns a problem for every numeric variable that is set but can not be parsed
+func applyEnvOverrides(fc *fileConfig) configError {
+	var problems configError
+	if fc.Chains == nil {
+		fc.Chains = make(map[string]fileChainConfig)
+	}
+	for name, profile := range chainProfiles {
+		chain, ok := fc.Chains[name]
+		if rpc := profile.getenv("APIADDRESS"); rpc != "" {
+			chain.RPC = rpc + profile.getenv("APPKEY")
+			ok = true
+		}
+		if fallbacks := profile.getenv("FALLBACK_RPCS"); fallbacks != "" {
+			chain.FallbackRPCs = strings.Split(fallbacks, ",")
+			ok = true
+		}
+		if explorer := profile.getenv("EXPLORER"); explorer != "" {
+			chain.Explorer = explorer
+		}
+		if ok {
+			fc.Chains[name] = chain
+		}
+	}
+	if chains := os.Getenv("DPR_CHAINS"); chains != "" {
+		fc.Analysis.Chains = strings.Split(chains, ",")
+	}
+	if value := os.Getenv("DPR_HOURS"); value != "" {
+		if hours, err := strconv.ParseInt(value, 10, 64); err == nil {
+			fc.Analysis.Hours = hours
+		} else {
+			problems = append(problems, fmt.Sprintf("DPR_HOURS: %q is not a whole number of hours", value))
+		}
+	}
+	if value := os.Getenv("DPR_CONFIRMATIONS"); value != "" {
+		if confirmations, err := strconv.ParseUint(value, 10, 64); err == nil {
+			fc.Analysis.Confirmations = &confirmations
+		} else {
+			problems = append(problems, fmt.Sprintf("DPR_CONFIRMATIONS: %q is not a number of blocks", value))
+		}
+	}
+	if bucket := os.Getenv("DPR_BUCKET"); bucket != "" {
+		fc.Analysis.Bucket = bucket
+	}
+	if value := os.Getenv("DPR_MIN_SPREAD_BPS"); value != "" {
+		if minSpread, err := strconv.ParseFloat(value, 64); err == nil {
+			fc.Analysis.MinSpreadBps = minSpread
+		} else {
+			problems = append(problems, fmt.Sprintf("DPR_MIN_SPREAD_BPS: %q is not a number of basis points", value))
+		}
+	}
+	if timezone := os.Getenv("DPR_TZ"); timezone != "" {
+		fc.Output.Timezone = timezone
+	}
+	if format := os.Getenv("DPR_FORMAT"); format != "" {
+		fc.Output.Format = format
+	}
+	if outputPath := os.Getenv("DPR_OUT"); outputPath != "" {
+		fc.Output.File = outputPath
+	}
+	if metrics, ok := os.LookupEnv("DPR_METRICS"); ok {
+		fc.Follow.Metrics = metrics
+	}
+	if listen := os.Getenv("DPR_LISTEN"); listen != "" {
+		fc.Serve.Listen = listen
+	}
+	if storePath := os.Getenv("DPR_STORE"); storePath != "" {
+		fc.Store.Path = storePath
+	}
+	if cacheDir, ok := os.LookupEnv("DPR_CACHE_DIR"); ok {
+		fc.Cache.Dir = &cacheDir
+	}
+	return problems
+}
+
+// applyFlagOverrides copies explicitly set command line flags over config values
+func applyFlagOverrides(fc *fileConfig, flags *flag.FlagSet, setFlags map[string]bool) {
+	value := func(name string) string {
+		return flags.Lookup(name).Value.String()
+	}
+	if setFlags["chain"] {
+		fc.Analysis.Chains = strings.Split(value("chain"), ",")
+	}
+	if setFlags["hours"] {
+		fc.Analysis.Hours, _ = strconv.ParseInt(value("hours"), 10, 64)
+	}
+	if setFlags["crosschain"] {
+		fc.Analysis.CrossChain, _ = strconv.ParseBool(value("crosschain"))
+	}
+	if setFlags["bucket"] {
+		fc.Analysis.Bucket = value("bucket")
+	}
+	if setFlags["min-spread"] {
+		fc.Analysis.MinSpreadBps, _ = strconv.ParseFloat(value("min-spread"), 64)
+	}
+	if setFlags["format"] {
+		fc.Output.Format = value("format")
+	}
+	if setFlags["tz"] {
+		fc.Output.Timezone = value("tz")
+	}
+	if setFlags["out"] {
+		fc.Output.File = value("out")
+	}
+	if setFlags["links"] {
+		links, _ := strconv.ParseBool(value("links"))
+		fc.Output.Links = &links
+	}
+	if setFlags["concurrency"] {
+		fc.RPC.Concurrency, _ = strconv.Atoi(value("concurrency"))
+	}
+	if setFlags["batch-size"] {
+		fc.RPC.BatchSize, _ = strconv.Atoi(value("batch-size"))
+	}
+	if setFlags["confirmations"] {
+		confirmations, _ := strconv.ParseUint(value("confirmations"), 10, 64)
+		fc.Analysis.Confirmations = &confirmations
+	}
+	if setFlags["checkpoint"] {
+		fc.Analysis.Checkpoint = value("checkpoint")
+	}
+	if setFlags["resume"] {
+		fc.Analysis.Resume, _ = strconv.ParseBool(value("resume"))
+	}
+	if setFlags["fresh"] {
+		fc.Analysis.Fresh, _ = strconv.ParseBool(value("fresh"))
+	}
+	if setFlags["poll"] {
+		fc.Follow.Poll = value("poll")
+	}
+	if setFlags["metrics"] {
+		fc.Follow.Metrics = value("metrics")
+	}
+	if setFlags["listen"] {
+		fc.Serve.Listen = value("listen")
+	}
+	if setFlags["multicall"] {
+		multicall, _ := strconv.ParseBool(value("multicall"))
+		fc.RPC.Multicall = &multicall
+	}
+	if setFlags["rate-limit"] {
+		fc.RPC.RateLimit, _ = strconv.ParseFloat(value("rate-limit"), 64)
+	}
+	if setFlags["cache-dir"] {
+		cacheDir := value("cache-dir")
+		fc.Cache.Dir = &cacheDir
+	}
+	if setFlags["offline"] {
+		fc.Analysis.Offline, _ = strconv.ParseBool(value("offline"))
+	}
+	if setFlags["store"] {
+		fc.Store.Path = value("store")
+	}
+	if setFlags["chunk"] {
+		fc.Store.ChunkBlocks, _ = strconv.ParseUint(value("chunk"), 10, 64)
+	}
+	if setFlags["max-size"] {
+		fc.Backtest.MaxSize, _ = strconv.ParseFloat(value("max-size"), 64)
+	}
+	if setFlags["latency"] {
+		latency, _ := strconv.ParseUint(value("latency"), 10, 64)
+		fc.Backtest.LatencyBlocks = &latency
+	}
+	if setFlags["gas-price"] {
+		fc.Backtest.GasPriceGwei, _ = strconv.ParseFloat(value("gas-price"), 64)
+	}
+	if setFlags["max-hops"] {
+		fc.Cycles.MaxHops, _ = strconv.Atoi(value("max-hops"))
+	}
+}
+
+// validate checks every field and converts configuration to its runtime form.
+// All problems are reported at once so that user can fix them in one go
+func (fc fileConfig) validate() (*appConfig, error) {
+	var problems configError
+	addProblem := func(field string, format string, args ...interface{}) {
+		problems = append(problems, field+": "+fmt.Sprintf(format, args...))
+	}
+
+	cfg := &appConfig{
+		hours:          fc.Analysis.Hours,
+		crossChain:     fc.Analysis.CrossChain,
+		bucket:         5 * time.Minute,
+		minSpreadBps:   fc.Analysis.MinSpreadBps,
+		format:         "table",
+		links:          true,
+		offline:        fc.Analysis.Offline,
+		storePath:      defaultStorePath,
+		chunkBlocks:    defaultChunkBlocks,
+		confirmations:  defaultConfirmations,
+		pollInterval:   defaultPollInterval,
+		checkpointPath: defaultCheckpointPath,
+		resume:         fc.Analysis.Resume,
+		fresh:          fc.Analysis.Fresh,
+		listenAddr:     defaultListenAddr,
+		maxHops:        defaultMaxHops,
+		backtest: backtestParams{
+			maxSize:       fc.Backtest.MaxSize,
+			latencyBlocks: defaultLatencyBlocks,
+			gasUnits:      defaultGasUnits,
+			gasPriceGwei:  fc.Backtest.GasPriceGwei,
+			nativePrice:   fc.Backtest.NativePrice,
+		},
+	}
+	if fc.Follow.Metrics != "" {
+		if _, _, err := net.SplitHostPort(fc.Follow.Metrics); err != nil {
+			addProblem("follow.metrics", "must be host:port like 127.0.0.1:9100 or :9100, got %q", fc.Follow.Metrics)
+		}
+		cfg.metricsAddr = fc.Follow.Metrics
+	}
+	if fc.Serve.Listen != "" {
+		if _, _, err := net.SplitHostPort(fc.Serve.Listen); err != nil {
+			addProblem("serve.listen", "must be host:port like 127.0.0.1:8080 or :8080, got %q", fc.Serve.Listen)
+		}
+		cfg.listenAddr = fc.Serve.Listen
+	}
+	if cfg.backtest.maxSize < 0 {
+		addProblem("backtest.max_size", "must not be negative, got %v", cfg.backtest.maxSize)
+	}
+	if fc.Backtest.LatencyBlocks != nil {
+		if *fc.Backtest.LatencyBlocks == 0 {
+			addProblem("backtest.latency_blocks", "must be at least 1, a trade can land in the next block at the earliest")
+		}
+		cfg.backtest.latencyBlocks = *fc.Backtest.LatencyBlocks
+	}
+	if fc.Backtest.GasUnits > 0 {
+		cfg.backtest.gasUnits = fc.Backtest.GasUnits
+	}
+	if cfg.backtest.gasPriceGwei < 0 {
+		addProblem("backtest.gas_price_gwei", "must not be negative, got %v", cfg.backtest.gasPriceGwei)
+	}
+	if cfg.backtest.nativePrice < 0 {
+		addProblem("backtest.native_price", "must not be negative, got %v", cfg.backtest.nativePrice)
+	} else if cfg.backtest.nativePrice == 0 && cfg.backtest.gasPriceGwei > 0 {
+		addProblem("backtest.native_price", "must be set to the price of the gas token in the quote token when gas_price_gwei is set")
+	}
+	if fc.Cycles.MaxHops != 0 {
+		if fc.Cycles.MaxHops < minCycleHops {
+			addProblem("cycles.max_hops", "must be at least %d, got %d", minCycleHops, fc.Cycles.MaxHops)
+		}
+		cfg.maxHops = fc.Cycles.MaxHops
+	}
+	if fc.Analysis.Resume && fc.Analysis.Fresh {
+		addProblem("analysis.fresh", "cannot be combined with resume, a scan is either resumed or started over")
+	}
+	if fc.Analysis.Checkpoint != "" {
+		cfg.checkpointPath = fc.Analysis.Checkpoint
+	}
+	if fc.Analysis.Confirmations != nil {
+		cfg.confirmations = *fc.Analysis.Confirmations
+	}
+	if fc.Follow.Poll != "" {
+		poll, err := time.ParseDuration(fc.Follow.Poll)
+		if err != nil || poll <= 0 {
+			addProblem("follow.poll", "must be a positive duration like 12s, got %q", fc.Follow.Poll)
+		}
+		cfg.pollInterval = poll
+	}
+	if fc.Store.Path != "" {
+		cfg.storePath = fc.Store.Path
+	}
+	if fc.Store.ChunkBlocks > 0 {
+		cfg.chunkBlocks = fc.Store.ChunkBlocks
+	}
+	cacheDir := defaultCacheDir()
+	if fc.Cache.Dir != nil {
+		cacheDir = *fc.Cache.Dir
+	}
+	fetch := fetchOptions{workers: defaultFetchWorkers, retries: defaultFetchRetries, backoff: defaultFetchBackoff, batchSize: defaultBatchSize}
+	if fc.RPC.BatchSize != 0 {
+		if fc.RPC.BatchSize < 0 {
+			addProblem("rpc.batch_size", "must be a positive number of requests, got %d", fc.RPC.BatchSize)
+		}
+		fetch.batchSize = fc.RPC.BatchSize
+	}
+	if fc.RPC.Concurrency != 0 {
+		if fc.RPC.Concurrency < 0 {
+			addProblem("rpc.concurrency", "must be a positive number of requests, got %d", fc.RPC.Concurrency)
+		}
+		fetch.workers = fc.RPC.Concurrency
+	}
+	if fc.RPC.Retries != nil {
+		if *fc.RPC.Retries < 0 {
+			addProblem("rpc.retries", "must not be negative, got %d", *fc.RPC.Retries)
+		}
+		fetch.retries = *fc.RPC.Retries
+	}
+	if fc.RPC.Backoff != "" {
+		backoff, err := time.ParseDuration(fc.RPC.Backoff)
+		if err != nil || backoff <= 0 {
+			addProblem("rpc.backoff", "must be a positive duration like 500ms, got %q", fc.RPC.Backoff)
+		}
+		fetch.backoff = backoff
+	}
+	if fc.RPC.RateLimit < 0 {
+		addProblem("rpc.rate_limit", "must not be negative, got %v", fc.RPC.RateLimit)
+	}
+	rateBurst := fc.RPC.Burst
+	if rateBurst < 0 {
+		addProblem("rpc.burst", "must not be negative, got %d", rateBurst)
+	}
+	if rateBurst == 0 {
+		//a bucket smaller than one request would never let anything through
+		rateBurst = int(math.Max(1, math.Ceil(fc.RPC.RateLimit)))
+	}
+	if cfg.hours < 0 {
+		addProblem("analysis.hours", "must be a positive number of hours, got %d", cfg.hours)
+	}
+	if fc.Analysis.Bucket != "" {
+		bucket, err := time.ParseDuration(fc.Analysis.Bucket)
+		if err != nil || bucket <= 0 {
+			addProblem("analysis.bucket", "must be a positive duration like 5m or 1h, got %q", fc.Analysis.Bucket)
+		}
+		cfg.bucket = bucket
+	}
+	if cfg.minSpreadBps < 0 {
+		addProblem("analysis.min_spread_bps", "must not be negative, got %v", cfg.minSpreadBps)
+	}
+	if fc.Output.Format != "" {
+		cfg.format = strings.ToLower(fc.Output.Format)
+	}
+	if !outputFormats[cfg.format] {
+		addProblem("output.format", "unknown format %q, supported formats: %s", fc.Output.Format, strings.Join(outputFormatNames(), ", "))
+	}
+	if cfg.format == "html" && cfg.crossChain {
+		addProblem("output.format", "html report is not available for cross-chain comparison")
+	}
+	cfg.outputPath = fc.Output.File
+	cfg.location = time.UTC
+	if fc.Output.Timezone != "" {
+		location, err := time.LoadLocation(fc.Output.Timezone)
+		if err != nil {
+			addProblem("output.timezone", "unknown time zone %q, use an IANA name like UTC or Europe/London", fc.Output.Timezone)
+		} else {
+			cfg.location = location
+		}
+	}
+	if fc.Output.Links != nil {
+		cfg.links = *fc.Output.Links
+	}
+
+	cfg.alertRules = fc.Alerts.validate(addProblem)
+
+	for name := range fc.Chains {
+		if _, ok := chainProfiles[name]; !ok {
+			addProblem("chains."+name, "unknown chain, supported chains: %s", strings.Join(chainNames(), ", "))
+		}
+	}
+
+	selected := fc.Analysis.Chains
+	if len(selected) == 0 {
+		selected = []string{"ethereum"}
+	}
+	profiles, err := parseChains(strings.Join(selected, ","))
+	if err != nil {
+		addProblem("analysis.chains", "%v", err)
+	}
+	if cfg.crossChain && len(profiles) < 2 {
+		addProblem("analysis.crosschain", "needs at least two chains in analysis.chains")
+	}
+
+	for _, profile := range profiles {
+		field := "chains." + profile.name
+		fileChain, ok := fc.Chains[profile.name]
+		if !ok {
+			addProblem(field, "chain is selected for analysis but not configured")
+			continue
+		}
+		chain := chainConfig{profile: profile, rpcURL: os.ExpandEnv(fileChain.RPC), cacheDir: cacheDir, fetch: fetch,
+			rateLimit: fc.RPC.RateLimit, rateBurst: rateBurst, confirmations: cfg.confirmations}
+		//offline analysis reads the local store only
+		if chain.rpcURL == "" && !cfg.offline {
+			addProblem(field+".rpc", "is required (or set %s_APIADDRESS)", profile.envPrefix)
+		}
+		if fc.RPC.Multicall == nil || *fc.RPC.Multicall {
+			chain.fetch.multicall = multicall3Addr
+			if fileChain.Multicall != "" {
+				if !common.IsHexAddress(fileChain.Multicall) {
+					addProblem(field+".multicall", "not a valid address %q", fileChain.Multicall)
+				}
+				chain.fetch.multicall = common.HexToAddress(fileChain.Multicall)
+			}
+		}
+		for i, fallback := range fileChain.FallbackRPCs {
+			fallback = os.ExpandEnv(strings.TrimSpace(fallback))
+			if !strings.HasPrefix(fallback, "http://") && !strings.HasPrefix(fallback, "https://") {
+				addProblem(fmt.Sprintf("%s.fallback_rpcs[%d]", field, i), "must be an http(s) url")
+			}
+			chain.fallbackURLs = append(chain.fallbackURLs, fallback)
+		}
+		if len(chain.fallbackURLs) > 0 && chain.rpcURL != "" && !strings.HasPrefix(chain.rpcURL, "http") {
+			addProblem(field+".rpc", "must be an http(s) url when fallback_rpcs are set")
+		}
+		if fileChain.Explorer != "" {
+			if strings.Count(fileChain.Explorer, "%s") != 1 {
+				addProblem(field+".explorer", "must contain exactly one %%s for the tx hash, got %q", fileChain.Explorer)
+			}
+			chain.profile.explorerTxURL = fileChain.Explorer
+		}
+
+		if len(fileChain.Dexes) < 2 {
+			addProblem(field+".dexes", "at least two dexes are needed to compare prices, got %d", len(fileChain.Dexes))
+		}
+		dexNames := make(map[string]bool)
+		for i, fileDex := range fileChain.Dexes {
+			dexField := fmt.Sprintf("%s.dexes[%d]", field, i)
+			dex := venueConfig{name: fileDex.Name, kind: strings.ToLower(fileDex.Type), fee: fileDex.Fee}
+			if dex.name == "" {
+				addProblem(dexField+".name", "is required")
+			} else if dexNames[dex.name] {
+				addProblem(dexField+".name", "duplicate dex name %q", dex.name)
+			}
+			dexNames[dex.name] = true
+			if dex.kind == "" {
+				dex.kind = venueTypeUniswapV2
+			}
+			if _, ok := venueTypes[dex.kind]; !ok {
+				addProblem(dexField+".type", "unknown dex type %q, supported types: %s", fileDex.Type, strings.Join(venueTypeNames(), ", "))
+			}
+			if dex.fee < 0 || dex.fee >= 1 {
+				addProblem(dexField+".fee", "must be a fraction between 0 and 1, e.g. 0.003")
+			}
+			if fileDex.Factory != "" {
+				if !common.IsHexAddress(fileDex.Factory) {
+					addProblem(dexField+".factory", "not a valid address %q", fileDex.Factory)
+				}
+				dex.factory = common.HexToAddress(fileDex.Factory)
+			} else if dex.kind != venueTypeBalancer {
+				addProblem(dexField+".factory", "is required for %s dexes", dex.kind)
+			}
+			if fileDex.Vault != "" {
+				if !common.IsHexAddress(fileDex.Vault) {
+					addProblem(dexField+".vault", "not a valid address %q", fileDex.Vault)
+				}
+				dex.vault = common.HexToAddress(fileDex.Vault)
+			}
+			if fileDex.PoolID != "" {
+				if !isHexHash(fileDex.PoolID) {
+					addProblem(dexField+".pool_id", "not a valid 32 byte hex value %q", fileDex.PoolID)
+				}
+				dex.poolID = common.HexToHash(fileDex.PoolID)
+			}
+			chain.dexes = append(chain.dexes, dex)
+		}
+
+		if len(fileChain.Pairs) == 0 {
+			addProblem(field+".pairs", "at least one pair is required")
+		}
+		for i, filePair := range fileChain.Pairs {
+			pairField := fmt.Sprintf("%s.pairs[%d]", field, i)
+			pair := pairConfig{poolIDs: make(map[string]common.Hash)}
+			if !common.IsHexAddress(filePair.Token0) {
+				addProblem(pairField+".token0", "not a valid address %q", filePair.Token0)
+			}
+			if !common.IsHexAddress(filePair.Token1) {
+				addProblem(pairField+".token1", "not a valid address %q", filePair.Token1)
+			}
+			//pools order tokens by address, so do we
+			pair.token0, pair.token1 = common.HexToAddress(filePair.Token0), common.HexToAddress(filePair.Token1)
+			if pair.token0 == pair.token1 {
+				addProblem(pairField, "token0 and token1 must be different")
+			}
+			if bytes.Compare(pair.token0.Bytes(), pair.token1.Bytes()) > 0 {
+				pair.token0, pair.token1 = pair.token1, pair.token0
+			}
+			for dexName, poolID := range filePair.PoolIDs {
+				if !dexNames[dexName] {
+					addProblem(pairField+".pool_ids."+dexName, "no dex with this name")
+				}
+				if !isHexHash(poolID) {
+					addProblem(pairField+".pool_ids."+dexName, "not a valid 32 byte hex value %q", poolID)
+				}
+				pair.poolIDs[dexName] = common.HexToHash(poolID)
+			}
+			for j, via := range filePair.Routes {
+				routeField := fmt.Sprintf("%s.routes[%d]", pairField, j)
+				if len(via) == 0 {
+					addProblem(routeField, "must list at least one token to trade through")
+				}
+				path := []common.Address{common.HexToAddress(filePair.Token0)}
+				for _, token := range via {
+					if !common.IsHexAddress(token) {
+						addProblem(routeField, "not a valid address %q", token)
+					}
+					path = append(path, common.HexToAddress(token))
+				}
+				path = append(path, common.HexToAddress(filePair.Token1))
+				seen := make(map[common.Address]bool)
+				for _, tokenAddr := range path {
+					if seen[tokenAddr] {
+						addProblem(routeField, "token %s is traded through twice", tokenAddr.Hex())
+					}
+					seen[tokenAddr] = true
+				}
+				pair.routes = append(pair.routes, path)
+			}
+			for j, dex := range chain.dexes {
+				if dex.kind == venueTypeBalancer && pair.venueFor(dex).poolID == (common.Hash{}) {
+					addProblem(pairField, "balancer dex %s (dexes[%d]) needs pool_id or pairs[%d].pool_ids.%s", dex.name, j, i, dex.name)
+				}
+			}
+			chain.pairs = append(chain.pairs, pair)
+		}
+		cfg.chains = append(cfg.chains, chain)
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, problems
+	}
+	return cfg, nil
+}
+
+// validate converts alert rules and sinks, every rule gets the sinks it names or all of them
+func (fa fileAlertsConfig) validate(addProblem func(field string, format string, args ...interface{})) []alertRule {
+	cooldown := defaultAlertCooldown
+	if fa.Cooldown != "" {
+		var err error
+		cooldown, err = time.ParseDuration(fa.Cooldown)
+		if err != nil || cooldown < 0 {
+			addProblem("alerts.cooldown", "must be a duration like 10m, got %q", fa.Cooldown)
+		}
+	}
+
+	var sinks []alertSink
+	sinkByName := make(map[string]alertSink)
+	for i, sinkCfg := range fa.Sinks {
+		field := fmt.Sprintf("alerts.sinks[%d]", i)
+		if sinkCfg.Name == "" {
+			addProblem(field+".name", "is required")
+		} else if _, ok := sinkByName[sinkCfg.Name]; ok {
+			addProblem(field+".name", "duplicate sink name %q", sinkCfg.Name)
+		}
+		var sink alertSink
+		switch strings.ToLower(sinkCfg.Type) {
+		case "webhook":
+			url := os.ExpandEnv(sinkCfg.URL)
+			if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+				addProblem(field+".url", "must be an http(s) url")
+			}
+			sink = webhookSink(sinkCfg.Name, url)
+		case "command":
+			if len(sinkCfg.Command) == 0 {
+				addProblem(field+".command", "must list the program and its arguments")
+				continue
+			}
+			sink = commandSink(sinkCfg.Name, sinkCfg.Command)
+		case "file":
+			if sinkCfg.Path == "" {
+				addProblem(field+".path", "is required")
+			}
+			sink = fileSink(sinkCfg.Name, sinkCfg.Path)
+		default:
+			addProblem(field+".type", "unknown sink type %q, supported types: command, file, webhook", sinkCfg.Type)
+			continue
+		}
+		sinks = append(sinks, sink)
+		sinkByName[sinkCfg.Name] = sink
+	}
+
+	var rules []alertRule
+	ruleNames := make(map[string]bool)
+	for i, fileRule := range fa.Rules {
+		field := fmt.Sprintf("alerts.rules[%d]", i)
+		rule := alertRule{name: fileRule.Name, kind: strings.ToLower(fileRule.Type), chain: fileRule.Chain, pair: fileRule.Pair, dex: fileRule.Dex,
+			minBps: fileRule.MinBps, blocks: fileRule.Blocks, minSize: fileRule.MinSize, dropPct: fileRule.DropPct, cooldown: cooldown, sinks: sinks}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule%d", i)
+		}
+		if ruleNames[rule.name] {
+			addProblem(field+".name", "duplicate rule name %q", rule.name)
+		}
+		ruleNames[rule.name] = true
+		if rule.chain != "" {
+			if _, ok := chainProfiles[strings.ToLower(rule.chain)]; !ok {
+				addProblem(field+".chain", "unknown chain %q, supported chains: %s", rule.chain, strings.Join(chainNames(), ", "))
+			}
+		}
+		if rule.pair != "" && strings.Count(rule.pair, "/") != 1 {
+			addProblem(field+".pair", "must be BASE/QUOTE like WETH/USDC, got %q", rule.pair)
+		}
+		switch rule.kind {
+		case alertSpread:
+			if rule.minBps <= 0 {
+				addProblem(field+".min_bps", "must be a positive number of bps for %s rules", rule.kind)
+			}
+			if rule.blocks == 0 {
+				rule.blocks = 1
+			}
+			if rule.blocks < 0 {
+				addProblem(field+".blocks", "must be a positive number of consecutive blocks, got %d", rule.blocks)
+			}
+			if rule.dex != "" {
+				addProblem(field+".dex", "spread rules compare all dexes of the pair")
+			}
+		case alertSwapSize:
+			if rule.minSize <= 0 {
+				addProblem(field+".min_size", "must be a positive size in the base token for %s rules", rule.kind)
+			}
+		case alertReservesDrop:
+			if rule.dropPct <= 0 || rule.dropPct > 100 {
+				addProblem(field+".drop_pct", "must be a percentage between 0 and 100 for %s rules", rule.kind)
+			}
+		default:
+			addProblem(field+".type", "unknown rule type %q, supported types: %s, %s, %s", fileRule.Type, alertReservesDrop, alertSpread, alertSwapSize)
+		}
+		if fileRule.Cooldown != "" {
+			ruleCooldown, err := time.ParseDuration(fileRule.Cooldown)
+			if err != nil || ruleCooldown < 0 {
+				addProblem(field+".cooldown", "must be a duration like 10m, got %q", fileRule.Cooldown)
+			}
+			rule.cooldown = ruleCooldown
+		}
+		if len(fileRule.Sinks) > 0 {
+			rule.sinks = nil
+			for _, name := range fileRule.Sinks {
+				sink, ok := sinkByName[name]
+				if !ok {
+					addProblem(field+".sinks", "no sink named %q", name)
+					continue
+				}
+				rule.sinks = append(rule.sinks, sink)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// isHexHash checks that s is a 0x prefixed 32 byte value, e.g. a Balancer pool id
+func isHexHash(s string) bool {
+	b, err := hexutil.Decode(s)
+	return err == nil && len(b) == common.HashLength
+}
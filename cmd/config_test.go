@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+chains:
+  ethereum:
+    rpc: http://yaml.example
+    dexes:
+      - {name: Sushiswap, factory: "0xC0AEe478e3658e2610c5F7A4A2E1777cE9e4f2Ac"}
+      - {name: Uniswap, factory: "0x5C69bEe701ef814a2B6a3EDD4B1652CB9cc5aA6f"}
+    pairs:
+      - {token0: "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", token1: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"}
+analysis: {hours: 3, min_spread_bps: 10}
+`
+
+// writeConfig writes a config file to a temporary directory and returns its path
+func writeConfig(t *testing.T, config string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testFlags parses args with the override flags of main
+func testFlags(t *testing.T, args ...string) *flag.FlagSet {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.String("chain", "ethereum", "")
+	flags.Int64("hours", 0, "")
+	flags.Float64("min-spread", 0, "")
+	flags.String("format", "table", "")
+	flags.Bool("links", true, "")
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+// clearEnv unsets variables read by loadConfig for the duration of the test
+func clearEnv(t *testing.T) {
+	for _, name := range []string{"ETH_APIADDRESS", "ETH_APPKEY", "ETH_EXPLORER", "DPR_CHAINS", "DPR_HOURS", "DPR_CONFIRMATIONS", "DPR_BUCKET", "DPR_MIN_SPREAD_BPS", "DPR_FORMAT"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		args      []string
+		hours     int64
+		minSpread float64
+		rpcURL    string
+		links     bool
+	}{
+		{name: "config file", hours: 3, minSpread: 10, rpcURL: "http://yaml.example", links: true},
+		{name: "environment over file", env: map[string]string{"DPR_HOURS": "5", "ETH_APIADDRESS": "http://env.example/", "ETH_APPKEY": "key"},
+			hours: 5, minSpread: 10, rpcURL: "http://env.example/key", links: true},
+		{name: "flags over environment", env: map[string]string{"DPR_HOURS": "5", "DPR_MIN_SPREAD_BPS": "20"}, args: []string{"-hours", "7", "-links=false"},
+			hours: 7, minSpread: 20, rpcURL: "http://yaml.example"},
+		{name: "flag set to its default", env: map[string]string{"DPR_HOURS": "5"}, args: []string{"-hours", "0"}, minSpread: 10, rpcURL: "http://yaml.example", links: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cfg, err := loadConfig(testFlags(t, tt.args...), writeConfig(t, testConfig))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.hours != tt.hours || cfg.minSpreadBps != tt.minSpread || cfg.links != tt.links {
+				t.Errorf("want hours %d, min spread %v, links %v, got %d, %v, %v", tt.hours, tt.minSpread, tt.links, cfg.hours, cfg.minSpreadBps, cfg.links)
+			}
+			if len(cfg.chains) != 1 || cfg.chains[0].rpcURL != tt.rpcURL {
+				t.Errorf("want ethereum at %s, got %+v", tt.rpcURL, cfg.chains)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		env    map[string]string
+		//problems are parts of the error, one per expected problem
+		problems []string
+	}{
+		{name: "valid", config: testConfig},
+		{name: "bad analysis", config: testConfig + "output: {format: xml}\n", problems: []string{`output.format: unknown format "xml"`}},
+		{name: "bad fields of a chain", config: `
+chains:
+  ethereum:
+    explorer: https://etherscan.io/tx/
+    dexes:
+      - {name: Uniswap, factory: "0x5C69bEe701ef814a2B6a3EDD4B1652CB9cc5aA6f", fee: 1.5}
+      - {name: Uniswap, type: curve}
+    pairs:
+      - {token0: "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", token1: "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2", pool_ids: {Balancer: "0x12"}}
+analysis: {hours: -1, bucket: 5, crosschain: true}
+`, problems: []string{
+			"analysis.hours: must be a positive number",
+			"analysis.bucket: must be a positive duration",
+			"analysis.crosschain: needs at least two chains",
+			"chains.ethereum.rpc: is required",
+			"chains.ethereum.explorer: must contain exactly one %s",
+			"chains.ethereum.dexes[0].fee: must be a fraction",
+			`chains.ethereum.dexes[1].name: duplicate dex name "Uniswap"`,
+			`chains.ethereum.dexes[1].type: unknown dex type "curve"`,
+			"chains.ethereum.dexes[1].factory: is required for curve dexes",
+			"chains.ethereum.pairs[0]: token0 and token1 must be different",
+			"chains.ethereum.pairs[0].pool_ids.Balancer: no dex with this name",
+			"chains.ethereum.pairs[0].pool_ids.Balancer: not a valid 32 byte hex value",
+		}},
+		{name: "unknown chain", config: strings.Replace(testConfig, "chains:\n", "chains:\n  fantom: {}\n", 1), problems: []string{"chains.fantom: unknown chain"}},
+		{name: "selected chain not configured", config: strings.Replace(testConfig, "analysis: {", "analysis: {chains: [arbitrum], ", 1),
+			problems: []string{"chains.arbitrum: chain is selected for analysis but not configured"}},
+		{name: "invalid environment", config: testConfig, env: map[string]string{"DPR_HOURS": "3h", "DPR_CONFIRMATIONS": "-1", "DPR_MIN_SPREAD_BPS": "ten"},
+			problems: []string{
+				`DPR_HOURS: "3h" is not a whole number of hours`,
+				`DPR_CONFIRMATIONS: "-1" is not a number of blocks`,
+				`DPR_MIN_SPREAD_BPS: "ten" is not a number of basis points`,
+			}},
+		{name: "invalid environment and config", config: testConfig + "output: {format: xml}\n", env: map[string]string{"DPR_HOURS": "3h"},
+			problems: []string{`DPR_HOURS: "3h" is not a whole number of hours`, `output.format: unknown format "xml"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cfg, err := loadConfig(testFlags(t), writeConfig(t, tt.config))
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				pair := cfg.chains[0].pairs[0]
+				if pair.token0.Hex() != "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48" {
+					t.Errorf("want token0 of the lower address, got %s", pair.token0.Hex())
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("want problems %v, got none", tt.problems)
+			}
+			for _, problem := range tt.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("want problem %q, got\n%v", problem, err)
+				}
+			}
+			if got := strings.Count(err.Error(), "\n  "); got != len(tt.problems) {
+				t.Errorf("want %d problems, got %d:\n%v", len(tt.problems), got, err)
+			}
+		})
+	}
+}
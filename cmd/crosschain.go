@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// chainPrices holds volume weighted average price of the pair on one chain per time bucket
+type chainPrices struct {
+	profile chainProfile
+	base    string
+	quote   string
+	//bucket start (unix time) -> price
+	prices map[int64]float64
+}
+
+// collectChainPrices averages prices of all DEXes of the chain over wall-clock time buckets,
+// as block numbers of different chains are unrelated. One series is returned per configured pair
+func collectChainPrices(chain *chainTrades, bucket time.Duration) ([]*chainPrices, error) {
+	var series []*chainPrices
+	for _, pair := range chain.pairs {
+		trades := make(map[uint64][]tradeStruct)
+		for _, venue := range pair.venues {
+			for blockNum, blockTrades := range venue.trades {
+				trades[blockNum] = append(trades[blockNum], blockTrades...)
+			}
+		}
+
+		blockNums := make([]uint64, 0, len(trades))
+		for blockNum := range trades {
+			blockNums = append(blockNums, blockNum)
+		}
+		blocksTime, err := chain.blocksTime(blockNums)
+		if err != nil && !interrupted(err) {
+			return nil, err
+		}
+
+		var (
+			notional = make(map[int64]float64)
+			volume   = make(map[int64]float64)
+		)
+		for blockNum, blockTrades := range trades {
+			//blocks with unknown time can not be placed on the timeline
+			if blocksTime[blockNum] == 0 {
+				continue
+			}
+			bucketStart := time.Unix(int64(blocksTime[blockNum]), 0).Truncate(bucket).Unix()
+			for _, trade := range blockTrades {
+				if trade.swapSide == flash || trade.synthetic() || trade.size <= 0 || math.IsInf(trade.price, 0) || math.IsNaN(trade.price) {
+					continue
+				}
+				notional[bucketStart] += trade.price * trade.size
+				volume[bucketStart] += trade.size
+			}
+		}
+
+		base, quote := pair.tokens.baseQuote()
+		prices := &chainPrices{profile: chain.profile, base: base, quote: quote, prices: make(map[int64]float64)}
+		for bucketStart, bucketVolume := range volume {
+			if bucketVolume > 0 {
+				prices.prices[bucketStart] = notional[bucketStart] / bucketVolume
+			}
+		}
+		series = append(series, prices)
+	}
+	return series, nil
+}
+
+// analyseCrossChain lines up the same pairs on several chains by time and prints price difference per bucket.
+// Pairs are matched by their position in the pairs list of every chain
+func analyseCrossChain(cfg *appConfig, chains []*chainTrades, out io.Writer) error {
+	var wg sync.WaitGroup
+	chainSeries := make([][]*chainPrices, len(chains))
+	errs := make([]error, len(chains))
+	for i, chain := range chains {
+		wg.Add(1)
+		go func(i int, chain *chainTrades) {
+			defer wg.Done()
+			chainSeries[i], errs[i] = collectChainPrices(chain, cfg.bucket)
+		}(i, chain)
+	}
+	wg.Wait()
+
+	for i, chain := range chains {
+		if errs[i] != nil {
+			return fmt.Errorf("[%s] %w", chain.profile.name, errs[i])
+		}
+	}
+
+	for pairIndex := range chainSeries[0] {
+		var series []*chainPrices
+		for _, pairs := range chainSeries {
+			if pairIndex < len(pairs) {
+				series = append(series, pairs[pairIndex])
+			}
+		}
+		alignOrientation(series, out)
+		logCrossChainPrices(out, series, cfg.bucket, cfg.location)
+	}
+	return nil
+}
+
+// alignOrientation makes prices of all chains quoted the same way as on the first chain.
+// Bridged tokens may have different decimals (e.g. USDC on BSC), so orientation is aligned by token symbols.
+// Pairs that cannot be aligned are reported to out, above their comparison
+func alignOrientation(series []*chainPrices, out io.Writer) {
+	reference := series[0]
+	for _, chain := range series[1:] {
+		switch {
+		case chain.base == reference.base && chain.quote == reference.quote:
+		case chain.base == reference.quote && chain.quote == reference.base:
+			for bucketStart, price := range chain.prices {
+				chain.prices[bucketStart] = 1 / price
+			}
+			chain.base, chain.quote = chain.quote, chain.base
+		default:
+			fmt.Fprintf(out, "[%s] Warning: pair %s/%s does not match %s/%s on %s\n", chain.profile.name,
+				chain.base, chain.quote, reference.base, reference.quote, reference.profile.name)
+		}
+	}
+}
+
+func logCrossChainPrices(out io.Writer, series []*chainPrices, bucket time.Duration, location *time.Location) {
+	bucketSet := make(map[int64]bool)
+	for _, chain := range series {
+		for bucketStart := range chain.prices {
+			bucketSet[bucketStart] = true
+		}
+	}
+	buckets := make([]int64, 0, len(bucketSet))
+	for bucketStart := range bucketSet {
+		buckets = append(buckets, bucketStart)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	fmt.Fprintf(out, "== %s/%s cross-chain, %s buckets (%s) ==\n", series[0].base, series[0].quote, bucket, location)
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	header := []string{"Time"}
+	for _, chain := range series {
+		header = append(header, chain.profile.name)
+	}
+	header = append(header, "Diff bps")
+	fmt.Fprintln(w, strings.Join(header, "\t")+"\t")
+
+	var (
+		compared int
+		diffSum  float64
+		diffMax  float64
+		maxAt    int64
+	)
+	for _, bucketStart := range buckets {
+		row := []string{time.Unix(bucketStart, 0).In(location).Format("2006-01-02 15:04")}
+		minPrice, maxPrice := math.Inf(1), math.Inf(-1)
+		quoted := 0
+		for _, chain := range series {
+			price, ok := chain.prices[bucketStart]
+			if !ok {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, fmt.Sprintf("%.2f", price))
+			minPrice, maxPrice = math.Min(minPrice, price), math.Max(maxPrice, price)
+			quoted++
+		}
+		//difference makes sense only when the pair has traded on at least two chains within the bucket
+		if quoted < 2 {
+			row = append(row, "")
+		} else {
+			diff := (maxPrice - minPrice) / minPrice * 10000
+			row = append(row, fmt.Sprintf("%.1f", diff))
+			compared++
+			diffSum += diff
+			if diff > diffMax {
+				diffMax, maxAt = diff, bucketStart
+			}
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t")+"\t")
+	}
+	w.Flush()
+
+	if compared == 0 {
+		fmt.Fprintln(out, "No time buckets with trades on two or more chains")
+		return
+	}
+	fmt.Fprintf(out, "Buckets compared: %d, average diff: %.1f bps, max diff: %.1f bps at %s\n", compared,
+		diffSum/float64(compared), diffMax, time.Unix(maxAt, 0).In(location).Format("2006-01-02 15:04"))
+}
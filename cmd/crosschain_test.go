@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAlignOrientation(t *testing.T) {
+	tests := []struct {
+		name        string
+		base, quote string
+		wantPrice   float64
+		wantWarning string
+	}{
+		{name: "same orientation", base: "WETH", quote: "USDC", wantPrice: 1600},
+		{name: "inverted", base: "USDC", quote: "WETH", wantPrice: 1 / 1600.0},
+		{name: "other pair", base: "WBTC", quote: "USDC", wantPrice: 1600,
+			wantWarning: "[bsc] Warning: pair WBTC/USDC does not match WETH/USDC on ethereum\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reference := &chainPrices{profile: chainProfile{name: "ethereum"}, base: "WETH", quote: "USDC", prices: map[int64]float64{0: 1600}}
+			chain := &chainPrices{profile: chainProfile{name: "bsc"}, base: tt.base, quote: tt.quote, prices: map[int64]float64{0: 1600}}
+			var out bytes.Buffer
+			alignOrientation([]*chainPrices{reference, chain}, &out)
+			if got := chain.prices[0]; got != tt.wantPrice {
+				t.Errorf("want price %v, got %v", tt.wantPrice, got)
+			}
+			if out.String() != tt.wantWarning {
+				t.Errorf("want warning %q, got %q", tt.wantWarning, out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	//defaultMaxHops finds triangular cycles like WETH→USDC→DAI→WETH
+	defaultMaxHops = 3
+	//minCycleHops leaves out two pool cycles of the same pair, they are the spreads shown by analysis
+	minCycleHops = 3
+)
+
+// graphPool is a pool of the token graph, it trades in both directions
+type graphPool struct {
+	venue    venueStruct
+	tokens   tokenStruct
+	from, to int
+	fee      float64
+	reserves poolReserves
+	history  poolHistory
+}
+
+// graphEdge is one direction of a pool, weight is -log of the marginal rate after the fee
+type graphEdge struct {
+	pool     int
+	from, to int
+	weight   float64
+}
+
+// tokenGraph has configured tokens as nodes and pools of every DEX trading any two of them as edges
+type tokenGraph struct {
+	symbols []string
+	pools   []graphPool
+	//edges by the token they start from
+	edges [][]graphEdge
+}
+
+// arbCycle is a profitable sequence of swaps starting and ending with the same token
+type arbCycle struct {
+	edges []graphEdge
+	//rate is the marginal output of one input token after fees
+	rate   float64
+	input  float64
+	profit float64
+}
+
+// reserves returns reserves of the token going into and coming out of the edge's pool
+func (g *tokenGraph) reserves(edge graphEdge) (in, out float64) {
+	pool := g.pools[edge.pool]
+	if edge.from == pool.from {
+		return pool.reserves.reserve0, pool.reserves.reserve1
+	}
+	return pool.reserves.reserve1, pool.reserves.reserve0
+}
+
+// weigh sets weights of all edges from the current reserves, edges of empty pools can not be traded
+func (g *tokenGraph) weigh() {
+	for _, edges := range g.edges {
+		for i, edge := range edges {
+			in, out := g.reserves(edge)
+			edges[i].weight = math.Inf(1)
+			if in > 0 && out > 0 {
+				edges[i].weight = -math.Log((1 - g.pools[edge.pool].fee) * out / in)
+			}
+		}
+	}
+}
+
+// findCycles searches cycles of up to maxHops pools whose rate beats the minimum spread, depth first
+// from every token. A cycle is found only from its lowest token, so that its rotations are not repeated
+func (g *tokenGraph) findCycles(maxHops int, minSpreadBps float64) []arbCycle {
+	//a cycle is profitable at the margin if the sum of -log rates is negative
+	threshold := -math.Log1p(minSpreadBps / 10000)
+	var (
+		cycles   []arbCycle
+		path     []graphEdge
+		usedPool = make(map[int]bool)
+		visited  = make(map[int]bool)
+	)
+	var search func(start, token int, weight float64)
+	search = func(start, token int, weight float64) {
+		for _, edge := range g.edges[token] {
+			if usedPool[edge.pool] || math.IsInf(edge.weight, 1) || edge.to < start {
+				continue
+			}
+			if edge.to == start {
+				if len(path)+1 >= minCycleHops && weight+edge.weight < threshold {
+					cycle := append(append([]graphEdge{}, path...), edge)
+					cycles = append(cycles, g.sizeCycle(cycle))
+				}
+				continue
+			}
+			if visited[edge.to] || len(path)+1 >= maxHops {
+				continue
+			}
+			path = append(path, edge)
+			usedPool[edge.pool], visited[edge.to] = true, true
+			search(start, edge.to, weight+edge.weight)
+			usedPool[edge.pool], visited[edge.to] = false, false
+			path = path[:len(path)-1]
+		}
+	}
+	for start := range g.edges {
+		visited[start] = true
+		search(start, start, 0)
+		visited[start] = false
+	}
+	sort.Slice(cycles, func(i, j int) bool { return cycles[i].rate > cycles[j].rate })
+	return cycles
+}
+
+// sizeCycle finds the input of the cycle with the highest profit, the swaps of the cycle together are one constant product curve
+func (g *tokenGraph) sizeCycle(edges []graphEdge) arbCycle {
+	cycle := arbCycle{edges: edges, rate: 1}
+	var curve swapCurve
+	for i, edge := range edges {
+		in, out := g.reserves(edge)
+		fee := g.pools[edge.pool].fee
+		cycle.rate *= (1 - fee) * out / in
+		if i == 0 {
+			curve = newSwapCurve(in, out, fee)
+		} else {
+			curve = curve.then(newSwapCurve(in, out, fee))
+		}
+	}
+	cycle.input = curve.optimalInput()
+	cycle.profit = curve.out(cycle.input) - cycle.input
+	return cycle
+}
+
+// describe names tokens and DEXes of the cycle, e.g. WETH→USDC→DAI→WETH via Uniswap, Sushiswap, Uniswap
+func (g *tokenGraph) describe(cycle arbCycle) string {
+	tokens := []string{g.symbols[cycle.edges[0].from]}
+	venues := make([]string, 0, len(cycle.edges))
+	for _, edge := range cycle.edges {
+		tokens = append(tokens, g.symbols[edge.to])
+		venues = append(venues, g.pools[edge.pool].venue.name)
+	}
+	return strings.Join(tokens, "→") + " via " + strings.Join(venues, ", ")
+}
+
+// tokenPairs returns every two of the tokens once, ordered by address as pools order them
+func tokenPairs(addrs []common.Address) [][2]common.Address {
+	var pairs [][2]common.Address
+	for i, addr0 := range addrs {
+		for _, addr1 := range addrs[i+1:] {
+			tkn0Addr, tkn1Addr := addr0, addr1
+			if bytes.Compare(tkn0Addr.Bytes(), tkn1Addr.Bytes()) > 0 {
+				tkn0Addr, tkn1Addr = tkn1Addr, tkn0Addr
+			}
+			pairs = append(pairs, [2]common.Address{tkn0Addr, tkn1Addr})
+		}
+	}
+	return pairs
+}
+
+// buildTokenGraph finds pools of every configured DEX for every two configured tokens. Pools of configured pairs
+// are taken as they are, other pairs are looked up at the factory (or the pool of the dex) and skipped if missing
+func buildTokenGraph(ctx context.Context, chain *chainStruct, chainCfg chainConfig) (*tokenGraph, error) {
+	graph := &tokenGraph{}
+	index := make(map[common.Address]int)
+	metadata := make(map[common.Address]tokenMeta)
+	var addrs []common.Address
+	addToken := func(addr common.Address, symbol string, decimals uint8) {
+		if _, ok := index[addr]; !ok {
+			index[addr] = len(graph.symbols)
+			graph.symbols = append(graph.symbols, symbol)
+			metadata[addr] = tokenMeta{symbol: symbol, decimals: decimals}
+			addrs = append(addrs, addr)
+		}
+	}
+	configured := make(map[[2]common.Address]pairStruct)
+	for _, pair := range chain.pairs {
+		addToken(pair.tokens.tkn0Addr, pair.tokens.tkn0Symbol, pair.tokens.tkn0Decimals)
+		addToken(pair.tokens.tkn1Addr, pair.tokens.tkn1Symbol, pair.tokens.tkn1Decimals)
+		configured[[2]common.Address{pair.tokens.tkn0Addr, pair.tokens.tkn1Addr}] = pair
+	}
+
+	//edges are weighed as constant product curves, which pools weighing the tokens unequally are not
+	addPools := func(tokens tokenStruct, venues []venueStruct) error {
+		kept, excluded, err := constantProductVenues(ctx, venues)
+		if err != nil {
+			return err
+		}
+		base, quote := tokens.baseQuote()
+		for _, venue := range excluded {
+			fmt.Printf("[%s] Warning: %s/%s on %s is left out, its pool does not weigh both tokens equally\n", chainCfg.profile.name, base, quote, venue.name)
+		}
+		for _, venue := range kept {
+			graph.pools = append(graph.pools, graphPool{venue: venue, tokens: tokens, from: index[tokens.tkn0Addr], to: index[tokens.tkn1Addr], fee: venue.adapter.fee()})
+		}
+		return nil
+	}
+	for _, pairAddrs := range tokenPairs(addrs) {
+		if pair, ok := configured[pairAddrs]; ok {
+			if err := addPools(pair.tokens, pair.venues); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		tokens := newTokens(pairAddrs[0], pairAddrs[1], metadata)
+		var venues []venueStruct
+		for _, dexCfg := range chainCfg.dexes {
+			adapter, err := newVenue(chain.client, dexCfg)
+			if err != nil {
+				return nil, err
+			}
+			//most token pairs have no pool on most DEXes, those are simply not part of the graph
+			if err := adapter.resolvePool(ctx, tokens); errors.Is(err, errNoPool) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("%s %s/%s: %w", dexCfg.name, tokens.tkn0Symbol, tokens.tkn1Symbol, err)
+			}
+			venues = append(venues, venueStruct{name: dexCfg.name, kind: dexCfg.kind, adapter: adapter})
+		}
+		if err := addPools(tokens, venues); err != nil {
+			return nil, err
+		}
+	}
+
+	graph.link()
+	return graph, nil
+}
+
+// link adds both directions of every pool as edges of the graph
+func (g *tokenGraph) link() {
+	g.edges = make([][]graphEdge, len(g.symbols))
+	for i, pool := range g.pools {
+		g.edges[pool.from] = append(g.edges[pool.from], graphEdge{pool: i, from: pool.from, to: pool.to})
+		g.edges[pool.to] = append(g.edges[pool.to], graphEdge{pool: i, from: pool.to, to: pool.from})
+	}
+}
+
+// cyclesChain replays reserves of all pools of the token graph since targetTimestamp and writes cycles found in every block to out
+func cyclesChain(ctx context.Context, cfg *appConfig, chainCfg chainConfig, targetTimestamp uint64, out io.Writer) error {
+	profile := chainCfg.profile
+	fmt.Printf("[%s] Initializing DEX and tokens data\n", profile.name)
+	chain, err := initParams(ctx, chainCfg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[%s] Finding pools of all token pairs\n", profile.name)
+	graph, err := buildTokenGraph(ctx, chain, chainCfg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[%s] Token graph: %d tokens, %d pools\n", profile.name, len(graph.symbols), len(graph.pools))
+
+	fmt.Printf("[%s] Finding block number by timestamp\n", profile.name)
+	startBlock, err := getBlockByTimestamp(ctx, chain.client, chain.blockTimes, targetTimestamp)
+	if err != nil {
+		return err
+	}
+	head, err := chain.headBlock(ctx)
+	if err != nil {
+		return err
+	}
+	scan := blockRange{start: startBlock.Uint64(), end: finalBlock(head, cfg.confirmations)}
+
+	fmt.Printf("[%s] Reading reserves of %d pools\n", profile.name, len(graph.pools))
+	adapters := make([]venueAdapter, len(graph.pools))
+	for i, pool := range graph.pools {
+		adapters[i] = pool.venue.adapter
+	}
+	initial, errs, err := poolsReserves(ctx, chain.rpcClient, chainCfg.fetch, adapters, new(big.Int).SetUint64(scan.start-1))
+	if err != nil {
+		return err
+	}
+	blocks := make(map[uint64]bool)
+	for i := range graph.pools {
+		pool := &graph.pools[i]
+		if errs[i] != nil {
+			return fmt.Errorf("%s %s reserves at block %d: %w", pool.venue.name, pairName(pool.tokens), scan.start-1, errs[i])
+		}
+		pool.reserves = initial[i]
+		pool.history, err = readPoolHistory(ctx, cfg, chain, chainCfg, pool.venue, scan, initial[i])
+		if err != nil {
+			return err
+		}
+		for blockNum := range pool.history.changes {
+			blocks[blockNum] = true
+		}
+	}
+	blockNums := make([]uint64, 0, len(blocks))
+	for blockNum := range blocks {
+		blockNums = append(blockNums, blockNum)
+	}
+	sort.Slice(blockNums, func(i, j int) bool { return blockNums[i] < blockNums[j] })
+
+	type blockCycles struct {
+		blockNum uint64
+		cycles   []arbCycle
+	}
+	var found []blockCycles
+	var cycleBlocks []uint64
+	for _, blockNum := range blockNums {
+		for i := range graph.pools {
+			if reserves, ok := graph.pools[i].history.changes[blockNum]; ok {
+				graph.pools[i].reserves = reserves
+			}
+		}
+		graph.weigh()
+		if cycles := graph.findCycles(cfg.maxHops, cfg.minSpreadBps); len(cycles) > 0 {
+			found = append(found, blockCycles{blockNum: blockNum, cycles: cycles})
+			cycleBlocks = append(cycleBlocks, blockNum)
+		}
+	}
+	blocksTime, err := getBlocksTime(ctx, chain.rpcClient, chain.blockTimes, cycleBlocks, chainCfg.fetch)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "== %s: cycles of up to %d pools among %s ==\n", profile.name, cfg.maxHops, strings.Join(graph.symbols, ", "))
+	fmt.Fprintf(out, "Blocks %d-%d: %d blocks with reserve changes, %d blocks with cycles\n", scan.start, scan.end, len(blockNums), len(found))
+	for _, block := range found {
+		blockTime := time.Unix(int64(blocksTime[block.blockNum]), 0).In(cfg.location)
+		fmt.Fprintf(out, "%d %s\n", block.blockNum, blockTime.Format("2006-01-02 15:04:05 MST"))
+		for _, cycle := range block.cycles {
+			start := graph.symbols[cycle.edges[0].from]
+			fmt.Fprintf(out, "  %s: %.1f bps, input %.4f %s, profit %.4f %s\n", graph.describe(cycle),
+				(cycle.rate-1)*10000, cycle.input, start, cycle.profit, start)
+		}
+	}
+	return nil
+}
+
+// runCycles searches cyclic arbitrage on every configured chain since the analysis start
+func runCycles(ctx context.Context, cfg *appConfig, skipValidate bool) {
+	if cfg.offline {
+		fatal("cycles reads reserves from RPC and can not run offline")
+	}
+	if !skipValidate {
+		fmt.Println("Validating configuration")
+		if !validateConfig(ctx, cfg, os.Stdout) {
+			fatal("Fix the problems above or run with -skip-validate")
+		}
+	}
+	targetTimestamp := analysisStart(cfg)
+	out, err := reportOutput(cfg)
+	if err != nil {
+		fatal(err)
+	}
+	if out != os.Stdout {
+		defer out.Close()
+	}
+
+	//chains are searched concurrently, each one into its own buffer so that reports do not interleave
+	var wg sync.WaitGroup
+	reports := make([]bytes.Buffer, len(cfg.chains))
+	errs := make([]error, len(cfg.chains))
+	for i, chainCfg := range cfg.chains {
+		wg.Add(1)
+		go func(i int, chainCfg chainConfig) {
+			defer wg.Done()
+			errs[i] = cyclesChain(ctx, cfg, chainCfg, targetTimestamp, &reports[i])
+		}(i, chainCfg)
+	}
+	wg.Wait()
+
+	failed := false
+	for i, chainCfg := range cfg.chains {
+		reports[i].WriteTo(out)
+		if errs[i] != nil && !interrupted(errs[i]) {
+			log.Printf("[%s] %v", chainCfg.profile.name, errs[i])
+			failed = true
+		}
+	}
+	if ctx.Err() != nil {
+		log.Print("Interrupted, results above are partial")
+		exit(130)
+	}
+	if failed {
+		exit(1)
+	}
+	if cfg.outputPath != "" {
+		fmt.Printf("Report written to %s\n", cfg.outputPath)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestTokenPairs(t *testing.T) {
+	a := common.HexToAddress("0x0a")
+	b := common.HexToAddress("0x0b")
+	c := common.HexToAddress("0x0c")
+
+	tests := []struct {
+		name  string
+		addrs []common.Address
+		want  [][2]common.Address
+	}{
+		{name: "no tokens"},
+		{name: "one token", addrs: []common.Address{a}},
+		{name: "ordered", addrs: []common.Address{a, b}, want: [][2]common.Address{{a, b}}},
+		{name: "reversed", addrs: []common.Address{b, a}, want: [][2]common.Address{{a, b}}},
+		{name: "three tokens", addrs: []common.Address{c, a, b}, want: [][2]common.Address{{a, c}, {b, c}, {a, b}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tokenPairs(tt.addrs)
+			if len(got) != len(tt.want) {
+				t.Fatalf("want %d pairs, got %v", len(tt.want), got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("pair %d: want %v, got %v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+// testPool is a pool of the test graph, reserve0 is of token from
+type testPool struct {
+	venue              string
+	from, to           int
+	reserve0, reserve1 float64
+}
+
+func newTestGraph(symbols []string, pools []testPool) *tokenGraph {
+	graph := &tokenGraph{symbols: symbols}
+	for _, pool := range pools {
+		graph.pools = append(graph.pools, graphPool{venue: venueStruct{name: pool.venue}, from: pool.from, to: pool.to,
+			fee: 0.003, reserves: poolReserves{reserve0: pool.reserve0, reserve1: pool.reserve1}})
+	}
+	graph.link()
+	graph.weigh()
+	return graph
+}
+
+func TestFindCycles(t *testing.T) {
+	symbols := []string{"WETH", "USDC", "DAI", "WBTC"}
+	const (
+		weth = iota
+		usdc
+		dai
+		wbtc
+	)
+	//WETH is 1700 USDC, but 1750 DAI, and DAI is worth as much as USDC
+	wethUsdc := testPool{venue: "Uniswap", from: weth, to: usdc, reserve0: 1000, reserve1: 1700000}
+	usdcDai := testPool{venue: "Uniswap", from: usdc, to: dai, reserve0: 1e7, reserve1: 1e7}
+	wethDai := testPool{venue: "Sushiswap", from: weth, to: dai, reserve0: 1000, reserve1: 1750000}
+
+	tests := []struct {
+		name         string
+		pools        []testPool
+		maxHops      int
+		minSpreadBps float64
+		want         []string
+	}{
+		{name: "triangle found once", pools: []testPool{wethUsdc, usdcDai, wethDai}, maxHops: 3,
+			want: []string{"WETH→DAI→USDC→WETH via Sushiswap, Uniswap, Uniswap"}},
+		{name: "pools listed in another order", pools: []testPool{usdcDai, wethDai, wethUsdc}, maxHops: 3,
+			want: []string{"WETH→DAI→USDC→WETH via Sushiswap, Uniswap, Uniswap"}},
+		{name: "no spread", pools: []testPool{wethUsdc, usdcDai, {venue: "Sushiswap", from: weth, to: dai, reserve0: 1000, reserve1: 1700000}}, maxHops: 3},
+		{name: "spread under the minimum", pools: []testPool{wethUsdc, usdcDai, wethDai}, maxHops: 3, minSpreadBps: 300},
+		{name: "two pools of one pair", pools: []testPool{wethUsdc, {venue: "Sushiswap", from: weth, to: usdc, reserve0: 1000, reserve1: 1750000}}, maxHops: 3},
+		{name: "same pair on two DEXes", pools: []testPool{wethUsdc, usdcDai, wethDai, {venue: "Pancakeswap", from: weth, to: dai, reserve0: 100, reserve1: 176000}}, maxHops: 3,
+			want: []string{"WETH→DAI→USDC→WETH via Pancakeswap, Uniswap, Uniswap", "WETH→DAI→USDC→WETH via Sushiswap, Uniswap, Uniswap"}},
+		{name: "empty pool", pools: []testPool{wethUsdc, {venue: "Uniswap", from: usdc, to: dai}, wethDai}, maxHops: 3},
+		{name: "four hops over the limit", pools: []testPool{wethUsdc, usdcDai,
+			{venue: "Uniswap", from: dai, to: wbtc, reserve0: 2e6, reserve1: 100}, {venue: "Uniswap", from: wbtc, to: weth, reserve0: 100, reserve1: 1750}}, maxHops: 3},
+		{name: "four hops", pools: []testPool{wethUsdc, usdcDai,
+			{venue: "Uniswap", from: dai, to: wbtc, reserve0: 2e6, reserve1: 100}, {venue: "Uniswap", from: wbtc, to: weth, reserve0: 100, reserve1: 1750}}, maxHops: 4,
+			want: []string{"WETH→USDC→DAI→WBTC→WETH via Uniswap, Uniswap, Uniswap, Uniswap"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph := newTestGraph(symbols, tt.pools)
+			cycles := graph.findCycles(tt.maxHops, tt.minSpreadBps)
+			got := make([]string, len(cycles))
+			for i, cycle := range cycles {
+				got[i] = graph.describe(cycle)
+				if cycle.rate <= 1 || cycle.input <= 0 || cycle.profit <= 0 {
+					t.Errorf("%s: want a profitable cycle, got %+v", got[i], cycle)
+				}
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("want %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
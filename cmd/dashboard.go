@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+const (
+	//dashboardWindow is the depth of trades shown by the dashboard and of the volume column
+	dashboardWindow  = time.Hour
+	dashboardRefresh = time.Second
+	//dashboardLogLines is the number of scan messages kept for the status line
+	dashboardLogLines = 50
+)
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+var (
+	headerStyle = tcell.StyleDefault.Reverse(true)
+	titleStyle  = tcell.StyleDefault.Bold(true)
+	dimStyle    = tcell.StyleDefault.Dim(true)
+	errorStyle  = tcell.StyleDefault.Foreground(tcell.ColorRed)
+	sideStyles  = map[swapSides]tcell.Style{
+		buy:   tcell.StyleDefault.Foreground(tcell.ColorGreen),
+		sell:  tcell.StyleDefault.Foreground(tcell.ColorRed),
+		flash: tcell.StyleDefault.Foreground(tcell.ColorYellow),
+	}
+)
+
+// dashboard draws a full-screen view of one pair at a time from the trades of the window
+type dashboard struct {
+	*tradeWindow
+	screen tcell.Screen
+	//pair is the index of the shown pair among pairs of all chains, scroll is the first shown swap of the feed
+	pair   int
+	scroll int
+
+	logMu sync.Mutex
+	logs  []string
+}
+
+// dashboardPair is a pair of a chain as listed by the dashboard
+type dashboardPair struct {
+	chain *servedChain
+	pair  *servedPair
+}
+
+// feedSwap is a swap of the feed with the venue and block it was made on
+type feedSwap struct {
+	venue    string
+	blockNum uint64
+	tradeStruct
+}
+
+// logf keeps scan messages for the status line, printing them would break the screen
+func (d *dashboard) logf(format string, args ...interface{}) {
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+	d.logs = append(d.logs, time.Now().In(d.cfg.location).Format("15:04:05 ")+fmt.Sprintf(format, args...))
+	if len(d.logs) > dashboardLogLines {
+		d.logs = d.logs[len(d.logs)-dashboardLogLines:]
+	}
+}
+
+func (d *dashboard) lastLog() string {
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+	if len(d.logs) == 0 {
+		return ""
+	}
+	return d.logs[len(d.logs)-1]
+}
+
+// pairs lists pairs of all chains whose DEX and token data are already read, w.mu must be locked
+func (d *dashboard) pairs() []dashboardPair {
+	var pairs []dashboardPair
+	for _, chain := range d.chains {
+		for i := range chain.pairs {
+			pairs = append(pairs, dashboardPair{chain: chain, pair: &chain.pairs[i]})
+		}
+	}
+	return pairs
+}
+
+// handleKey applies a key press and tells whether the dashboard should quit
+func (d *dashboard) handleKey(ev *tcell.EventKey) bool {
+	d.mu.RLock()
+	count := len(d.pairs())
+	d.mu.RUnlock()
+	switchTo := func(pair int) {
+		if count > 0 {
+			d.pair, d.scroll = (pair+count)%count, 0
+		}
+	}
+	_, height := d.screen.Size()
+	switch ev.Key() {
+	case tcell.KeyEscape, tcell.KeyCtrlC:
+		return true
+	case tcell.KeyRight, tcell.KeyTab:
+		switchTo(d.pair + 1)
+	case tcell.KeyLeft, tcell.KeyBacktab:
+		switchTo(d.pair - 1)
+	case tcell.KeyDown:
+		d.scroll++
+	case tcell.KeyUp:
+		d.scroll--
+	case tcell.KeyPgDn:
+		d.scroll += height / 2
+	case tcell.KeyPgUp:
+		d.scroll -= height / 2
+	case tcell.KeyHome:
+		d.scroll = 0
+	case tcell.KeyRune:
+		switch r := ev.Rune(); {
+		case r == 'q':
+			return true
+		case r == 'n':
+			switchTo(d.pair + 1)
+		case r == 'p':
+			switchTo(d.pair - 1)
+		case r >= '1' && r <= '9' && int(r-'1') < count:
+			switchTo(int(r - '1'))
+		}
+	}
+	if d.scroll < 0 {
+		d.scroll = 0
+	}
+	return false
+}
+
+// draw renders the shown pair: the venue table, the spread sparkline and the swap feed
+func (d *dashboard) draw() {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	screen := d.screen
+	screen.Clear()
+	width, height := screen.Size()
+	line := func(y int, style tcell.Style, format string, args ...interface{}) {
+		if y < height {
+			drawText(screen, 0, y, width, style, fmt.Sprintf(format, args...))
+		}
+	}
+
+	pairs := d.pairs()
+	if len(pairs) == 0 {
+		line(0, headerStyle, " %-*s", width-1, "dex-price-reader: reading DEX and tokens data")
+		line(height-1, dimStyle, "%s", d.lastLog())
+		screen.Show()
+		return
+	}
+	if d.pair >= len(pairs) {
+		d.pair = 0
+	}
+	shown := pairs[d.pair]
+	chain, pair := shown.chain, shown.pair
+
+	status := fmt.Sprintf("head %d, final %d", chain.head, chain.syncedTo)
+	if !chain.updated.IsZero() {
+		status += ", updated " + chain.updated.In(d.cfg.location).Format("15:04:05")
+	}
+	header := fmt.Sprintf(" [%s] %s  pair %d/%d  %s", chain.profile.name, pairName(pair.tokens), d.pair+1, len(pairs), status)
+	line(0, headerStyle, "%-*s", width, header)
+	y := 1
+	if chain.err != nil {
+		line(y, errorStyle, " Warning: %v", chain.err)
+	}
+	y++
+
+	//venue table
+	cutoff := uint64(time.Now().Add(-dashboardWindow).Unix())
+	lowMid, _, haveMids := priceRange(pair.midPrices)
+	line(y, titleStyle, " %-16s %14s %14s %14s %10s %7s", "DEX", "Last price", "Mid-price", "Volume 1h", "Spread bps", "Swaps")
+	y++
+	for i, venue := range pair.venues {
+		last, mid, spread := "-", "-", "-"
+		if trade, _, ok := venue.lastTrade(); ok {
+			last = fmt.Sprintf("%.2f", trade.price)
+		}
+		if i < len(pair.midPrices) && pair.midPrices[i] > 0 {
+			mid = fmt.Sprintf("%.2f", pair.midPrices[i])
+			if haveMids {
+				spread = fmt.Sprintf("%.1f", spreadBps(lowMid, pair.midPrices[i]))
+			}
+		}
+		var volume float64
+		swaps := 0
+		for blockNum, trades := range venue.trades {
+			if chain.blocksTime[blockNum] < cutoff {
+				continue
+			}
+			for _, trade := range trades {
+				if trade.synthetic() {
+					continue
+				}
+				swaps++
+				if trade.swapSide != flash {
+					volume += trade.size
+				}
+			}
+		}
+		line(y, tcell.StyleDefault, " %-16s %14s %14s %14.2f %10s %7d", venue.name, last, mid, volume, spread, swaps)
+		y++
+	}
+	y++
+
+	//sparkline of the mid-price spread, one bar per poll
+	spreads := pair.spreads
+	bars := width - 2
+	if bars < 0 {
+		bars = 0
+	}
+	if len(spreads) > bars {
+		spreads = spreads[len(spreads)-bars:]
+	}
+	if len(spreads) == 0 {
+		line(y, titleStyle, " Spread: waiting for mid-prices of two DEXes")
+	} else {
+		low, high := spreads[0], spreads[0]
+		for _, spread := range spreads {
+			low, high = math.Min(low, spread), math.Max(high, spread)
+		}
+		line(y, titleStyle, " Spread bps: now %.1f, min %.1f, max %.1f over %d polls", spreads[len(spreads)-1], low, high, len(spreads))
+		line(y+1, tcell.StyleDefault, " %s", sparkline(spreads, low, high))
+	}
+	y += 3
+
+	//swap feed, newest first
+	feed := swapFeed(pair.pairTrades)
+	line(y, titleStyle, " %-8s %-10s %-16s %-5s %14s %14s  %s", "Time", "Block", "DEX", "Side", "Price", "Size", "Tx")
+	y++
+	//a terminal too small for the feed has no rows, the scroll still has to stay within the feed
+	rows := height - 1 - y
+	if rows < 0 {
+		rows = 0
+	}
+	if d.scroll > len(feed)-rows {
+		d.scroll = len(feed) - rows
+	}
+	if d.scroll < 0 {
+		d.scroll = 0
+	}
+	for _, swap := range feed[d.scroll:] {
+		if y >= height-1 {
+			break
+		}
+		blockTime := time.Unix(int64(chain.blocksTime[swap.blockNum]), 0).In(d.cfg.location)
+		line(y, sideStyles[swap.swapSide], " %-8s %-10d %-16s %-5s %14.2f %14.2f  %s", blockTime.Format("15:04:05"), swap.blockNum,
+			swap.venue, swap.swapSide, swap.price, swap.size, swap.txHash.Hex())
+		y++
+	}
+
+	help := "q quit  ←/→ n/p 1-9 pair  ↑/↓ PgUp/PgDn scroll"
+	if message := d.lastLog(); message != "" {
+		help = message + "  |  " + help
+	}
+	line(height-1, dimStyle, " %s", help)
+	screen.Show()
+}
+
+// swapFeed returns all swaps of the pair ordered from the newest
+func swapFeed(pair pairTrades) []feedSwap {
+	var feed []feedSwap
+	for _, venue := range pair.venues {
+		for blockNum, trades := range venue.trades {
+			for _, trade := range trades {
+				feed = append(feed, feedSwap{venue: venue.name, blockNum: blockNum, tradeStruct: trade})
+			}
+		}
+	}
+	sort.Slice(feed, func(i, j int) bool {
+		if feed[i].blockNum != feed[j].blockNum {
+			return feed[i].blockNum > feed[j].blockNum
+		}
+		return feed[i].logIndex > feed[j].logIndex
+	})
+	return feed
+}
+
+// sparkline scales values between low and high to block characters, flat series are drawn at the bottom
+func sparkline(values []float64, low, high float64) string {
+	var sb strings.Builder
+	for _, value := range values {
+		level := 0
+		if high > low {
+			level = int((value - low) / (high - low) * float64(len(sparkBars)-1))
+		}
+		sb.WriteRune(sparkBars[level])
+	}
+	return sb.String()
+}
+
+// drawText writes text from x on row y, cutting it at width
+func drawText(screen tcell.Screen, x, y, width int, style tcell.Style, text string) {
+	for _, r := range text {
+		if x >= width {
+			return
+		}
+		screen.SetContent(x, y, r, nil, style)
+		x++
+	}
+}
+
+// runDashboard scans the last hour and new final blocks in the background and shows them full-screen until q is pressed
+func runDashboard(ctx context.Context, cfg *appConfig, skipValidate bool) {
+	if cfg.offline {
+		fatal("dashboard reads new blocks from RPC and can not run offline")
+	}
+	if !skipValidate {
+		fmt.Println("Validating configuration")
+		if !validateConfig(ctx, cfg, os.Stdout) {
+			fatal("Fix the problems above or run with -skip-validate")
+		}
+	}
+
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		fatal(err)
+	}
+	if err := screen.Init(); err != nil {
+		fatal(err)
+	}
+	defer screen.Fini()
+
+	d := &dashboard{tradeWindow: newTradeWindow(cfg, dashboardWindow), screen: screen}
+	d.midPrices = true
+	d.tradeWindow.logf = d.logf
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.run(ctx, uint64(time.Now().Add(-dashboardWindow).Unix()))
+	}()
+
+	events := make(chan tcell.Event)
+	go func() {
+		for {
+			ev := screen.PollEvent()
+			//nil is returned once the screen is finalized
+			if ev == nil {
+				return
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	d.draw()
+	for ctx.Err() == nil {
+		select {
+		case ev := <-events:
+			switch ev := ev.(type) {
+			case *tcell.EventKey:
+				if d.handleKey(ev) {
+					cancel()
+					continue
+				}
+			case *tcell.EventResize:
+				screen.Sync()
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			continue
+		}
+		d.draw()
+	}
+	wg.Wait()
+}
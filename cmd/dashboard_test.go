@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestDashboardDrawSmallScreens(t *testing.T) {
+	tokens := tokenStruct{tkn0Symbol: "USDC", tkn0Decimals: 6, tkn1Symbol: "WETH", tkn1Decimals: 18}
+	trades := make(map[uint64][]tradeStruct)
+	for i := uint64(1); i <= 5; i++ {
+		trades[i] = []tradeStruct{{price: 1700, size: 1, swapSide: buy, txHash: common.BigToHash(new(big.Int).SetUint64(i))}}
+	}
+	cfg := &appConfig{location: time.UTC}
+	w := &tradeWindow{cfg: cfg, window: time.Hour, chains: []*servedChain{{
+		profile:    chainProfile{name: "ethereum"},
+		blocksTime: map[uint64]uint64{},
+		pairs: []servedPair{{
+			pairTrades: pairTrades{tokens: tokens, venues: []venueTrades{{name: "Uniswap", trades: trades}}},
+			spreads:    []float64{10, 12, 8, 15, 11},
+		}},
+	}}}
+
+	tests := []struct {
+		name          string
+		width, height int
+		//scroll is the first shown swap before drawing, want after it
+		scroll int
+		want   int
+	}{
+		{name: "feed fits", width: 120, height: 40, scroll: 3, want: 0},
+		{name: "feed scrolled", width: 120, height: 12, scroll: 3, want: 3},
+		{name: "scrolled past the feed", width: 120, height: 12, scroll: 40, want: 3},
+		{name: "no rows for the feed", width: 120, height: 3, scroll: 40, want: 5},
+		{name: "one column", width: 1, height: 40, scroll: 3, want: 0},
+		{name: "one cell", width: 1, height: 1, scroll: 40, want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			screen := tcell.NewSimulationScreen("")
+			if err := screen.Init(); err != nil {
+				t.Fatal(err)
+			}
+			defer screen.Fini()
+			screen.SetSize(tt.width, tt.height)
+			d := &dashboard{tradeWindow: w, screen: screen, scroll: tt.scroll}
+			d.draw()
+			if d.scroll != tt.want {
+				t.Errorf("want scroll %d, got %d", tt.want, d.scroll)
+			}
+		})
+	}
+}
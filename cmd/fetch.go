@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	defaultFetchWorkers = 8
+	defaultFetchRetries = 3
+	defaultFetchBackoff = 500 * time.Millisecond
+)
+
+// fetchOptions limit load on the RPC provider during bulk lookups
+type fetchOptions struct {
+	//number of concurrent requests
+	workers int
+	//attempts made after the first failure
+	retries int
+	//delay before the first retry, doubled after every failed attempt
+	backoff time.Duration
+	//number of requests sent in one JSON-RPC batch
+	batchSize int
+	//Multicall3 contract packing contract reads, zero address sends every read separately
+	multicall common.Address
+}
+
+// withRetry calls fn until it succeeds, retries are exhausted or ctx is cancelled.
+// Errors the RPC transport has already retried are returned at once, so that attempts do not multiply
+func withRetry(ctx context.Context, opts fetchOptions, fn func() error) error {
+	delay := opts.backoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= opts.retries || ctx.Err() != nil || errors.Is(err, errRetriesExhausted) {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// blockErrors aggregates failures of individual blocks into one error
+type blockErrors map[uint64]error
+
+func (e blockErrors) Error() string {
+	blockNums := make([]uint64, 0, len(e))
+	for blockNum := range e {
+		blockNums = append(blockNums, blockNum)
+	}
+	sort.Slice(blockNums, func(i, j int) bool { return blockNums[i] < blockNums[j] })
+
+	//a long list of the same RPC error is not readable, so only a few blocks are listed
+	const listed = 3
+	messages := make([]string, 0, listed)
+	for _, blockNum := range blockNums {
+		if len(messages) == listed {
+			messages = append(messages, fmt.Sprintf("and %d more", len(blockNums)-listed))
+			break
+		}
+		messages = append(messages, fmt.Sprintf("block %d: %v", blockNum, e[blockNum]))
+	}
+	return fmt.Sprintf("failed to read %d block(s): %s", len(e), strings.Join(messages, "; "))
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	defaultConfirmations = 12
+	defaultPollInterval  = 12 * time.Second
+)
+
+// finalBlock is the latest block having the given number of confirmations, the head itself is the first one
+func finalBlock(head, confirmations uint64) uint64 {
+	if confirmations == 0 {
+		return head
+	}
+	if head < confirmations {
+		return 0
+	}
+	return head - confirmations + 1
+}
+
+// followedBlock is an unconfirmed block whose results may have to be retracted
+type followedBlock struct {
+	hash common.Hash
+	//results of the block were printed
+	emitted bool
+	//trades of the block, counted by metrics once the block is final
+	pairs []pairTrades
+	//alerts fired on the block, retracted if a reorg replaces it
+	alerts []alert
+}
+
+// blockOnly returns trades of the pairs made in the given block
+func blockOnly(pairs []pairTrades, blockNum uint64) []pairTrades {
+	result := make([]pairTrades, len(pairs))
+	for i, pair := range pairs {
+		result[i].tokens = pair.tokens
+		for _, venue := range pair.venues {
+			trades := make(map[uint64][]tradeStruct)
+			if blockTrades, ok := venue.trades[blockNum]; ok {
+				trades[blockNum] = blockTrades
+			}
+			result[i].venues = append(result[i].venues, venueTrades{name: venue.name, trades: trades})
+		}
+	}
+	return result
+}
+
+// chainFollower prints swaps of new blocks of one chain as they arrive. Blocks without enough confirmations
+// are tracked by hash, when a reorg replaces them their results are retracted and printed again
+type chainFollower struct {
+	cfg         *appConfig
+	chainCfg    chainConfig
+	chain       *chainStruct
+	out         io.Writer
+	outMu       *sync.Mutex
+	nextBlock   uint64
+	unconfirmed map[uint64]followedBlock
+	//alerts is nil when no rules are configured
+	alerts *alertEngine
+}
+
+// poll processes blocks produced since the previous poll
+func (f *chainFollower) poll(ctx context.Context) error {
+	profile := f.chainCfg.profile
+	head, err := f.chain.headBlock(ctx)
+	if err != nil {
+		return err
+	}
+	var report bytes.Buffer
+
+	//a changed hash of a tracked block means a reorg, everything from that block on is read again.
+	//A reorg to a shorter chain drops tracked blocks above the new head, those do not exist anymore
+	if len(f.unconfirmed) > 0 {
+		tracked := make([]uint64, 0, len(f.unconfirmed))
+		var existing []uint64
+		for blockNum := range f.unconfirmed {
+			tracked = append(tracked, blockNum)
+			if blockNum <= head {
+				existing = append(existing, blockNum)
+			}
+		}
+		sort.Slice(tracked, func(i, j int) bool { return tracked[i] < tracked[j] })
+		refs, err := getBlockRefs(ctx, f.chain.rpcClient, existing, f.chainCfg.fetch)
+		if err != nil {
+			return err
+		}
+		for _, blockNum := range tracked {
+			if blockNum <= head && refs[blockNum].Hash == f.unconfirmed[blockNum].hash {
+				continue
+			}
+			var alerts []alert
+			for _, retracted := range tracked {
+				if retracted < blockNum {
+					continue
+				}
+				block := f.unconfirmed[retracted]
+				if block.emitted {
+					fmt.Fprintf(&report, "[%s] Reorg: results of block %d (%s) are retracted\n", profile.name, retracted, block.hash.Hex())
+				}
+				for _, a := range block.alerts {
+					fmt.Fprintf(&report, "[%s] Reorg: alert %s of block %d is retracted\n", profile.name, a.Rule, retracted)
+				}
+				alerts = append(alerts, block.alerts...)
+				delete(f.unconfirmed, retracted)
+			}
+			if f.alerts != nil {
+				f.alerts.retract(profile.name, blockNum, alerts)
+			}
+			f.nextBlock = blockNum
+			break
+		}
+	}
+	if f.nextBlock > head {
+		f.flush(&report)
+		return nil
+	}
+
+	//logs of every pair and venue are read first, nothing is printed if a reorg happens meanwhile
+	pairs := make([]pairTrades, len(f.chain.pairs))
+	blocks := map[uint64]bool{}
+	//alert rules on reserves look at every block, not only at blocks with swaps
+	readReserves := f.alerts != nil && f.alerts.needsReserves()
+	for blockNum := f.nextBlock; blockNum <= head; blockNum++ {
+		if head-blockNum+1 < f.cfg.confirmations || readReserves {
+			blocks[blockNum] = true
+		}
+	}
+	for i, pair := range f.chain.pairs {
+		pairs[i].tokens = pair.tokens
+		//routes follow the pools, in the order of pair.routes
+		for _, venue := range pair.venues {
+			pairs[i].venues = append(pairs[i].venues, venueTrades{name: venue.name, trades: make(map[uint64][]tradeStruct)})
+		}
+		for _, route := range pair.routes {
+			pairs[i].venues = append(pairs[i].venues, venueTrades{name: route.name, trades: make(map[uint64][]tradeStruct)})
+		}
+		//catching up after downtime may span many blocks, so logs are read in chunks as during a scan
+		for chunkStart := f.nextBlock; chunkStart <= head; chunkStart += f.cfg.chunkBlocks {
+			chunkEnd := chunkStart + f.cfg.chunkBlocks - 1
+			if chunkEnd > head {
+				chunkEnd = head
+			}
+			direct := make([]venueTrades, len(pair.venues))
+			for j, venue := range pair.venues {
+				chunkTrades, err := getLogs(ctx, f.chain.client, venue.adapter, new(big.Int).SetUint64(chunkStart), new(big.Int).SetUint64(chunkEnd))
+				if err != nil {
+					return fmt.Errorf("%s blocks %d-%d: %w", venue.name, chunkStart, chunkEnd, err)
+				}
+				direct[j] = venueTrades{name: venue.name, trades: chunkTrades}
+				for blockNum, blockTrades := range chunkTrades {
+					pairs[i].venues[j].trades[blockNum] = blockTrades
+					blocks[blockNum] = true
+				}
+			}
+			for k, route := range pair.routes {
+				routeTrades, err := readRouteTrades(ctx, f.chain.rpcClient, f.chainCfg.fetch, pair.tokens, route, direct)
+				if err != nil {
+					return fmt.Errorf("%s blocks %d-%d: %w", route.name, chunkStart, chunkEnd, err)
+				}
+				for blockNum, blockTrades := range routeTrades {
+					pairs[i].venues[len(pair.venues)+k].trades[blockNum] = blockTrades
+				}
+			}
+		}
+	}
+	blockNums := make([]uint64, 0, len(blocks))
+	for blockNum := range blocks {
+		blockNums = append(blockNums, blockNum)
+	}
+	sort.Slice(blockNums, func(i, j int) bool { return blockNums[i] < blockNums[j] })
+	refs, err := getBlockRefs(ctx, f.chain.rpcClient, blockNums, f.chainCfg.fetch)
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		for _, venue := range pair.venues {
+			for blockNum, trades := range venue.trades {
+				//route quotes have no block hash, they are priced only at blocks whose swaps are checked here
+				for _, trade := range trades {
+					if !trade.synthetic() && trade.blockHash != refs[blockNum].Hash {
+						return fmt.Errorf("block %d changed while it was read, retrying", blockNum)
+					}
+				}
+			}
+		}
+	}
+
+	var reserves [][]map[uint64]poolReserves
+	if readReserves {
+		if reserves, err = f.blockReserves(ctx, blockNums); err != nil {
+			return err
+		}
+	}
+
+	for _, blockNum := range blockNums {
+		confirmations := head - blockNum + 1
+		emitted := false
+		var alerts []alert
+		for _, pair := range pairs {
+			var table bytes.Buffer
+			logSynchronousSwaps(&table, profile, pair.venues, map[uint64]uint64{blockNum: uint64(refs[blockNum].Time)}, f.cfg)
+			if table.Len() == 0 {
+				continue
+			}
+			base, quote := pair.tokens.baseQuote()
+			status := "final"
+			if confirmations < f.cfg.confirmations {
+				status = fmt.Sprintf("%d/%d confirmations", confirmations, f.cfg.confirmations)
+			}
+			fmt.Fprintf(&report, "== %s: %s/%s block %d, %s ==\n", profile.name, base, quote, blockNum, status)
+			table.WriteTo(&report)
+			emitted = true
+		}
+		if f.alerts != nil {
+			for i, pair := range blockOnly(pairs, blockNum) {
+				b := alertBlock{chain: profile.name, blockNum: blockNum, blockTime: uint64(refs[blockNum].Time),
+					confirmations: confirmations, pair: pair}
+				if reserves != nil {
+					//reserves of routes are not read, they stay unknown
+					b.reserves, b.known = make([]poolReserves, len(pair.venues)), make([]bool, len(pair.venues))
+					for j := range reserves[i] {
+						b.reserves[j], b.known[j] = reserves[i][j][blockNum]
+					}
+				}
+				for _, a := range f.alerts.check(b) {
+					fmt.Fprintf(&report, "[%s] Alert %s: %s\n", profile.name, a.Rule, a.Message)
+					alerts = append(alerts, a)
+				}
+			}
+		}
+		if confirmations < f.cfg.confirmations {
+			f.unconfirmed[blockNum] = followedBlock{hash: refs[blockNum].Hash, emitted: emitted, pairs: blockOnly(pairs, blockNum), alerts: alerts}
+		} else {
+			for _, pair := range blockOnly(pairs, blockNum) {
+				observeFinalTrades(f.cfg, profile.name, pair)
+			}
+		}
+	}
+
+	//blocks getting enough confirmations in this poll are final and no longer tracked
+	for blockNum, block := range f.unconfirmed {
+		if head-blockNum+1 >= f.cfg.confirmations {
+			if block.emitted {
+				fmt.Fprintf(&report, "[%s] Block %d is final\n", profile.name, blockNum)
+			}
+			for _, pair := range block.pairs {
+				observeFinalTrades(f.cfg, profile.name, pair)
+			}
+			delete(f.unconfirmed, blockNum)
+		}
+	}
+	f.nextBlock = head + 1
+	f.flush(&report)
+
+	headBlockGauge.WithLabelValues(profile.name).Set(float64(head))
+	for _, pair := range pairs {
+		observeLastTrades(profile.name, pair)
+	}
+	return f.observeReserves(ctx)
+}
+
+// blockReserves reads reserves of every pool at the given blocks, by pair and venue index.
+// Blocks whose reserves could not be read are left out
+func (f *chainFollower) blockReserves(ctx context.Context, blockNums []uint64) ([][]map[uint64]poolReserves, error) {
+	result := make([][]map[uint64]poolReserves, len(f.chain.pairs))
+	for i, pair := range f.chain.pairs {
+		for _, venue := range pair.venues {
+			reserves, err := batchReserves(ctx, f.chain.rpcClient, venue.adapter, blockNums, f.chainCfg.fetch)
+			var blockErrs blockErrors
+			if err != nil && !errors.As(err, &blockErrs) {
+				return nil, fmt.Errorf("%s reserves: %w", venue.name, err)
+			}
+			if err != nil {
+				fmt.Printf("[%s] Warning: %s reserves: %v\n", f.chainCfg.profile.name, venue.name, err)
+			}
+			result[i] = append(result[i], reserves)
+		}
+	}
+	return result, nil
+}
+
+// observeReserves reads reserves of all pools at the latest block for mid-price metrics
+func (f *chainFollower) observeReserves(ctx context.Context) error {
+	var adapters []venueAdapter
+	for _, pair := range f.chain.pairs {
+		for _, venue := range pair.venues {
+			adapters = append(adapters, venue.adapter)
+		}
+	}
+	reserves, errs, err := poolsReserves(ctx, f.chain.rpcClient, f.chainCfg.fetch, adapters, nil)
+	if err != nil {
+		return fmt.Errorf("reading reserves: %w", err)
+	}
+	for _, pair := range f.chain.pairs {
+		count := len(pair.venues)
+		observeMidPrices(f.chainCfg.profile.name, pair, reserves[:count], errs[:count])
+		reserves, errs = reserves[count:], errs[count:]
+	}
+	return nil
+}
+
+// flush prints the report of the poll at once, so that reports of several chains do not interleave
+func (f *chainFollower) flush(report *bytes.Buffer) {
+	f.outMu.Lock()
+	defer f.outMu.Unlock()
+	report.WriteTo(f.out)
+}
+
+// followChain polls the chain until an unrecoverable error. Failed polls are retried on the next tick
+func followChain(ctx context.Context, cfg *appConfig, chainCfg chainConfig, alerts *alertEngine, out io.Writer, outMu *sync.Mutex) error {
+	profile := chainCfg.profile
+	fmt.Printf("[%s] Initializing DEX and tokens data\n", profile.name)
+	chain, err := initParams(ctx, chainCfg)
+	if err != nil {
+		return err
+	}
+	head, err := chain.headBlock(ctx)
+	if err != nil {
+		return err
+	}
+
+	//blocks that are not final yet are shown right away
+	follower := &chainFollower{
+		cfg:         cfg,
+		chainCfg:    chainCfg,
+		chain:       chain,
+		out:         out,
+		outMu:       outMu,
+		nextBlock:   finalBlock(head, cfg.confirmations),
+		unconfirmed: make(map[uint64]followedBlock),
+		alerts:      alerts,
+	}
+	fmt.Printf("[%s] Following from block %d, blocks are final after %d confirmations\n", profile.name, follower.nextBlock, cfg.confirmations)
+	ticker := time.NewTicker(cfg.pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := follower.poll(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("[%s] Warning: %v\n", profile.name, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// runFollow follows all configured chains at once
+func runFollow(ctx context.Context, cfg *appConfig) {
+	if cfg.metricsAddr != "" {
+		addr, err := serveMetrics(cfg.metricsAddr)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Serving metrics on http://%s/metrics\n", addr)
+	}
+	//one engine for all chains, so that cooldowns and sinks are shared
+	alerts := newAlertEngine(cfg)
+	var wg sync.WaitGroup
+	var outMu sync.Mutex
+	for _, chainCfg := range cfg.chains {
+		wg.Add(1)
+		go func(chainCfg chainConfig) {
+			defer wg.Done()
+			if err := followChain(ctx, cfg, chainCfg, alerts, os.Stdout, &outMu); err != nil && !interrupted(err) {
+				log.Printf("[%s] %v", chainCfg.profile.name, err)
+			}
+		}(chainCfg)
+	}
+	wg.Wait()
+	if alerts != nil {
+		alerts.wait()
+	}
+}
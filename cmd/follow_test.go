@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestFollowRoutes(t *testing.T) {
+	server := newFakeNode(t, 100)
+	var fc fileConfig
+	//Routed has no USDC/WETH pool, it is priced through DAI
+	config := `
+chains:
+  ethereum:
+    rpc: ` + server.URL + `
+    dexes:
+      - {name: Sushiswap, factory: "0xC0AEe478e3658e2610c5F7A4A2E1777cE9e4f2Ac"}
+      - {name: Routed, factory: "0x3333333333333333333333333333333333333333"}
+    pairs:
+      - token0: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+        token1: "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+        routes: [["0x6B175474E89094C44Da98b954EedeAC495271d0F"]]
+analysis: {confirmations: 3}
+cache: {dir: ""}
+rpc: {multicall: false}
+`
+	if err := yaml.Unmarshal([]byte(config), &fc); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := fc.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	chain, err := initParams(ctx, cfg.chains[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain.pairs) != 1 || len(chain.pairs[0].venues) != 1 || len(chain.pairs[0].routes) != 1 {
+		t.Fatalf("want Sushiswap priced directly and Routed through a route, got %+v", chain.pairs)
+	}
+
+	var out bytes.Buffer
+	follower := &chainFollower{cfg: cfg, chainCfg: cfg.chains[0], chain: chain, out: &out, outMu: &sync.Mutex{},
+		nextBlock: 81, unconfirmed: make(map[uint64]followedBlock)}
+	if err := follower.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	//swaps are made every 10 blocks, block 100 is not final yet
+	for _, want := range []string{"block 90, final", "block 100, 1/3 confirmations", "Routed via DAI"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("want %q in the output, got\n%s", want, out.String())
+		}
+	}
+	block, ok := follower.unconfirmed[100]
+	if !ok || len(block.pairs) != 1 || len(block.pairs[0].venues) != 2 {
+		t.Fatalf("want block 100 tracked with both venues, got %+v", follower.unconfirmed)
+	}
+	routed := block.pairs[0].venues[1]
+	if routed.name != "Routed via DAI" || len(routed.trades[100]) != 1 || !routed.trades[100][0].synthetic() {
+		t.Errorf("want a route quote of block 100, got %+v", routed)
+	}
+}
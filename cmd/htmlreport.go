@@ -0,0 +1,433 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// chart geometry in SVG user units, the plot area is inside the margins
+const (
+	chartWidth  = 960
+	chartHeight = 280
+	chartLeft   = 70
+	chartRight  = 20
+	chartTop    = 20
+	chartBottom = 40
+	chartTicks  = 5
+	volumeBars  = 60
+)
+
+var venueColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#17becf"}
+
+var sideColors = map[swapSides]string{buy: "#2ca02c", sell: "#d62728"}
+
+// svgChart is a chart ready to be drawn, every coordinate is already scaled to the plot area
+type svgChart struct {
+	Title string
+	//Left, Right and Bottom are edges of the plot area
+	Width, Height       int
+	Left, Right, Bottom float64
+	XTicks              []svgTick
+	YTicks              []svgTick
+	Series              []svgSeries
+	Bars                []svgBar
+	Legend              []svgLegend
+
+	xMin, xMax, yMin, yMax float64
+}
+
+type svgTick struct {
+	Pos   float64
+	Label string
+}
+
+// svgSeries is a set of points of one colour, joined by a line if Line is set
+type svgSeries struct {
+	Color  string
+	Line   bool
+	Points []svgPoint
+}
+
+type svgPoint struct {
+	X, Y, R float64
+	Title   string
+}
+
+type svgBar struct {
+	X, Y, W, H float64
+	Color      string
+	Title      string
+}
+
+type svgLegend struct {
+	Name  string
+	Color string
+}
+
+// htmlPair is the section of the report of one pair
+type htmlPair struct {
+	Title         string
+	Summary       string
+	Prices        *svgChart
+	Spreads       *svgChart
+	Volumes       *svgChart
+	Quote         string
+	Base          string
+	Opportunities []htmlOpportunity
+	MinSpread     float64
+}
+
+type htmlOpportunity struct {
+	Block     uint64
+	Time      string
+	Side      string
+	BuyDex    string
+	BuyPrice  float64
+	SellDex   string
+	SellPrice float64
+	Bps       float64
+	Size      float64
+	Profit    float64
+	BuyTx     string
+	SellTx    string
+}
+
+// newChart prepares axes of a chart of the given data ranges, an empty range is widened so that points stay visible
+func newChart(title string, xMin, xMax, yMin, yMax float64, location *time.Location) *svgChart {
+	if xMax <= xMin {
+		xMin, xMax = xMin-60, xMax+60
+	}
+	if yMax <= yMin {
+		pad := math.Max(math.Abs(yMin)*0.01, 1)
+		yMin, yMax = yMin-pad, yMax+pad
+	}
+	c := &svgChart{
+		Title: title, Width: chartWidth, Height: chartHeight,
+		Left: chartLeft, Right: chartWidth - chartRight, Bottom: chartHeight - chartBottom,
+		xMin: xMin, xMax: xMax, yMin: yMin, yMax: yMax,
+	}
+	for i := 0; i <= chartTicks; i++ {
+		x := xMin + (xMax-xMin)*float64(i)/chartTicks
+		c.XTicks = append(c.XTicks, svgTick{Pos: c.x(x), Label: time.Unix(int64(x), 0).In(location).Format("01-02 15:04")})
+		y := yMin + (yMax-yMin)*float64(i)/chartTicks
+		c.YTicks = append(c.YTicks, svgTick{Pos: c.y(y), Label: fmt.Sprintf("%.6g", y)})
+	}
+	return c
+}
+
+func (c *svgChart) x(value float64) float64 {
+	return chartLeft + (value-c.xMin)/(c.xMax-c.xMin)*(chartWidth-chartLeft-chartRight)
+}
+
+func (c *svgChart) y(value float64) float64 {
+	return chartHeight - chartBottom - (value-c.yMin)/(c.yMax-c.yMin)*(chartHeight-chartTop-chartBottom)
+}
+
+// priceChart is a scatter of plain swaps of every venue, the area of a point follows the trade size
+func priceChart(cfg *appConfig, pair pairTrades, blocksTime map[uint64]uint64, base, quote string) *svgChart {
+	xMin, xMax, yMin, yMax, maxSize := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1), 0.0
+	for _, venue := range pair.venues {
+		for blockNum, trades := range venue.trades {
+			for _, trade := range trades {
+				blockTime, ok := blocksTime[blockNum]
+				if !ok || trade.swapSide == flash || trade.price <= 0 {
+					continue
+				}
+				xMin, xMax = math.Min(xMin, float64(blockTime)), math.Max(xMax, float64(blockTime))
+				yMin, yMax = math.Min(yMin, trade.price), math.Max(yMax, trade.price)
+				maxSize = math.Max(maxSize, trade.size)
+			}
+		}
+	}
+	if math.IsInf(xMin, 0) {
+		return nil
+	}
+
+	chart := newChart(fmt.Sprintf("Trade prices, %s per %s", quote, base), xMin, xMax, yMin, yMax, cfg.location)
+	for i, venue := range pair.venues {
+		series := svgSeries{Color: venueColors[i%len(venueColors)]}
+		for blockNum, trades := range venue.trades {
+			for _, trade := range trades {
+				blockTime, ok := blocksTime[blockNum]
+				if !ok || trade.swapSide == flash || trade.price <= 0 {
+					continue
+				}
+				radius := 2.0
+				if maxSize > 0 {
+					radius += 8 * math.Sqrt(trade.size/maxSize)
+				}
+				series.Points = append(series.Points, svgPoint{
+					X: chart.x(float64(blockTime)), Y: chart.y(trade.price), R: radius,
+					Title: fmt.Sprintf("%s %s block %d: %.2f, size %.4f %s", venue.name, trade.swapSide, blockNum, trade.price, trade.size, base),
+				})
+			}
+		}
+		//bigger trades are drawn first, so that they do not hide smaller ones
+		sort.Slice(series.Points, func(i, j int) bool { return series.Points[i].R > series.Points[j].R })
+		chart.Series = append(chart.Series, series)
+		chart.Legend = append(chart.Legend, svgLegend{Name: venue.name, Color: series.Color})
+	}
+	return chart
+}
+
+// spreadChart draws the same block spread of buys and sells over time
+func spreadChart(cfg *appConfig, spreads []blockSpread, blocksTime map[uint64]uint64) *svgChart {
+	//blocks without time are left out, their timestamps are missing only if reading was interrupted
+	var timed []blockSpread
+	for _, spread := range spreads {
+		if _, ok := blocksTime[spread.blockNum]; ok {
+			timed = append(timed, spread)
+		}
+	}
+	spreads = timed
+	if len(spreads) == 0 {
+		return nil
+	}
+	xMin, xMax, yMax := math.Inf(1), math.Inf(-1), 0.0
+	for _, spread := range spreads {
+		blockTime := float64(blocksTime[spread.blockNum])
+		xMin, xMax = math.Min(xMin, blockTime), math.Max(xMax, blockTime)
+		yMax = math.Max(yMax, spread.bps)
+	}
+
+	chart := newChart("Spread across DEXes, bps", xMin, xMax, 0, yMax, cfg.location)
+	for _, side := range []swapSides{buy, sell} {
+		series := svgSeries{Color: sideColors[side], Line: true}
+		for _, spread := range spreads {
+			if spread.side != side {
+				continue
+			}
+			series.Points = append(series.Points, svgPoint{
+				X: chart.x(float64(blocksTime[spread.blockNum])), Y: chart.y(spread.bps), R: 2.5,
+				Title: fmt.Sprintf("%s block %d: %.1f bps, %s %.2f / %s %.2f", side, spread.blockNum, spread.bps,
+					spread.low.venue, spread.low.price, spread.high.venue, spread.high.price),
+			})
+		}
+		chart.Series = append(chart.Series, series)
+		chart.Legend = append(chart.Legend, svgLegend{Name: side.String(), Color: series.Color})
+	}
+	return chart
+}
+
+// volumeChart stacks volume of plain swaps of every venue in equal time buckets
+func volumeChart(cfg *appConfig, pair pairTrades, blocksTime map[uint64]uint64, base string) *svgChart {
+	start, end := math.Inf(1), math.Inf(-1)
+	for _, venue := range pair.venues {
+		for blockNum := range venue.trades {
+			if blockTime, ok := blocksTime[blockNum]; ok {
+				start, end = math.Min(start, float64(blockTime)), math.Max(end, float64(blockTime))
+			}
+		}
+	}
+	if math.IsInf(start, 0) {
+		return nil
+	}
+	bucket := math.Max(math.Ceil((end-start+1)/volumeBars), 1)
+
+	volumes := make([][]float64, len(pair.venues))
+	totals := make([]float64, volumeBars)
+	for i, venue := range pair.venues {
+		volumes[i] = make([]float64, volumeBars)
+		for blockNum, trades := range venue.trades {
+			blockTime, ok := blocksTime[blockNum]
+			if !ok {
+				continue
+			}
+			index := int((float64(blockTime) - start) / bucket)
+			for _, trade := range trades {
+				if trade.swapSide != flash && !trade.synthetic() {
+					volumes[i][index] += trade.size
+					totals[index] += trade.size
+				}
+			}
+		}
+	}
+	maxTotal := 0.0
+	for _, total := range totals {
+		maxTotal = math.Max(maxTotal, total)
+	}
+
+	chart := newChart(fmt.Sprintf("Volume, %s", base), start, start+bucket*volumeBars, 0, maxTotal, cfg.location)
+	width := chart.x(start+bucket) - chart.x(start)
+	for index := 0; index < volumeBars; index++ {
+		bucketStart := start + bucket*float64(index)
+		stacked := 0.0
+		for i, venue := range pair.venues {
+			volume := volumes[i][index]
+			if volume <= 0 {
+				continue
+			}
+			top := chart.y(stacked + volume)
+			chart.Bars = append(chart.Bars, svgBar{
+				X: chart.x(bucketStart), Y: top, W: math.Max(width-1, 1), H: chart.y(stacked) - top,
+				Color: venueColors[i%len(venueColors)],
+				Title: fmt.Sprintf("%s from %s: %.4f %s", venue.name,
+					time.Unix(int64(bucketStart), 0).In(cfg.location).Format("2006-01-02 15:04"), volume, base),
+			})
+			stacked += volume
+		}
+	}
+	for i, venue := range pair.venues {
+		chart.Legend = append(chart.Legend, svgLegend{Name: venue.name, Color: venueColors[i%len(venueColors)]})
+	}
+	return chart
+}
+
+// topOpportunities returns spreads of at least the minimum spread with the highest estimated profit
+func topOpportunities(cfg *appConfig, profile chainProfile, spreads []blockSpread, blocksTime map[uint64]uint64) []htmlOpportunity {
+	var opportunities []blockSpread
+	for _, spread := range spreads {
+		if spread.isOpportunity(cfg.minSpreadBps) {
+			opportunities = append(opportunities, spread)
+		}
+	}
+	sort.SliceStable(opportunities, func(i, j int) bool {
+		_, profitI := opportunities[i].opportunity()
+		_, profitJ := opportunities[j].opportunity()
+		return profitI > profitJ
+	})
+	if len(opportunities) > defaultOpportunities {
+		opportunities = opportunities[:defaultOpportunities]
+	}
+
+	rows := make([]htmlOpportunity, 0, len(opportunities))
+	for _, spread := range opportunities {
+		size, profit := spread.opportunity()
+		row := htmlOpportunity{
+			Block: spread.blockNum, Time: time.Unix(int64(blocksTime[spread.blockNum]), 0).In(cfg.location).Format("2006-01-02 15:04:05 MST"),
+			Side: spread.side.String(), BuyDex: spread.low.venue, BuyPrice: spread.low.price, SellDex: spread.high.venue, SellPrice: spread.high.price,
+			Bps: spread.bps, Size: size, Profit: profit,
+		}
+		if cfg.links {
+			row.BuyTx, row.SellTx = profile.txLink(spread.low.txHash.Hex()), profile.txLink(spread.high.txHash.Hex())
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// writeHTMLReport writes one self-contained HTML page with charts and top opportunities of every pair of every chain
+func writeHTMLReport(cfg *appConfig, chains []*chainTrades, out io.Writer) error {
+	var pairs []htmlPair
+	for _, chain := range chains {
+		if chain == nil {
+			continue
+		}
+		for _, pair := range chain.pairs {
+			base, quote := pair.tokens.baseQuote()
+			venueNames := make([]string, 0, len(pair.venues))
+			blocks := make(map[uint64]bool)
+			swaps := 0
+			for _, venue := range pair.venues {
+				venueNames = append(venueNames, venue.name)
+				for blockNum, trades := range venue.trades {
+					blocks[blockNum] = true
+					for _, trade := range trades {
+						if !trade.synthetic() {
+							swaps++
+						}
+					}
+				}
+			}
+			blockNums := make([]uint64, 0, len(blocks))
+			for blockNum := range blocks {
+				blockNums = append(blockNums, blockNum)
+			}
+			blocksTime, err := chain.blocksTime(blockNums)
+			if err != nil && !interrupted(err) {
+				return err
+			}
+
+			spreads := blockSpreads(pair.venues)
+			section := htmlPair{
+				Title:   fmt.Sprintf("%s: %s/%s on %s", chain.profile.name, base, quote, strings.Join(venueNames, ", ")),
+				Summary: fmt.Sprintf("%d swaps in %d blocks, %d same block spreads", swaps, len(blocks), len(spreads)),
+				Prices:  priceChart(cfg, pair, blocksTime, base, quote),
+				Spreads: spreadChart(cfg, spreads, blocksTime),
+				Volumes: volumeChart(cfg, pair, blocksTime, base),
+				Base:    base, Quote: quote,
+				Opportunities: topOpportunities(cfg, chain.profile, spreads, blocksTime),
+				MinSpread:     cfg.minSpreadBps,
+			}
+			pairs = append(pairs, section)
+		}
+	}
+
+	return htmlReportTemplate.Execute(out, struct {
+		Generated string
+		Pairs     []htmlPair
+	}{time.Now().In(cfg.location).Format("2006-01-02 15:04:05 MST"), pairs})
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"num": func(format string, value float64) string { return fmt.Sprintf(format, value) },
+	"add": func(a, b float64) float64 { return a + b },
+	"sub": func(a, b float64) float64 { return a - b },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>DEX price report</title>
+<style>
+body { font-family: sans-serif; margin: 24px; color: #222; }
+h2 { margin-top: 40px; }
+svg { display: block; margin: 12px 0; }
+svg text { font-size: 11px; fill: #444; }
+.grid { stroke: #e5e5e5; }
+.axis { stroke: #888; }
+table { border-collapse: collapse; font-size: 13px; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: right; }
+th { background: #f4f4f4; }
+td.text { text-align: left; }
+.legend span { display: inline-block; margin-right: 16px; font-size: 13px; }
+.legend i { display: inline-block; width: 10px; height: 10px; margin-right: 4px; }
+</style>
+</head>
+<body>
+<h1>DEX price report</h1>
+<p>Generated {{.Generated}}</p>
+{{range .Pairs}}
+<h2>{{.Title}}</h2>
+<p>{{.Summary}}</p>
+{{template "chart" .Prices}}
+{{template "chart" .Spreads}}
+{{template "chart" .Volumes}}
+<h3>Top opportunities</h3>
+{{if .Opportunities}}
+<table>
+<tr><th>Block</th><th>Time</th><th>Side</th><th>Buy on</th><th>Price</th><th>Sell on</th><th>Price</th><th>Spread bps</th><th>Size, {{.Base}}</th><th>Profit, {{.Quote}}</th></tr>
+{{range .Opportunities}}
+<tr><td>{{.Block}}</td><td class="text">{{.Time}}</td><td class="text">{{.Side}}</td>
+<td class="text">{{if .BuyTx}}<a href="{{.BuyTx}}">{{.BuyDex}}</a>{{else}}{{.BuyDex}}{{end}}</td><td>{{num "%.2f" .BuyPrice}}</td>
+<td class="text">{{if .SellTx}}<a href="{{.SellTx}}">{{.SellDex}}</a>{{else}}{{.SellDex}}{{end}}</td><td>{{num "%.2f" .SellPrice}}</td>
+<td>{{num "%.1f" .Bps}}</td><td>{{num "%.4f" .Size}}</td><td>{{num "%.2f" .Profit}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p>No spreads of at least {{num "%.1f" .MinSpread}} bps.</p>
+{{end}}
+{{else}}
+<p>No pairs were read.</p>
+{{end}}
+</body>
+</html>
+{{define "chart"}}{{if .}}
+<h3>{{.Title}}</h3>
+<div class="legend">{{range .Legend}}<span><i style="background: {{.Color}}"></i>{{.Name}}</span>{{end}}</div>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+{{$chart := .}}{{range .YTicks}}<line class="grid" x1="{{$chart.Left}}" x2="{{$chart.Right}}" y1="{{num "%.1f" .Pos}}" y2="{{num "%.1f" .Pos}}"/>
+<text x="{{num "%.1f" (sub $chart.Left 6)}}" y="{{num "%.1f" .Pos}}" text-anchor="end" dominant-baseline="middle">{{.Label}}</text>
+{{end}}{{range .XTicks}}<line class="axis" x1="{{num "%.1f" .Pos}}" x2="{{num "%.1f" .Pos}}" y1="{{$chart.Bottom}}" y2="{{num "%.1f" (add $chart.Bottom 5)}}"/>
+<text x="{{num "%.1f" .Pos}}" y="{{num "%.1f" (add $chart.Bottom 18)}}" text-anchor="middle">{{.Label}}</text>
+{{end}}<line class="axis" x1="{{.Left}}" x2="{{.Right}}" y1="{{.Bottom}}" y2="{{.Bottom}}"/>
+{{range .Bars}}<rect x="{{num "%.1f" .X}}" y="{{num "%.1f" .Y}}" width="{{num "%.1f" .W}}" height="{{num "%.1f" .H}}" fill="{{.Color}}"><title>{{.Title}}</title></rect>
+{{end}}{{range .Series}}{{$color := .Color}}{{if .Line}}<polyline fill="none" stroke="{{$color}}" stroke-width="1" points="{{range .Points}}{{num "%.1f" .X}},{{num "%.1f" .Y}} {{end}}"/>
+{{end}}{{range .Points}}<circle cx="{{num "%.1f" .X}}" cy="{{num "%.1f" .Y}}" r="{{num "%.1f" .R}}" fill="{{$color}}" fill-opacity="0.5"><title>{{.Title}}</title></circle>
+{{end}}{{end}}</svg>
+{{end}}{{end}}
+`))
@@ -2,36 +2,45 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"math/big"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
-	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/joho/godotenv"
-
-	"dex-price-reader/contract-api/erc20"
-	"dex-price-reader/contract-api/unifactory"
-	"dex-price-reader/contract-api/unipair"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
-type dexStruct struct {
-	dex0Name     string
-	dex0PairAddr common.Address
-	dex1Name     string
-	dex1PairAddr common.Address
+// venueStruct is a DEX pool of the analysed pair
+type venueStruct struct {
+	name    string
+	kind    string
+	adapter venueAdapter
+}
+
+// pairStruct is a token pair and its pools on every configured DEX
+type pairStruct struct {
+	tokens tokenStruct
+	venues []venueStruct
+	//routes price the pair on DEXes without its pool
+	routes []routeStruct
 }
+
 type tokenStruct struct {
 	tkn0Addr        common.Address
 	tkn0Symbol      string
@@ -43,153 +52,195 @@ type tokenStruct struct {
 	tkn1Denominator *big.Float
 }
 
+// baseQuote returns symbols of the token trade size is measured in and the token price is quoted in
+func (t tokenStruct) baseQuote() (string, string) {
+	if t.tkn0Decimals > t.tkn1Decimals {
+		return t.tkn0Symbol, t.tkn1Symbol
+	}
+	return t.tkn1Symbol, t.tkn0Symbol
+}
+
+// pairName names the pair BASE/QUOTE
+func pairName(tokens tokenStruct) string {
+	base, quote := tokens.baseQuote()
+	return base + "/" + quote
+}
+
+// matchPairName tells whether name (case insensitive, either order of symbols) refers to the pair
+func matchPairName(name string, tokens tokenStruct) bool {
+	base, quote := tokens.baseQuote()
+	return strings.EqualFold(name, base+"/"+quote) || strings.EqualFold(name, quote+"/"+base)
+}
+
 type swapSides int64
 
 const (
 	sell swapSides = iota
 	buy
+	//flash is a flash swap, a multi-sided swap or anything else that is not a plain one-way swap
+	flash
 )
 
+func (s swapSides) String() string {
+	switch s {
+	case buy:
+		return "buy"
+	case sell:
+		return "sell"
+	}
+	return "flash"
+}
+
 type tradeStruct struct {
-	price    float64
-	size     float64
-	swapSide swapSides
+	price     float64
+	size      float64
+	swapSide  swapSides
+	txHash    common.Hash
+	logIndex  uint
+	blockHash common.Hash
 }
 
-type blocksStruct struct {
-	blocks map[uint64]uint64
-	mu     sync.Mutex
+// chainStruct holds everything needed to run the analysis on one chain
+type chainStruct struct {
+	profile    chainProfile
+	client     *ethclient.Client
+	rpcClient  *rpc.Client
+	blockTimes *blockTimeCache
+	pairs      []pairStruct
 }
 
-func initParams() (*ethclient.Client, dexStruct, tokenStruct) {
-	err := godotenv.Load()
+// headBlock reads the number of the latest block, blocks final at that head become cacheable
+func (c *chainStruct) headBlock(ctx context.Context) (uint64, error) {
+	head, err := c.client.BlockNumber(ctx)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		return 0, err
 	}
+	c.blockTimes.observeHead(head)
+	return head, nil
+}
 
-	appKey := os.Getenv("ETH_APPKEY")
-	rpcUrl := os.Getenv("ETH_APIADDRESS") + appKey
-
-	client, err := ethclient.Dial(rpcUrl)
+func initParams(ctx context.Context, cfg chainConfig) (*chainStruct, error) {
+	client, rpcClient, err := dialChain(cfg)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	//factory contracts instances are needed to find respective pair pool addresses
-	dex0, err := unifactory.NewUnifactory(common.HexToAddress(os.Getenv("ETH_DEX0_FACTORY")), client)
+	//make sure RPC url points to the chain we expect, otherwise addresses would mean different contracts
+	chainID, err := client.ChainID(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	dex1, err := unifactory.NewUnifactory(common.HexToAddress(os.Getenv("ETH_DEX1_FACTORY")), client)
-	if err != nil {
-		log.Fatal(err)
-	}
-	//Tokens contract addresses to be analysed
-	var (
-		tokens tokenStruct
-		dexes  dexStruct
-	)
-	if os.Getenv("ETH_TOKEN1") > os.Getenv("ETH_TOKEN0") {
-		tokens.tkn0Addr = common.HexToAddress(os.Getenv("ETH_TOKEN0"))
-		tokens.tkn1Addr = common.HexToAddress(os.Getenv("ETH_TOKEN1"))
-	} else {
-		tokens.tkn0Addr = common.HexToAddress(os.Getenv("ETH_TOKEN1"))
-		tokens.tkn1Addr = common.HexToAddress(os.Getenv("ETH_TOKEN0"))
+	if chainID.Int64() != cfg.profile.chainID {
+		return nil, fmt.Errorf("rpc of %s serves chain id %d, expected %d", cfg.profile.name, chainID.Int64(), cfg.profile.chainID)
 	}
 
-	tkn0, err := erc20.NewErc20(tokens.tkn0Addr, client)
+	blockTimes, err := openBlockTimeCache(cfg.cacheDir, cfg.profile.name, cfg.confirmations)
 	if err != nil {
-		log.Fatal(err)
-	}
-	tkn1, err := erc20.NewErc20(tokens.tkn1Addr, client)
-	if err != nil {
-		log.Fatal(err)
-	}
-	tokens.tkn0Symbol, err = tkn0.Symbol(nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	tokens.tkn1Symbol, err = tkn1.Symbol(nil)
-	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	tokens.tkn0Decimals, err = tkn0.Decimals(nil)
-	if err != nil {
-		log.Fatal(err)
+
+	//metadata of all tokens is read at once, many pairs share the same tokens
+	var tokenAddrs []common.Address
+	seen := make(map[common.Address]bool)
+	for _, pairCfg := range cfg.pairs {
+		for _, tokenAddr := range append([]common.Address{pairCfg.token0, pairCfg.token1}, pairCfg.routeTokens()...) {
+			if !seen[tokenAddr] {
+				seen[tokenAddr] = true
+				tokenAddrs = append(tokenAddrs, tokenAddr)
+			}
+		}
 	}
-	tokens.tkn0Denominator = new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(tokens.tkn0Decimals)), nil))
-	tokens.tkn1Decimals, err = tkn1.Decimals(nil)
+	metadata, err := readTokenMeta(ctx, rpcClient, tokenAddrs, cfg.fetch)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	tokens.tkn1Denominator = new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(tokens.tkn1Decimals)), nil))
 
-	//get contract addresses of the pair pool at decentralized exchanges to read logs of swaps
-	dexes.dex0PairAddr, err = dex0.GetPair(nil, tokens.tkn0Addr, tokens.tkn1Addr)
-	if err != nil {
-		log.Fatal(err)
-	}
-	dexes.dex0Name = os.Getenv("ETH_DEX0_NAME")
-	dexes.dex1PairAddr, err = dex1.GetPair(nil, tokens.tkn0Addr, tokens.tkn1Addr)
-	if err != nil {
-		log.Fatal(err)
+	chain := &chainStruct{profile: cfg.profile, client: client, rpcClient: rpcClient, blockTimes: blockTimes}
+	for _, pairCfg := range cfg.pairs {
+		//Tokens contract addresses to be analysed
+		tokens := newTokens(pairCfg.token0, pairCfg.token1, metadata)
+		pair := pairStruct{tokens: tokens}
+		//get contract addresses of the pair pool at decentralized exchanges to read logs of swaps
+		for _, dexCfg := range cfg.dexes {
+			venue, err := newVenue(client, pairCfg.venueFor(dexCfg))
+			if err != nil {
+				return nil, err
+			}
+			err = venue.resolvePool(ctx, tokens)
+			//a DEX without a pool of the pair is priced through the first configured route it has pools of
+			if errors.Is(err, errNoPool) && len(pairCfg.routes) > 0 {
+				route, ok, routeErr := resolveRoute(ctx, client, pairCfg.venueFor(dexCfg), pairCfg.routes, metadata)
+				if routeErr != nil {
+					return nil, fmt.Errorf("%s %s/%s route: %w", dexCfg.name, tokens.tkn0Symbol, tokens.tkn1Symbol, routeErr)
+				}
+				if ok {
+					pair.routes = append(pair.routes, route)
+					continue
+				}
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s %s/%s: %w", dexCfg.name, tokens.tkn0Symbol, tokens.tkn1Symbol, err)
+			}
+			pair.venues = append(pair.venues, venueStruct{name: dexCfg.name, kind: dexCfg.kind, adapter: venue})
+		}
+		chain.pairs = append(chain.pairs, pair)
 	}
-	dexes.dex1Name = os.Getenv("ETH_DEX1_NAME")
 
-	return client, dexes, tokens
+	return chain, nil
 }
 
-func getBlockByTimestamp(client *ethclient.Client, targetTimestamp uint64) (*big.Int, error) {
-	headerFirst, err := client.HeaderByNumber(context.Background(), big.NewInt(1))
+// newTokens builds the pair from metadata of its tokens, tkn0Addr must be lower than tkn1Addr
+func newTokens(tkn0Addr, tkn1Addr common.Address, metadata map[common.Address]tokenMeta) tokenStruct {
+	tkn0, tkn1 := metadata[tkn0Addr], metadata[tkn1Addr]
+	return tokenStruct{
+		tkn0Addr: tkn0Addr, tkn0Symbol: tkn0.symbol, tkn0Decimals: tkn0.decimals, tkn0Denominator: tokenDenominator(tkn0.decimals),
+		tkn1Addr: tkn1Addr, tkn1Symbol: tkn1.symbol, tkn1Decimals: tkn1.decimals, tkn1Denominator: tokenDenominator(tkn1.decimals),
+	}
+}
+
+func getBlockByTimestamp(ctx context.Context, client *ethclient.Client, cache *blockTimeCache, targetTimestamp uint64) (*big.Int, error) {
+	firstTime, err := blockTime(ctx, client, cache, 1)
 	if err != nil {
 		return nil, err
 	}
-	headerCurrent, err := client.HeaderByNumber(context.Background(), nil)
+	headerCurrent, err := client.HeaderByNumber(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	averageTime := float64(headerCurrent.Time-headerFirst.Time) / float64(headerCurrent.Number.Uint64())
+	cache.observeHead(headerCurrent.Number.Uint64())
+	cache.put(headerCurrent.Number.Uint64(), headerCurrent.Time)
+	currentNum, currentTime := headerCurrent.Number.Uint64(), headerCurrent.Time
+	averageTime := float64(currentTime-firstTime) / float64(currentNum)
 
-	for headerCurrent.Time > targetTimestamp {
-		decreaseBlocks := int64(math.Round(float64(headerCurrent.Time-targetTimestamp) / averageTime))
+	for currentTime > targetTimestamp {
+		decreaseBlocks := uint64(math.Round(float64(currentTime-targetTimestamp) / averageTime))
 		if decreaseBlocks < 1 {
 			break
 		}
-		headerCurrent, err = client.HeaderByNumber(context.Background(), big.NewInt(headerCurrent.Number.Int64()-decreaseBlocks))
+		if decreaseBlocks >= currentNum {
+			decreaseBlocks = currentNum - 1
+		}
+		currentNum -= decreaseBlocks
+		currentTime, err = blockTime(ctx, client, cache, currentNum)
 		if err != nil {
 			return nil, err
 		}
 
 	}
-	for (headerCurrent.Time + uint64(averageTime)) < targetTimestamp {
-		headerCurrent, err = client.HeaderByNumber(context.Background(), big.NewInt(headerCurrent.Number.Int64()+1))
+	for (currentTime + uint64(averageTime)) < targetTimestamp {
+		currentNum++
+		currentTime, err = blockTime(ctx, client, cache, currentNum)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	return headerCurrent.Number, nil
+	return new(big.Int).SetUint64(currentNum), nil
 }
 
-func getLogs(client *ethclient.Client, pairAddr common.Address, tokens tokenStruct,
-	fromBlock *big.Int) (map[uint64][]tradeStruct, error) {
-	//Query all Swap events (without filterting by sender/to) for a given pair pool address
-	query := ethereum.FilterQuery{
-		FromBlock: fromBlock,
-		Addresses: []common.Address{
-			pairAddr,
-		},
-		Topics: [][]common.Hash{
-			{crypto.Keccak256Hash([]byte("Swap(address,uint256,uint256,uint256,uint256,address)"))},
-		},
-	}
-
-	logs, err := client.FilterLogs(context.Background(), query)
-	if err != nil {
-		return nil, err
-	}
-
-	contractAbi, err := abi.JSON(strings.NewReader(string(unipair.UnipairABI)))
+// getLogs reads swaps of the venue between fromBlock and toBlock inclusive, nil toBlock means the latest block
+func getLogs(ctx context.Context, client *ethclient.Client, venue venueAdapter, fromBlock, toBlock *big.Int) (map[uint64][]tradeStruct, error) {
+	logs, err := client.FilterLogs(ctx, venue.logFilter(fromBlock, toBlock))
 	if err != nil {
 		return nil, err
 	}
@@ -198,36 +249,20 @@ func getLogs(client *ethclient.Client, pairAddr common.Address, tokens tokenStru
 	tradingData = make(map[uint64][]tradeStruct)
 
 	for _, vLog := range logs {
-		swapEvent, err := contractAbi.Unpack("Swap", vLog.Data)
+		//removed logs belong to blocks dropped by a reorg
+		if vLog.Removed {
+			continue
+		}
+		tradeInfo, ok, err := venue.decodeLog(vLog)
 		if err != nil {
 			return nil, err
 		}
-		//Below we cast amounts to big.Float, divide them using token denominator and then cast to float64
-		amount0In, _ := new(big.Float).Quo(new(big.Float).SetInt(swapEvent[0].(*big.Int)), tokens.tkn0Denominator).Float64()
-		amount1In, _ := new(big.Float).Quo(new(big.Float).SetInt(swapEvent[1].(*big.Int)), tokens.tkn1Denominator).Float64()
-		amount0Out, _ := new(big.Float).Quo(new(big.Float).SetInt(swapEvent[2].(*big.Int)), tokens.tkn0Denominator).Float64()
-		amount1Out, _ := new(big.Float).Quo(new(big.Float).SetInt(swapEvent[3].(*big.Int)), tokens.tkn1Denominator).Float64()
-		var tradeInfo tradeStruct
-		if amount0In > 0 {
-			tradeInfo.swapSide = sell
-			if tokens.tkn0Decimals > tokens.tkn1Decimals {
-				tradeInfo.price = math.Round(amount1Out/amount0In*100) / 100
-				tradeInfo.size = math.Round(amount0In*100) / 100
-			} else {
-				tradeInfo.price = math.Round(amount0In/amount1Out*100) / 100
-				tradeInfo.size = math.Round(amount1Out*100) / 100
-			}
-
-		} else {
-			tradeInfo.swapSide = buy
-			if tokens.tkn0Decimals > tokens.tkn1Decimals {
-				tradeInfo.price = math.Round(amount1In/amount0Out*100) / 100
-				tradeInfo.size = math.Round(amount0Out*100) / 100
-			} else {
-				tradeInfo.price = math.Round(amount0Out/amount1In*100) / 100
-				tradeInfo.size = math.Round(amount1In*100) / 100
-			}
+		if !ok {
+			continue
 		}
+		tradeInfo.txHash = vLog.TxHash
+		tradeInfo.logIndex = vLog.Index
+		tradeInfo.blockHash = vLog.BlockHash
 
 		tradingData[vLog.BlockNumber] = append(tradingData[vLog.BlockNumber], tradeInfo)
 
@@ -237,110 +272,597 @@ func getLogs(client *ethclient.Client, pairAddr common.Address, tokens tokenStru
 
 }
 
-func getBlocksTime(client *ethclient.Client, dex0Trades map[uint64][]tradeStruct,
-	dex1Trades map[uint64][]tradeStruct) map[uint64]uint64 {
+// interrupted tells whether err is caused by the user stopping the run
+func interrupted(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
 
-	var wg sync.WaitGroup
-	blocksTime := blocksStruct{blocks: make(map[uint64]uint64)}
-	for blockNum := range dex0Trades {
-		if _, ok := dex1Trades[blockNum]; ok {
-			wg.Add(1)
-			go func(blockNum uint64, blocksTime *blocksStruct, wg *sync.WaitGroup) {
-				defer wg.Done()
-				block, err := client.BlockByNumber(context.Background(), big.NewInt(int64(blockNum)))
-				blocksTime.mu.Lock()
-				if err != nil {
-					blocksTime.blocks[blockNum] = 0
-				} else {
-					blocksTime.blocks[blockNum] = block.Time()
-				}
-				blocksTime.mu.Unlock()
-			}(blockNum, &blocksTime, &wg)
+// newTrade converts swap amounts (already divided by token denominators) into price, size and side
+func newTrade(tokens tokenStruct, amount0In, amount1In, amount0Out, amount1Out float64) tradeStruct {
+	var tradeInfo tradeStruct
+	//net flow of every token into the pool decides direction, as both in and out amounts of a token may be non-zero
+	net0, net1 := amount0In-amount0Out, amount1In-amount1Out
+	//a plain swap brings exactly one token in and takes exactly the other one out
+	plain := amount0In > 0 && amount1Out > 0 && amount1In == 0 && amount0Out == 0 ||
+		amount1In > 0 && amount0Out > 0 && amount0In == 0 && amount1Out == 0
+	switch {
+	case !plain:
+		tradeInfo.swapSide = flash
+	case net0 > 0:
+		tradeInfo.swapSide = sell
+	default:
+		tradeInfo.swapSide = buy
+	}
+
+	//price exists only if one token went in and the other one out, otherwise it would be zero, Inf or NaN
+	if net0 == 0 || net1 == 0 || (net0 > 0) == (net1 > 0) {
+		return tradeInfo
+	}
+	baseAmount, quoteAmount := math.Abs(net1), math.Abs(net0)
+	if tokens.tkn0Decimals > tokens.tkn1Decimals {
+		baseAmount, quoteAmount = math.Abs(net0), math.Abs(net1)
+	}
+	tradeInfo.price = math.Round(quoteAmount/baseAmount*100) / 100
+	tradeInfo.size = math.Round(baseAmount*100) / 100
+	return tradeInfo
+}
+
+// synthetic tells a price quoted for a route from pool reserves apart from a swap, it has no transaction
+// and is left out of swap counts and volume
+func (t tradeStruct) synthetic() bool {
+	return t.txHash == (common.Hash{})
+}
+
+// venueTrades are trades of one venue grouped by block number
+type venueTrades struct {
+	name   string
+	trades map[uint64][]tradeStruct
+}
+
+// lastTrade returns the latest priced plain swap of the venue and its block number
+func (v venueTrades) lastTrade() (last tradeStruct, lastAt uint64, ok bool) {
+	for blockNum, trades := range v.trades {
+		for _, trade := range trades {
+			if trade.swapSide == flash || trade.price <= 0 {
+				continue
+			}
+			if !ok || blockNum > lastAt || blockNum == lastAt && trade.logIndex > last.logIndex {
+				last, lastAt, ok = trade, blockNum, true
+			}
 		}
 	}
+	return last, lastAt, ok
+}
 
-	wg.Wait()
+// sharedBlocks returns numbers of blocks having trades on at least two venues
+func sharedBlocks(venues []venueTrades) []uint64 {
+	venuesInBlock := make(map[uint64]int)
+	for _, venue := range venues {
+		for blockNum := range venue.trades {
+			venuesInBlock[blockNum]++
+		}
+	}
+	var blockNums []uint64
+	for blockNum, count := range venuesInBlock {
+		if count > 1 {
+			blockNums = append(blockNums, blockNum)
+		}
+	}
+	return blockNums
+}
+
+var outputFormats = map[string]bool{
+	"table": true,
+	"html":  true,
+}
+
+func outputFormatNames() []string {
+	names := make([]string, 0, len(outputFormats))
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// spreadBps is the difference between the highest and the lowest price in basis points
+func spreadBps(minPrice, maxPrice float64) float64 {
+	if minPrice <= 0 {
+		return 0
+	}
+	return (maxPrice - minPrice) / minPrice * 10000
+}
+
+// logSynchronousSwaps prints blocks in which the pair traded on several venues as a timeline ordered by block number,
+// trades of a block are ordered by log index. A separator line is printed where the day changes
+func logSynchronousSwaps(out io.Writer, profile chainProfile, venues []venueTrades, blocksTime map[uint64]uint64, cfg *appConfig) {
+
+	blockNums := make([]uint64, 0, len(blocksTime))
+	for blockNum := range blocksTime {
+		blockNums = append(blockNums, blockNum)
+	}
+	sort.Slice(blockNums, func(i, j int) bool { return blockNums[i] < blockNums[j] })
+
+	type venueTrade struct {
+		venue string
+		tradeStruct
+	}
 
-	return blocksTime.blocks
-}
-
-func logSynchronousSwaps(dex0Trades map[uint64][]tradeStruct, dex0Name string,
-	dex1Trades map[uint64][]tradeStruct, dex1Name string, blocksTime map[uint64]uint64) {
-
-	var (
-		buyStringDEX0  string
-		buyStringDEX1  string
-		sellStringDEX0 string
-		sellStringDEX1 string
-	)
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
-	for blockNum, dex0Slice := range dex0Trades {
-		if dex1Slice, ok := dex1Trades[blockNum]; ok {
-			buyStringDEX0, buyStringDEX1, sellStringDEX0, sellStringDEX1 = "", "", "", ""
-			for _, dex0Swap := range dex0Slice {
-				if dex0Swap.swapSide == buy {
-					buyStringDEX0 = buyStringDEX0 + fmt.Sprintf("Buy\t"+dex0Name+"\t%.2f\t%.2f\t\r\n", dex0Swap.price, dex0Swap.size)
-				} else {
-					sellStringDEX0 = sellStringDEX0 + fmt.Sprintf("Sell\t"+dex0Name+"\t%.2f\t%.2f\t\r\n", dex0Swap.price, dex0Swap.size)
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	var lastDay string
+	for _, blockNum := range blockNums {
+		var trades []venueTrade
+		for _, venue := range venues {
+			for _, swap := range venue.trades[blockNum] {
+				//flash and complex swaps have no side, their prices are not comparable with plain swaps
+				if swap.swapSide != flash {
+					trades = append(trades, venueTrade{venue: venue.name, tradeStruct: swap})
 				}
 			}
-			for _, dex1Swap := range dex1Slice {
-				if dex1Swap.swapSide == buy {
-					buyStringDEX1 = buyStringDEX1 + fmt.Sprintf("Buy\t"+dex1Name+"\t%.2f\t%.2f\t\r\n", dex1Swap.price, dex1Swap.size)
-				} else {
-					sellStringDEX1 = sellStringDEX1 + fmt.Sprintf("Sell\t"+dex1Name+"\t%.2f\t%.2f\t\r\n", dex1Swap.price, dex1Swap.size)
-				}
+		}
+		sort.SliceStable(trades, func(i, j int) bool { return trades[i].logIndex < trades[j].logIndex })
+
+		var (
+			buyString, sellString string
+			buyVenues, sellVenues = make(map[string]bool), make(map[string]bool)
+			buyMin, buyMax        = math.Inf(1), math.Inf(-1)
+			sellMin, sellMax      = math.Inf(1), math.Inf(-1)
+		)
+		for _, swap := range trades {
+			row := fmt.Sprintf("\t"+swap.venue+"\t%.2f\t%.2f\t", swap.price, swap.size)
+			if cfg.links {
+				row += profile.txLink(swap.txHash.Hex()) + "\t"
+			}
+			if swap.swapSide == buy {
+				buyString = buyString + "Buy" + row + "\r\n"
+				buyVenues[swap.venue] = true
+				buyMin, buyMax = math.Min(buyMin, swap.price), math.Max(buyMax, swap.price)
+			} else {
+				sellString = sellString + "Sell" + row + "\r\n"
+				sellVenues[swap.venue] = true
+				sellMin, sellMax = math.Min(sellMin, swap.price), math.Max(sellMax, swap.price)
+			}
+		}
+		//same side trades are comparable only if they happened on two or more venues
+		printBuy := len(buyVenues) > 1 && spreadBps(buyMin, buyMax) >= cfg.minSpreadBps
+		printSell := len(sellVenues) > 1 && spreadBps(sellMin, sellMax) >= cfg.minSpreadBps
+		if printBuy || printSell {
+			blockTime := time.Unix(int64(blocksTime[blockNum]), 0).In(cfg.location)
+			day := blockTime.Format("Mon 02 Jan 2006")
+			if lastDay != "" && day != lastDay {
+				fmt.Fprintf(w, "-- %s --\n", day)
+			}
+			lastDay = day
+			header := fmt.Sprintf("%d %s\tDEX\tPrice\tSize\t", blockNum, blockTime.Format("2006-01-02 15:04:05 MST"))
+			if cfg.links {
+				header += "Tx\t"
 			}
-			if (len(buyStringDEX0) > 0 && len(buyStringDEX1) > 0) || (len(sellStringDEX0) > 0 && len(sellStringDEX1) > 0) {
-				fmt.Fprintln(w, time.Unix(int64(blocksTime[blockNum]), 0).Format(time.Stamp)+"\tDEX\tPrice\tSize\t")
-				if len(buyStringDEX0) > 0 && len(buyStringDEX1) > 0 {
-					fmt.Fprintf(w, buyStringDEX0+buyStringDEX1)
+			fmt.Fprintln(w, header)
+			if printBuy {
+				fmt.Fprint(w, buyString)
+			}
+			if printSell {
+				fmt.Fprint(w, sellString)
+			}
+		}
+	}
+
+	w.Flush()
+
+}
+
+// pairTrades are trades of a pair on every venue of the chain
+type pairTrades struct {
+	tokens tokenStruct
+	venues []venueTrades
+}
+
+// chainTrades is the input of analysis, read either from RPC or from the local store
+type chainTrades struct {
+	profile chainProfile
+	pairs   []pairTrades
+	//blocksTime returns timestamps of the given blocks
+	blocksTime func(blockNums []uint64) (map[uint64]uint64, error)
+}
+
+// readChainTrades reads swaps of all configured pairs since targetTimestamp from RPC.
+// Progress messages are tagged by chain name as several chains can run at once.
+// Logs are read in chunks recorded in the checkpoint, a resumed scan reads only chunks not recorded yet.
+// When ctx is cancelled while logs are read, trades read so far are returned together with the error
+func readChainTrades(ctx context.Context, cfg *appConfig, checkpoint *scanCheckpoint, chainCfg chainConfig,
+	targetTimestamp uint64) (*chainTrades, error) {
+
+	profile := chainCfg.profile
+	fmt.Printf("[%s] Initializing DEX and tokens data\n", profile.name)
+	chain, err := initParams(ctx, chainCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	//we will analyse blocks from startBlock (defined based on the input from user) to the latest final block,
+	//a resumed scan keeps the range of the interrupted one
+	scan, ok := checkpoint.chainRange(profile.name)
+	if ok {
+		fmt.Printf("[%s] Resuming scan of blocks %d-%d\n", profile.name, scan.start, scan.end)
+	} else {
+		fmt.Printf("[%s] Finding block number by timestamp\n", profile.name)
+		startBlock, err := getBlockByTimestamp(ctx, chain.client, chain.blockTimes, targetTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		//swaps of the latest blocks may still be reorged, so analysis stops at the last final block
+		head, err := chain.headBlock(ctx)
+		if err != nil {
+			return nil, err
+		}
+		scan = blockRange{start: startBlock.Uint64(), end: finalBlock(head, cfg.confirmations)}
+		if err := checkpoint.startChain(profile.name, scan); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &chainTrades{
+		profile: profile,
+		blocksTime: func(blockNums []uint64) (map[uint64]uint64, error) {
+			return getBlocksTime(ctx, chain.rpcClient, chain.blockTimes, blockNums, chainCfg.fetch)
+		},
+	}
+	for _, pair := range chain.pairs {
+		base, quote := pair.tokens.baseQuote()
+		fmt.Printf("[%s] Reading %s/%s swap logs\n", profile.name, base, quote)
+		trades := pairTrades{tokens: pair.tokens}
+		for _, venue := range pair.venues {
+			poolKey := fmt.Sprintf("%s %s %s", venue.name, pair.tokens.tkn0Addr.Hex(), pair.tokens.tkn1Addr.Hex())
+			progress := checkpoint.poolProgress(profile.name, poolKey)
+			venueLogs := progress.trades
+			chunkStart := scan.start
+			if progress.done >= chunkStart {
+				chunkStart = progress.done + 1
+			}
+			for ; chunkStart <= scan.end; chunkStart += cfg.chunkBlocks {
+				chunkEnd := chunkStart + cfg.chunkBlocks - 1
+				if chunkEnd > scan.end {
+					chunkEnd = scan.end
+				}
+				chunkLogs, err := getLogs(ctx, chain.client, venue.adapter, new(big.Int).SetUint64(chunkStart), new(big.Int).SetUint64(chunkEnd))
+				if interrupted(err) {
+					trades.venues = append(trades.venues, venueTrades{name: venue.name, trades: venueLogs})
+					result.pairs = append(result.pairs, trades)
+					return result, err
 				}
-				if len(sellStringDEX0) > 0 && len(sellStringDEX1) > 0 {
-					fmt.Fprintf(w, sellStringDEX0+sellStringDEX1)
+				if err != nil {
+					return nil, fmt.Errorf("%s blocks %d-%d: %w", venue.name, chunkStart, chunkEnd, err)
+				}
+				if err := checkpoint.saveRange(profile.name, poolKey, chunkEnd, chunkLogs); err != nil {
+					return nil, err
+				}
+				for blockNum, blockTrades := range chunkLogs {
+					venueLogs[blockNum] = blockTrades
 				}
 			}
+			trades.venues = append(trades.venues, venueTrades{name: venue.name, trades: venueLogs})
 		}
+		direct := trades.venues
+		for _, route := range pair.routes {
+			fmt.Printf("[%s] Pricing %s/%s on %s\n", profile.name, base, quote, route.name)
+			routeTrades, err := readRouteTrades(ctx, chain.rpcClient, chainCfg.fetch, pair.tokens, route, direct)
+			if interrupted(err) {
+				result.pairs = append(result.pairs, trades)
+				return result, err
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", route.name, err)
+			}
+			trades.venues = append(trades.venues, venueTrades{name: route.name, trades: routeTrades})
+		}
+		result.pairs = append(result.pairs, trades)
 	}
+	return result, nil
+}
 
-	w.Flush()
+// loadChainTrades reads trades from the local store in offline mode and from RPC otherwise
+func loadChainTrades(ctx context.Context, cfg *appConfig, store *tradeStore, checkpoint *scanCheckpoint,
+	chainCfg chainConfig, targetTimestamp uint64) (*chainTrades, error) {
 
+	if cfg.offline {
+		return store.chainTrades(chainCfg, targetTimestamp)
+	}
+	return readChainTrades(ctx, cfg, checkpoint, chainCfg, targetTimestamp)
 }
 
-func main() {
+// analyseChain finds swaps made on several DEXes of the chain in the same blocks and writes them to out.
+// After an interruption only blocks with already known timestamps are shown
+func analyseChain(cfg *appConfig, chain *chainTrades, out io.Writer) error {
+	for _, pair := range chain.pairs {
+		base, quote := pair.tokens.baseQuote()
+		venueNames := make([]string, 0, len(pair.venues))
+		for _, venue := range pair.venues {
+			venueNames = append(venueNames, venue.name)
+		}
 
-	fmt.Println("Initializing DEX and tokens data")
-	client, dexes, tokens := initParams()
+		blocksTime, err := chain.blocksTime(sharedBlocks(pair.venues))
+		if err != nil && !interrupted(err) {
+			return err
+		}
 
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Enter analysis depth in hours: ")
-	input, _ := reader.ReadString('\n')
-	inputNum := strings.ReplaceAll(input, "\r\n", "")
-	duration, err := strconv.ParseInt(inputNum, 10, 64)
-	if err != nil || duration <= 0 {
-		log.Fatal("Input must be postive integer")
+		fmt.Fprintf(out, "== %s: %s/%s on %s ==\n", chain.profile.name, base, quote, strings.Join(venueNames, ", "))
+		logSynchronousSwaps(out, chain.profile, pair.venues, blocksTime, cfg)
 	}
-	targetTimestamp := uint64(time.Now().Unix() - duration*60*60) //user has input duration in hours
+	return nil
+}
 
-	//we will analyse blocks from startBlock (defined based on the input from user) to the latest
-	fmt.Println("Finding block number by timestamp")
-	startBlock, err := getBlockByTimestamp(client, targetTimestamp)
-	if err != nil {
-		log.Fatal(err)
+// analysisStart returns unix time analysis starts from, asking user for the depth if it is not configured
+func analysisStart(cfg *appConfig) uint64 {
+	duration := cfg.hours
+	if duration == 0 {
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Print("Enter analysis depth in hours: ")
+		input, _ := reader.ReadString('\n')
+		var err error
+		duration, err = strconv.ParseInt(strings.TrimSpace(input), 10, 64)
+		if err != nil {
+			duration = 0
+		}
 	}
+	if duration <= 0 {
+		fatal("Input must be postive integer")
+	}
+	return uint64(time.Now().Unix() - duration*60*60) //user has input duration in hours
+}
 
-	fmt.Println("Reading swap logs")
-	dex0Trades, err := getLogs(client, dexes.dex0PairAddr, tokens, startBlock)
-	if err != nil {
-		log.Fatal(err)
+// reportOutput returns the file the report is written to, stdout unless output.file is set
+func reportOutput(cfg *appConfig) (*os.File, error) {
+	if cfg.outputPath == "" {
+		return os.Stdout, nil
 	}
-	dex1Trades, err := getLogs(client, dexes.dex1PairAddr, tokens, startBlock)
+	return os.Create(cfg.outputPath)
+}
+
+// runAnalysis validates configuration and prints synchronous swaps (or cross-chain comparison) of all configured chains
+func runAnalysis(ctx context.Context, cfg *appConfig, skipValidate bool) {
+	var store *tradeStore
+	if cfg.offline {
+		//there is nothing to validate on-chain when all data comes from the local store
+		var err error
+		store, err = openStore(cfg.storePath)
+		if err != nil {
+			fatal(err)
+		}
+		defer store.close()
+	} else if !skipValidate {
+		fmt.Println("Validating configuration")
+		if !validateConfig(ctx, cfg, os.Stdout) {
+			fatal("Fix the problems above or run with -skip-validate")
+		}
+	}
+
+	//every completed chunk of logs is recorded, so that a failed or interrupted scan can be resumed
+	var checkpoint *scanCheckpoint
+	if !cfg.offline {
+		var err error
+		checkpoint, err = openCheckpoint(cfg.checkpointPath, cfg.resume, cfg.fresh)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	//a resumed scan keeps the block ranges of the checkpoint, the depth is only asked for chains it has not started
+	resumed := checkpoint != nil && cfg.resume
+	for i := 0; resumed && i < len(cfg.chains); i++ {
+		_, resumed = checkpoint.chainRange(cfg.chains[i].profile.name)
+	}
+	var targetTimestamp uint64
+	if !resumed {
+		targetTimestamp = analysisStart(cfg)
+	}
+
+	out, err := reportOutput(cfg)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	if out != os.Stdout {
+		defer out.Close()
 	}
+	//the HTML report is a single page of all chains, so it is written once every chain is read
+	htmlReport := cfg.format == "html"
 
-	blocksTime := getBlocksTime(client, dex0Trades, dex1Trades)
+	//chains are analysed concurrently, each one into its own buffer so that reports do not interleave
+	var wg sync.WaitGroup
+	chains := make([]*chainTrades, len(cfg.chains))
+	reports := make([]bytes.Buffer, len(cfg.chains))
+	errs := make([]error, len(cfg.chains))
+	for i, chainCfg := range cfg.chains {
+		wg.Add(1)
+		go func(i int, chainCfg chainConfig) {
+			defer wg.Done()
+			chains[i], errs[i] = loadChainTrades(ctx, cfg, store, checkpoint, chainCfg, targetTimestamp)
+			//an interrupted chain still reports what has been read
+			if chains[i] != nil && !cfg.crossChain && !htmlReport {
+				if err := analyseChain(cfg, chains[i], &reports[i]); err != nil && errs[i] == nil {
+					errs[i] = err
+				}
+			}
+		}(i, chainCfg)
+	}
+	wg.Wait()
 
-	logSynchronousSwaps(dex0Trades, dexes.dex0Name, dex1Trades, dexes.dex1Name, blocksTime)
+	if ctx.Err() != nil {
+		var readChains []*chainTrades
+		for i := range reports {
+			reports[i].WriteTo(out)
+			if chains[i] != nil {
+				readChains = append(readChains, chains[i])
+			}
+		}
+		if cfg.crossChain && len(readChains) > 1 {
+			if err := analyseCrossChain(cfg, readChains, out); err != nil {
+				log.Print(err)
+			}
+		}
+		if htmlReport {
+			if err := writeHTMLReport(cfg, readChains, out); err != nil {
+				log.Print(err)
+			}
+		}
+		log.Print("Interrupted, results above are partial")
+		if checkpoint != nil {
+			checkpoint.close()
+			log.Print("Run again with -resume to continue the scan")
+		}
+		exit(130)
+	}
+	failed := false
+	for i, chainCfg := range cfg.chains {
+		if errs[i] != nil {
+			log.Printf("[%s] %v", chainCfg.profile.name, errs[i])
+			failed = true
+		}
+	}
+	if failed {
+		if checkpoint != nil {
+			checkpoint.close()
+			log.Print("Run again with -resume to continue the scan")
+		}
+		exit(1)
+	}
+	if checkpoint != nil {
+		checkpoint.remove()
+	}
 
+	switch {
+	case cfg.crossChain:
+		if err := analyseCrossChain(cfg, chains, out); err != nil {
+			fatal(err)
+		}
+	case htmlReport:
+		if err := writeHTMLReport(cfg, chains, out); err != nil {
+			fatal(err)
+		}
+	default:
+		for i := range reports {
+			reports[i].WriteTo(out)
+		}
+	}
+	if cfg.outputPath != "" {
+		fmt.Printf("Report written to %s\n", cfg.outputPath)
+	}
+}
+
+var commands = map[string]string{
+	"analyse":   "find swaps made on several DEXes in the same block (default)",
+	"backtest":  "replay swaps and reserves of past blocks and simulate cross-DEX arbitrage",
+	"cycles":    "find cyclic arbitrage like WETH→USDC→DAI→WETH across pools of all configured tokens",
+	"dashboard": "show live prices, spreads and swaps of the last hour of every pair full-screen",
+	"follow":    "print swaps made on several DEXes in the same block as new blocks arrive",
+	"serve":     "scan new blocks in the background and serve trades, spreads and opportunities as JSON over HTTP",
+	"sync":      "save swaps newer than the last synced block of every pool to the local store",
+	"validate":  "check configuration against the chain and report every problem found",
+}
+
+func usage(flags *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(flags.Output(), "  %-10s %s\n", name, commands[name])
+		}
+		fmt.Fprintln(flags.Output(), "\nFlags:")
+		flags.PrintDefaults()
+	}
+}
+
+// exit ends the process after printing RPC usage of the run, os.Exit would skip the report at the end of main
+func exit(code int) {
+	reportRPCUsage(os.Stderr)
+	os.Exit(code)
+}
+
+// fatal is log.Fatal printing RPC usage of the run before the process exits
+func fatal(v ...interface{}) {
+	log.Print(v...)
+	exit(1)
+}
+
+func main() {
+	command, args := "analyse", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.Usage = usage(flags)
+	configPath := flags.String("config", defaultConfigPath, "path to YAML configuration file, .env is used if it does not exist")
+	flags.String("chain", "ethereum", "comma separated list of chains to analyse: "+strings.Join(chainNames(), ", "))
+	flags.Int64("hours", 0, "analysis depth in hours, asked interactively if not set")
+	flags.Bool("crosschain", false, "compare the pair across chains by time buckets instead of DEXes within a block")
+	flags.Duration("bucket", 5*time.Minute, "time bucket of cross-chain comparison")
+	flags.Float64("min-spread", 0, "show only blocks where same side prices differ by at least this many bps")
+	flags.String("format", "table", "output format: "+strings.Join(outputFormatNames(), ", "))
+	flags.String("out", "", "file the report is written to instead of stdout")
+	flags.String("tz", "UTC", "IANA time zone of report timestamps, e.g. Europe/London or Local")
+	flags.Bool("links", true, "show explorer links of transactions")
+	flags.Int("concurrency", defaultFetchWorkers, "maximum number of concurrent RPC requests of bulk lookups")
+	flags.Float64("rate-limit", 0, "maximum requests per second to every RPC endpoint, 0 is unlimited")
+	flags.Int("batch-size", defaultBatchSize, "number of requests sent in one JSON-RPC batch, 1 disables batching")
+	flags.Uint64("confirmations", defaultConfirmations, "number of confirmations after which a block is final, newer blocks may be reorged")
+	flags.Duration("poll", defaultPollInterval, "interval of checking new blocks in follow mode")
+	flags.String("metrics", "", "address of the Prometheus /metrics endpoint of follow command, e.g. :9100")
+	flags.String("listen", defaultListenAddr, "address of the HTTP API of serve command")
+	flags.Bool("multicall", true, "pack contract reads into Multicall3 aggregate3 calls")
+	flags.String("cache-dir", defaultCacheDir(), "directory of the block timestamp cache shared by all runs, empty to disable")
+	flags.String("checkpoint", defaultCheckpointPath, "file recording progress of the scan, removed when the scan completes")
+	flags.Bool("resume", false, "continue the scan recorded in the checkpoint file")
+	flags.Bool("fresh", false, "discard the checkpoint of an unfinished scan and start over")
+	flags.Bool("offline", false, "analyse trades from the local store without any RPC calls")
+	flags.String("store", defaultStorePath, "path to the local SQLite trade store")
+	flags.Uint64("chunk", defaultChunkBlocks, "number of blocks read by a single eth_getLogs call, by sync and by every other command scanning logs")
+	flags.Float64("max-size", 0, "maximum base token bought by one arbitrage of backtest, 0 is unlimited")
+	flags.Uint64("latency", defaultLatencyBlocks, "number of blocks after which a backtest trade lands")
+	flags.Float64("gas-price", 0, "gas price in gwei paid by backtest trades")
+	flags.Int("max-hops", defaultMaxHops, "number of pools of the longest cycle searched by cycles command")
+	skipValidate := flags.Bool("skip-validate", false, "do not check configuration on-chain before analysis")
+	if _, ok := commands[command]; !ok {
+		log.Printf("Unknown command %q", command)
+		flags.Usage()
+		exit(2)
+	}
+	flags.Parse(args)
+
+	cfg, err := loadConfig(flags, *configPath)
+	if err != nil {
+		fatal(err)
+	}
+
+	//the first Ctrl-C stops the work gracefully, the second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	switch command {
+	case "validate":
+		if !validateConfig(ctx, cfg, os.Stdout) {
+			exit(1)
+		}
+	case "analyse":
+		runAnalysis(ctx, cfg, *skipValidate)
+	case "sync":
+		runSync(ctx, cfg)
+	case "follow":
+		runFollow(ctx, cfg)
+	case "serve":
+		runServe(ctx, cfg, *skipValidate)
+	case "dashboard":
+		runDashboard(ctx, cfg, *skipValidate)
+	case "backtest":
+		runBacktest(ctx, cfg, *skipValidate)
+	case "cycles":
+		runCycles(ctx, cfg, *skipValidate)
+	}
+	reportRPCUsage(os.Stderr)
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTrade(t *testing.T) {
+	//USDC/WETH, base is WETH as it has more decimals
+	usdcWeth := tokenStruct{tkn0Symbol: "USDC", tkn0Decimals: 6, tkn1Symbol: "WETH", tkn1Decimals: 18}
+	//WETH/USDT, base is WETH as token0
+	wethUsdt := tokenStruct{tkn0Symbol: "WETH", tkn0Decimals: 18, tkn1Symbol: "USDT", tkn1Decimals: 6}
+
+	tests := []struct {
+		name                                         string
+		tokens                                       tokenStruct
+		amount0In, amount1In, amount0Out, amount1Out float64
+		side                                         swapSides
+		price, size                                  float64
+	}{
+		{name: "token0 in", tokens: usdcWeth, amount0In: 3400, amount1Out: 2, side: sell, price: 1700, size: 2},
+		{name: "token1 in", tokens: usdcWeth, amount1In: 2, amount0Out: 3400, side: buy, price: 1700, size: 2},
+		{name: "base is token0", tokens: wethUsdt, amount0In: 2, amount1Out: 3400, side: sell, price: 1700, size: 2},
+		{name: "rounded", tokens: usdcWeth, amount0In: 1000, amount1Out: 3, side: sell, price: 333.33, size: 3},
+		//a flash swap repaid with part of the same token has a price of its net flow
+		{name: "both in, net token0 in", tokens: usdcWeth, amount0In: 3500, amount1In: 1, amount0Out: 100, amount1Out: 3, side: flash, price: 1700, size: 2},
+		{name: "both out", tokens: usdcWeth, amount0In: 3400, amount0Out: 3400, amount1Out: 2, amount1In: 2, side: flash},
+		{name: "same direction", tokens: usdcWeth, amount0In: 3400, amount1In: 2, side: flash},
+		{name: "only out", tokens: usdcWeth, amount1Out: 2, side: flash},
+		{name: "zero", tokens: usdcWeth, side: flash},
+		{name: "zero out", tokens: usdcWeth, amount0In: 3400, side: flash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trade := newTrade(tt.tokens, tt.amount0In, tt.amount1In, tt.amount0Out, tt.amount1Out)
+			if trade.swapSide != tt.side || trade.price != tt.price || trade.size != tt.size {
+				t.Errorf("want %v of %v at %v, got %v of %v at %v", tt.side, tt.size, tt.price, trade.swapSide, trade.size, trade.price)
+			}
+		})
+	}
+}
+
+func TestLogSynchronousSwaps(t *testing.T) {
+	cfg := &appConfig{location: time.UTC}
+	day1 := uint64(time.Date(2024, 1, 1, 23, 59, 0, 0, time.UTC).Unix())
+	day2 := uint64(time.Date(2024, 1, 2, 0, 1, 0, 0, time.UTC).Unix())
+	trade := func(side swapSides, price float64, logIndex uint) tradeStruct {
+		return tradeStruct{price: price, size: 1, swapSide: side, logIndex: logIndex}
+	}
+
+	tests := []struct {
+		name       string
+		venues     []venueTrades
+		blocksTime map[uint64]uint64
+		//want lists parts of the output in the order they are printed
+		want   []string
+		absent []string
+	}{
+		{
+			name: "blocks by number",
+			venues: []venueTrades{
+				{name: "A", trades: map[uint64][]tradeStruct{10: {trade(buy, 100, 0)}, 20: {trade(buy, 110, 0)}, 30: {trade(buy, 120, 0)}}},
+				{name: "B", trades: map[uint64][]tradeStruct{10: {trade(buy, 101, 1)}, 20: {trade(buy, 111, 1)}, 30: {trade(buy, 121, 1)}}},
+			},
+			blocksTime: map[uint64]uint64{30: day1, 10: day1, 20: day1},
+			want:       []string{"10 2024-01-01", "20 2024-01-01", "30 2024-01-01"},
+			absent:     []string{"--"},
+		},
+		{
+			name: "trades by log index",
+			venues: []venueTrades{
+				{name: "A", trades: map[uint64][]tradeStruct{10: {trade(sell, 100, 7), trade(sell, 103, 2)}}},
+				{name: "B", trades: map[uint64][]tradeStruct{10: {trade(sell, 101, 5)}}},
+			},
+			blocksTime: map[uint64]uint64{10: day1},
+			want:       []string{"103.00", "101.00", "100.00"},
+		},
+		{
+			name: "day separator",
+			venues: []venueTrades{
+				{name: "A", trades: map[uint64][]tradeStruct{10: {trade(buy, 100, 0)}, 20: {trade(buy, 100, 0)}}},
+				{name: "B", trades: map[uint64][]tradeStruct{10: {trade(buy, 101, 1)}, 20: {trade(buy, 101, 1)}}},
+			},
+			blocksTime: map[uint64]uint64{10: day1, 20: day2},
+			want:       []string{"10 2024-01-01", "-- Tue 02 Jan 2024 --", "20 2024-01-02"},
+			absent:     []string{"-- Mon"},
+		},
+		{
+			name: "separator only between printed blocks",
+			venues: []venueTrades{
+				{name: "A", trades: map[uint64][]tradeStruct{10: {trade(buy, 100, 0)}, 20: {trade(buy, 100, 0)}}},
+				{name: "B", trades: map[uint64][]tradeStruct{20: {trade(buy, 101, 1)}}},
+			},
+			blocksTime: map[uint64]uint64{10: day1, 20: day2},
+			want:       []string{"20 2024-01-02"},
+			absent:     []string{"--", "10 2024-01-01"},
+		},
+		{
+			name: "flash swaps left out",
+			venues: []venueTrades{
+				{name: "A", trades: map[uint64][]tradeStruct{10: {trade(buy, 100, 0)}}},
+				{name: "B", trades: map[uint64][]tradeStruct{10: {trade(flash, 101, 1)}}},
+			},
+			blocksTime: map[uint64]uint64{10: day1},
+			absent:     []string{"10 2024-01-01"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			logSynchronousSwaps(&out, chainProfile{}, tt.venues, tt.blocksTime, cfg)
+			rest := out.String()
+			for _, part := range tt.want {
+				i := strings.Index(rest, part)
+				if i < 0 {
+					t.Fatalf("want %q after the parts before it, got\n%s", part, out.String())
+				}
+				rest = rest[i+len(part):]
+			}
+			for _, part := range tt.absent {
+				if strings.Contains(out.String(), part) {
+					t.Errorf("want no %q, got\n%s", part, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds metrics of the tool only, without Go runtime metrics of the default registry
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	lastPriceGauge = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dpr_last_trade_price",
+		Help: "Price of the last plain swap of the pool in the quote token.",
+	}, []string{"chain", "pair", "dex"})
+	midPriceGauge = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dpr_mid_price",
+		Help: "Marginal price of the pool from its reserves at the head block, in the quote token.",
+	}, []string{"chain", "pair", "dex"})
+	spreadGauge = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dpr_spread_bps",
+		Help: "Difference between the highest and the lowest mid-price of the pair across DEXes in bps.",
+	}, []string{"chain", "pair"})
+	headBlockGauge = promauto.With(metricsRegistry).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dpr_head_block",
+		Help: "Latest block seen by follow mode.",
+	}, []string{"chain"})
+
+	swapsCounter = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dpr_swaps_total",
+		Help: "Swaps of final blocks by side (buy, sell, flash).",
+	}, []string{"chain", "pair", "dex", "side"})
+	volumeCounter = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dpr_volume_total",
+		Help: "Volume of plain swaps of final blocks in the base token.",
+	}, []string{"chain", "pair", "dex"})
+	opportunitiesCounter = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dpr_opportunities_total",
+		Help: "Same block spreads of final blocks of at least the minimum spread, by DEX to buy on and DEX to sell on.",
+	}, []string{"chain", "pair", "buy_dex", "sell_dex"})
+
+	rpcDurationHistogram = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dpr_rpc_request_duration_seconds",
+		Help:    "Duration of HTTP calls to RPC endpoints by JSON-RPC method (batch for batches) and result.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"chain", "method", "result"})
+	rpcErrorsCounter = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "dpr_rpc_errors_total",
+		Help: "Failed HTTP calls to RPC endpoints by HTTP status or network.",
+	}, []string{"chain", "reason"})
+)
+
+// observeRPCCall records a single HTTP call to an RPC endpoint
+func observeRPCCall(chain, method string, started time.Time, resp *http.Response, err error) {
+	result := "ok"
+	switch {
+	case err != nil:
+		result = "error"
+		rpcErrorsCounter.WithLabelValues(chain, "network").Inc()
+	case resp.StatusCode >= 400:
+		result = "error"
+		rpcErrorsCounter.WithLabelValues(chain, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	rpcDurationHistogram.WithLabelValues(chain, method, result).Observe(time.Since(started).Seconds())
+}
+
+// observeFinalTrades counts swaps, volume and opportunities of a pair once its block is final
+func observeFinalTrades(cfg *appConfig, chain string, pair pairTrades) {
+	pairLabel := pairName(pair.tokens)
+	for _, venue := range pair.venues {
+		for _, trades := range venue.trades {
+			for _, trade := range trades {
+				//route quotes are not swaps, they count only towards opportunities
+				if trade.synthetic() {
+					continue
+				}
+				swapsCounter.WithLabelValues(chain, pairLabel, venue.name, trade.swapSide.String()).Inc()
+				if trade.swapSide != flash {
+					volumeCounter.WithLabelValues(chain, pairLabel, venue.name).Add(trade.size)
+				}
+			}
+		}
+	}
+	for _, spread := range blockSpreads(pair.venues) {
+		if spread.isOpportunity(cfg.minSpreadBps) {
+			opportunitiesCounter.WithLabelValues(chain, pairLabel, spread.low.venue, spread.high.venue).Inc()
+		}
+	}
+}
+
+// observeLastTrades sets the last trade price of every venue having plain swaps in the given trades
+func observeLastTrades(chain string, pair pairTrades) {
+	pairLabel := pairName(pair.tokens)
+	for _, venue := range pair.venues {
+		if last, _, ok := venue.lastTrade(); ok {
+			lastPriceGauge.WithLabelValues(chain, pairLabel, venue.name).Set(last.price)
+		}
+	}
+}
+
+// observeMidPrices sets mid-prices of the pools of a pair and the spread between them.
+// Pools whose reserves could not be read keep their previous mid-price and are left out of the spread,
+// which is removed while fewer than two pools are priced
+func observeMidPrices(chain string, pair pairStruct, reserves []poolReserves, errs []error) {
+	pairLabel := pairName(pair.tokens)
+	var minPrice, maxPrice float64
+	priced := 0
+	for i, venue := range pair.venues {
+		if errs[i] != nil {
+			continue
+		}
+		price := reserves[i].midPrice(pair.tokens)
+		if price <= 0 {
+			continue
+		}
+		midPriceGauge.WithLabelValues(chain, pairLabel, venue.name).Set(price)
+		if priced == 0 || price < minPrice {
+			minPrice = price
+		}
+		if priced == 0 || price > maxPrice {
+			maxPrice = price
+		}
+		priced++
+	}
+	if priced > 1 {
+		spreadGauge.WithLabelValues(chain, pairLabel).Set(spreadBps(minPrice, maxPrice))
+	} else {
+		//a spread of pools no longer priced would be scraped as current
+		spreadGauge.DeleteLabelValues(chain, pairLabel)
+	}
+}
+
+// serveMetrics exposes /metrics on addr in the background and returns the actual address
+func serveMetrics(addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("metrics: %v", err)
+		}
+	}()
+	return listener.Addr(), nil
+}
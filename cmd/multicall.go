@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"dex-price-reader/contract-api/multicall3"
+)
+
+// Multicall3 is deployed at the same address on all supported chains
+var multicall3Addr = common.HexToAddress("0xcA11bde05977b3631167028862bE2a173976CA11")
+
+// multicallSize is the number of calls packed into one aggregate3, small enough for the eth_call gas cap of providers
+const multicallSize = 250
+
+var (
+	errCallReverted = errors.New("call reverted")
+	errEmptyOutput  = errors.New("empty output, the address has no contract code")
+)
+
+// contractCall is a single read of contract state, output and err are set by readContracts
+type contractCall struct {
+	target common.Address
+	data   []byte
+	output []byte
+	err    error
+}
+
+// readContracts executes the calls at the given block (nil for latest). With Multicall3 enabled the calls are
+// packed into aggregate3 calls, otherwise every call is a separate request of a JSON-RPC batch.
+// A failure of a single call is reported in its err field and does not fail the others
+func readContracts(ctx context.Context, client *rpc.Client, opts fetchOptions, blockNum *big.Int, calls []*contractCall) error {
+	var err error
+	if opts.multicall == (common.Address{}) {
+		err = readContractsBatch(ctx, client, opts, blockNum, calls)
+	} else {
+		err = readContractsMulticall(ctx, client, opts, blockNum, calls)
+	}
+	if err != nil {
+		return err
+	}
+	for _, call := range calls {
+		if call.err == nil && len(call.output) == 0 {
+			call.err = errEmptyOutput
+		}
+	}
+	return nil
+}
+
+func readContractsBatch(ctx context.Context, client *rpc.Client, opts fetchOptions, blockNum *big.Int, calls []*contractCall) error {
+	outputs := make([]hexutil.Bytes, len(calls))
+	elems := make([]rpc.BatchElem, len(calls))
+	for i, call := range calls {
+		elems[i] = callElem(ethereum.CallMsg{To: &call.target, Data: call.data}, blockNum, &outputs[i])
+	}
+	if err := batchCall(ctx, client, opts, elems); err != nil {
+		return err
+	}
+	for i, call := range calls {
+		call.output, call.err = outputs[i], elems[i].Error
+	}
+	return nil
+}
+
+func readContractsMulticall(ctx context.Context, client *rpc.Client, opts fetchOptions, blockNum *big.Int, calls []*contractCall) error {
+	multicallAbi, err := multicall3.Multicall3MetaData.GetAbi()
+	if err != nil {
+		return err
+	}
+
+	var chunks [][]*contractCall
+	for start := 0; start < len(calls); start += multicallSize {
+		end := start + multicallSize
+		if end > len(calls) {
+			end = len(calls)
+		}
+		chunks = append(chunks, calls[start:end])
+	}
+	outputs := make([]hexutil.Bytes, len(chunks))
+	elems := make([]rpc.BatchElem, len(chunks))
+	for i, chunk := range chunks {
+		//allowFailure lets the other calls succeed when one of them reverts
+		packed := make([]multicall3.Multicall3Call3, len(chunk))
+		for j, call := range chunk {
+			packed[j] = multicall3.Multicall3Call3{Target: call.target, AllowFailure: true, CallData: call.data}
+		}
+		data, err := multicallAbi.Pack("aggregate3", packed)
+		if err != nil {
+			return err
+		}
+		elems[i] = callElem(ethereum.CallMsg{To: &opts.multicall, Data: data}, blockNum, &outputs[i])
+	}
+	if err := batchCall(ctx, client, opts, elems); err != nil {
+		return err
+	}
+
+	for i, chunk := range chunks {
+		results, err := unpackAggregate3(multicallAbi, elems[i].Error, outputs[i], len(chunk))
+		for j, call := range chunk {
+			switch {
+			case err != nil:
+				call.err = err
+			case !results[j].Success:
+				call.err = errCallReverted
+			default:
+				call.output = results[j].ReturnData
+			}
+		}
+	}
+	return nil
+}
+
+// unpackAggregate3 decodes results of aggregate3, there must be one result per call
+func unpackAggregate3(multicallAbi *abi.ABI, callErr error, output []byte, calls int) ([]multicall3.Multicall3Result, error) {
+	if callErr != nil {
+		return nil, fmt.Errorf("multicall: %w", callErr)
+	}
+	values, err := multicallAbi.Unpack("aggregate3", output)
+	if err != nil {
+		return nil, fmt.Errorf("multicall: %w", err)
+	}
+	results := *abi.ConvertType(values[0], new([]multicall3.Multicall3Result)).(*[]multicall3.Multicall3Result)
+	if len(results) != calls {
+		return nil, fmt.Errorf("multicall: %d results of %d calls", len(results), calls)
+	}
+	return results, nil
+}
+
+// poolsReserves reads reserves of all venue pools at the given block (nil for latest) in as few requests as possible
+func poolsReserves(ctx context.Context, client *rpc.Client, opts fetchOptions, venues []venueAdapter,
+	blockNum *big.Int) ([]poolReserves, []error, error) {
+
+	calls := make([]*contractCall, len(venues))
+	decoders := make([]reservesDecoder, len(venues))
+	for i, venue := range venues {
+		msg, decode := venue.reservesCall()
+		calls[i], decoders[i] = &contractCall{target: *msg.To, data: msg.Data}, decode
+	}
+	if err := readContracts(ctx, client, opts, blockNum, calls); err != nil {
+		return nil, nil, err
+	}
+
+	reserves := make([]poolReserves, len(venues))
+	errs := make([]error, len(venues))
+	for i, call := range calls {
+		if call.err != nil {
+			errs[i] = call.err
+			continue
+		}
+		reserves[i].reserve0, reserves[i].reserve1, errs[i] = decoders[i](call.output)
+	}
+	return reserves, errs, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// venuePrice is a trade of one venue taking part in a spread
+type venuePrice struct {
+	venue  string
+	price  float64
+	size   float64
+	txHash common.Hash
+}
+
+// blockSpread is the price difference of same side trades made on different venues in one block
+type blockSpread struct {
+	blockNum uint64
+	side     swapSides
+	//low is the cheapest trade of the block, high is the most expensive one on any other venue
+	low  venuePrice
+	high venuePrice
+	bps  float64
+}
+
+// opportunity estimates the trade of buying where the pair was cheap and selling where it was expensive,
+// limited by the smaller of the two observed trades
+func (s blockSpread) opportunity() (size, profit float64) {
+	size = math.Min(s.low.size, s.high.size)
+	return size, size * (s.high.price - s.low.price)
+}
+
+// isOpportunity tells whether the spread is wide enough to be worth trading, equal prices never are
+func (s blockSpread) isOpportunity(minSpreadBps float64) bool {
+	return s.bps > 0 && s.bps >= minSpreadBps
+}
+
+// blockSpreads returns spreads of every block and side having priced trades on at least two venues,
+// ordered by block number with buys first
+func blockSpreads(venues []venueTrades) []blockSpread {
+	blockNums := sharedBlocks(venues)
+	sort.Slice(blockNums, func(i, j int) bool { return blockNums[i] < blockNums[j] })
+
+	var spreads []blockSpread
+	for _, blockNum := range blockNums {
+		for _, side := range []swapSides{buy, sell} {
+			var trades []venuePrice
+			for _, venue := range venues {
+				for _, trade := range venue.trades[blockNum] {
+					if trade.swapSide == side && trade.price > 0 {
+						trades = append(trades, venuePrice{venue: venue.name, price: trade.price, size: trade.size, txHash: trade.txHash})
+					}
+				}
+			}
+			if len(trades) < 2 {
+				continue
+			}
+			low := trades[0]
+			for _, trade := range trades[1:] {
+				if trade.price < low.price {
+					low = trade
+				}
+			}
+			//the spread between two trades of the same venue can not be traded
+			var high *venuePrice
+			for i, trade := range trades {
+				if trade.venue != low.venue && (high == nil || trade.price > high.price) {
+					high = &trades[i]
+				}
+			}
+			if high == nil {
+				continue
+			}
+			spreads = append(spreads, blockSpread{blockNum: blockNum, side: side, low: low, high: *high, bps: spreadBps(low.price, high.price)})
+		}
+	}
+	return spreads
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// routeStruct prices the pair on a DEX without a direct pool through pools of intermediate tokens,
+// e.g. WBTC→WETH→USDC through the WBTC/WETH and WETH/USDC pools of the DEX
+type routeStruct struct {
+	name string
+	kind string
+	//legs in the order of the configured path, from one token of the pair to the other
+	legs []routeLeg
+}
+
+// routeLeg is one pool of the route and the token going into it
+type routeLeg struct {
+	tokens  tokenStruct
+	from    common.Address
+	adapter venueAdapter
+}
+
+// routeTokens returns tokens traded through by all routes of the pair
+func (p pairConfig) routeTokens() []common.Address {
+	var tokens []common.Address
+	for _, path := range p.routes {
+		tokens = append(tokens, path[1:len(path)-1]...)
+	}
+	return tokens
+}
+
+// resolveRoute finds pools of every leg of the first path fully traded on the DEX, ok is false if there is none.
+// Paths missing a pool are skipped, any other error is returned
+func resolveRoute(ctx context.Context, client *ethclient.Client, dexCfg venueConfig, paths [][]common.Address,
+	metadata map[common.Address]tokenMeta) (route routeStruct, ok bool, err error) {
+
+search:
+	for _, path := range paths {
+		route = routeStruct{}
+		for i := 0; i+1 < len(path); i++ {
+			tkn0Addr, tkn1Addr := path[i], path[i+1]
+			//pools order their tokens by address
+			if bytes.Compare(tkn0Addr.Bytes(), tkn1Addr.Bytes()) > 0 {
+				tkn0Addr, tkn1Addr = tkn1Addr, tkn0Addr
+			}
+			tokens := newTokens(tkn0Addr, tkn1Addr, metadata)
+			adapter, err := newVenue(client, dexCfg)
+			if err != nil {
+				return routeStruct{}, false, err
+			}
+			if err := adapter.resolvePool(ctx, tokens); errors.Is(err, errNoPool) {
+				continue search
+			} else if err != nil {
+				return routeStruct{}, false, fmt.Errorf("%s/%s: %w", tokens.tkn0Symbol, tokens.tkn1Symbol, err)
+			}
+			route.legs = append(route.legs, routeLeg{tokens: tokens, from: path[i], adapter: adapter})
+		}
+		via := make([]string, 0, len(path)-2)
+		for _, tokenAddr := range path[1 : len(path)-1] {
+			via = append(via, metadata[tokenAddr].symbol)
+		}
+		route.name, route.kind = dexCfg.name+" via "+strings.Join(via, "→"), dexCfg.kind
+		return route, true, nil
+	}
+	return routeStruct{}, false, nil
+}
+
+// curve composes swaps of all legs from the given token of the pair to the other one, reserves are by leg
+func (r routeStruct) curve(from common.Address, reserves []poolReserves) swapCurve {
+	legs := make([]int, len(r.legs))
+	for i := range legs {
+		legs[i] = i
+	}
+	//the path is configured in one direction, swaps the other way go through the legs backwards
+	if r.legs[0].from != from {
+		for i, j := 0, len(legs)-1; i < j; i, j = i+1, j-1 {
+			legs[i], legs[j] = legs[j], legs[i]
+		}
+	}
+	var curve swapCurve
+	for i, leg := range legs {
+		tokens := r.legs[leg].tokens
+		in, out := reserves[leg].reserve0, reserves[leg].reserve1
+		//the output token of the leg goes into the next one
+		next := tokens.tkn1Addr
+		if from != tokens.tkn0Addr {
+			in, out, next = out, in, tokens.tkn0Addr
+		}
+		swap := newSwapCurve(in, out, r.legs[leg].adapter.fee())
+		if i == 0 {
+			curve = swap
+		} else {
+			curve = curve.then(swap)
+		}
+		from = next
+	}
+	return curve
+}
+
+// readRouteTrades prices the route in every block where the pair traded on the venues. For each side of the block
+// the route gets a synthetic trade of the largest size traded on that side, priced by swapping that size through
+// the legs at their reserves at the end of the block, fees included
+func readRouteTrades(ctx context.Context, client *rpc.Client, opts fetchOptions, tokens tokenStruct, route routeStruct,
+	venues []venueTrades) (map[uint64][]tradeStruct, error) {
+
+	//sizes by block and side, sell and buy are 0 and 1
+	sizes := make(map[uint64]*[2]float64)
+	lastLog := make(map[uint64]uint)
+	for _, venue := range venues {
+		for blockNum, trades := range venue.trades {
+			for _, trade := range trades {
+				if trade.logIndex > lastLog[blockNum] {
+					lastLog[blockNum] = trade.logIndex
+				}
+				if trade.swapSide == flash || trade.size <= 0 {
+					continue
+				}
+				if sizes[blockNum] == nil {
+					sizes[blockNum] = new([2]float64)
+				}
+				sizes[blockNum][trade.swapSide] = math.Max(sizes[blockNum][trade.swapSide], trade.size)
+			}
+		}
+	}
+	blockNums := make([]uint64, 0, len(sizes))
+	for blockNum := range sizes {
+		blockNums = append(blockNums, blockNum)
+	}
+
+	legReserves := make([]map[uint64]poolReserves, len(route.legs))
+	for i, leg := range route.legs {
+		reserves, err := batchReserves(ctx, client, leg.adapter, blockNums, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s reserves: %w", leg.tokens.tkn0Symbol, leg.tokens.tkn1Symbol, err)
+		}
+		legReserves[i] = reserves
+	}
+
+	baseAddr := tokens.tkn1Addr
+	if tokens.tkn0Decimals > tokens.tkn1Decimals {
+		baseAddr = tokens.tkn0Addr
+	}
+	trades := make(map[uint64][]tradeStruct)
+	for blockNum, blockSizes := range sizes {
+		reserves := make([]poolReserves, len(route.legs))
+		priced := true
+		for i := range route.legs {
+			reserves[i] = legReserves[i][blockNum]
+			priced = priced && reserves[i].reserve0 > 0 && reserves[i].reserve1 > 0
+		}
+		if !priced {
+			continue
+		}
+		for side, size := range blockSizes {
+			if size <= 0 {
+				continue
+			}
+			//as in newTrade, token0 goes into the pool on sells and token1 on buys
+			from := tokens.tkn1Addr
+			if swapSides(side) == sell {
+				from = tokens.tkn0Addr
+			}
+			//size is in the base token, which is either the input or the output of the swap
+			var price float64
+			if from == baseAddr {
+				price = route.curve(from, reserves).out(size) / size
+			} else {
+				quoteIn, ok := route.curve(from, reserves).in(size)
+				if !ok {
+					continue
+				}
+				price = quoteIn / size
+			}
+			//the price is the state at the end of the block, so it is listed after the block's swaps
+			trades[blockNum] = append(trades[blockNum], tradeStruct{
+				price: math.Round(price*100) / 100, size: size, swapSide: swapSides(side), logIndex: lastLog[blockNum],
+			})
+		}
+	}
+	return trades, nil
+}
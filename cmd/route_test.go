@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// amountIn is the input a pool needs to give amountOut, the inverse of amountOut
+func amountIn(amountOut, reserveIn, reserveOut, fee float64) float64 {
+	return reserveIn * amountOut / ((reserveOut - amountOut) * (1 - fee))
+}
+
+func TestReadRouteTrades(t *testing.T) {
+	server := newFakeNode(t, 100)
+	client, err := rpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	fakeWBTC := common.HexToAddress("0x2260FAC5E5542a773Aa44fBCfeDf7C193bc2C599")
+	metadata := map[common.Address]tokenMeta{fakeWBTC: {symbol: "WBTC", decimals: 8}}
+	for addr, meta := range fakeTokens {
+		metadata[addr] = meta
+	}
+	tokens := newTokens(fakeUSDC, fakeWETH, metadata)
+	//the routed pools are DAI/USDC of 1e6/1e6 and DAI/WETH of 1750000/1000
+	baseInPrice := func(size float64) float64 {
+		daiOut := amountOut(size, 1000, 1750000, 0.003)
+		return amountOut(daiOut, 1e6, 1e6, 0.003) / size
+	}
+	quoteInPrice := func(size float64) float64 {
+		daiIn := amountIn(size, 1750000, 1000, 0.003)
+		return amountIn(daiIn, 1e6, 1e6, 0.003) / size
+	}
+	venues := []venueTrades{{name: "Uniswap", trades: map[uint64][]tradeStruct{
+		10: {{price: 1700, size: 2, swapSide: sell, logIndex: 4}},
+		20: {{price: 1700, size: 1, swapSide: buy}, {price: 1700, size: 3, swapSide: buy, logIndex: 1}, {size: 50, swapSide: flash, logIndex: 2}},
+	}}}
+
+	tests := []struct {
+		name    string
+		factory common.Address
+		paths   [][]common.Address
+		ok      bool
+		wantErr bool
+		via     string
+	}{
+		{name: "path from the quote token", paths: [][]common.Address{{fakeUSDC, fakeDAI, fakeWETH}}, ok: true, via: "Routed via DAI"},
+		{name: "path from the base token", paths: [][]common.Address{{fakeWETH, fakeDAI, fakeUSDC}}, ok: true, via: "Routed via DAI"},
+		{name: "first path without a pool", paths: [][]common.Address{{fakeUSDC, fakeWBTC, fakeWETH}, {fakeUSDC, fakeDAI, fakeWETH}}, ok: true, via: "Routed via DAI"},
+		{name: "no path with pools", paths: [][]common.Address{{fakeUSDC, fakeWBTC, fakeWETH}}},
+		{name: "lookup error", factory: common.HexToAddress("0x9999999999999999999999999999999999999999"), paths: [][]common.Address{{fakeUSDC, fakeDAI, fakeWETH}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dexCfg := venueConfig{name: "Routed", kind: "uniswapv2", factory: fakeRouted}
+			if tt.factory != (common.Address{}) {
+				dexCfg.factory = tt.factory
+			}
+			route, ok, err := resolveRoute(context.Background(), ethclient.NewClient(client), dexCfg, tt.paths, metadata)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("want ok %v, got %+v %v", tt.ok, route, ok)
+			}
+			if !ok {
+				return
+			}
+			if route.name != tt.via || len(route.legs) != 2 {
+				t.Errorf("want %s over 2 legs, got %s over %d", tt.via, route.name, len(route.legs))
+			}
+			trades, err := readRouteTrades(context.Background(), client, fetchOptions{workers: 1, batchSize: 10}, tokens, route, venues)
+			if err != nil {
+				t.Fatal(err)
+			}
+			//as in newTrade, USDC goes in on sells and WETH on buys
+			want := map[uint64]tradeStruct{
+				10: {price: math.Round(quoteInPrice(2)*100) / 100, size: 2, swapSide: sell, logIndex: 4},
+				20: {price: math.Round(baseInPrice(3)*100) / 100, size: 3, swapSide: buy, logIndex: 2},
+			}
+			if len(trades) != len(want) {
+				t.Fatalf("want trades of blocks 10 and 20, got %+v", trades)
+			}
+			for blockNum, trade := range want {
+				if len(trades[blockNum]) != 1 || trades[blockNum][0] != trade {
+					t.Errorf("block %d: want %+v, got %+v", blockNum, trade, trades[blockNum])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/time/rate"
+)
+
+// rpcEndpoint is one RPC provider of a chain with its own request budget
+type rpcEndpoint struct {
+	url     *url.URL
+	limiter *rate.Limiter
+}
+
+// rpcTransport sends JSON-RPC requests over HTTP to the first healthy endpoint of the chain.
+// Rate limited and failed requests are retried with jittered backoff on the next endpoint.
+// It is the only layer retrying whole requests, requests it gave up on fail with errRetriesExhausted
+type rpcTransport struct {
+	endpoints []rpcEndpoint
+	opts      fetchOptions
+	base      http.RoundTripper
+	usage     *rpcUsage
+
+	mu      sync.Mutex
+	current int
+}
+
+// rpcUsage counts requests of one chain by JSON-RPC method
+type rpcUsage struct {
+	chain    string
+	mu       sync.Mutex
+	requests map[string]int
+	//HTTP round trips, a batch carries many requests in one
+	roundTrips int
+	retries    int
+	failovers  int
+}
+
+// rpcUsages keeps usage of every dialed chain to report it at the end of the run
+var rpcUsages struct {
+	mu     sync.Mutex
+	chains map[string]*rpcUsage
+}
+
+func chainUsage(chain string) *rpcUsage {
+	rpcUsages.mu.Lock()
+	defer rpcUsages.mu.Unlock()
+	if rpcUsages.chains == nil {
+		rpcUsages.chains = make(map[string]*rpcUsage)
+	}
+	usage, ok := rpcUsages.chains[chain]
+	if !ok {
+		usage = &rpcUsage{chain: chain, requests: make(map[string]int)}
+		rpcUsages.chains[chain] = usage
+	}
+	return usage
+}
+
+// dialChain connects to the RPC endpoints of the chain. Plain HTTP(S) endpoints go through
+// rpcTransport, a single websocket or IPC endpoint is dialed directly. The raw RPC client
+// is returned as well for batch requests
+func dialChain(cfg chainConfig) (*ethclient.Client, *rpc.Client, error) {
+	urls := append([]string{cfg.rpcURL}, cfg.fallbackURLs...)
+	if len(urls) == 1 && !strings.HasPrefix(urls[0], "http") {
+		client, err := rpc.Dial(urls[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		return ethclient.NewClient(client), client, nil
+	}
+
+	transport := &rpcTransport{opts: cfg.fetch, base: http.DefaultTransport, usage: chainUsage(cfg.profile.name)}
+	for _, rawURL := range urls {
+		endpointURL, err := url.Parse(rawURL)
+		if err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") {
+			return nil, nil, fmt.Errorf("rpc endpoint %d of %s: only http(s) urls can be used with fallbacks", len(transport.endpoints), cfg.profile.name)
+		}
+		limit, burst := rate.Inf, 0
+		if cfg.rateLimit > 0 {
+			limit, burst = rate.Limit(cfg.rateLimit), cfg.rateBurst
+		}
+		transport.endpoints = append(transport.endpoints, rpcEndpoint{url: endpointURL, limiter: rate.NewLimiter(limit, burst)})
+	}
+
+	client, err := rpc.DialHTTPWithClient(cfg.rpcURL, &http.Client{Transport: transport})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ethclient.NewClient(client), client, nil
+}
+
+// errRetriesExhausted marks a request the transport has already retried, callers must not retry it again
+var errRetriesExhausted = errors.New("rpc retries exhausted")
+
+// retryableStatus tells whether the provider may answer differently a bit later
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500
+}
+
+// rpcErrorRateLimited is the JSON-RPC error code of "limit exceeded" (EIP-1474)
+const rpcErrorRateLimited = -32005
+
+// rateLimitedError tells whether a JSON-RPC error is a rate limit, which providers answer with HTTP 200
+// and code -32005, 429 or a plain -32000 with the reason in the message
+func rateLimitedError(code int, message string) bool {
+	message = strings.ToLower(message)
+	return code == rpcErrorRateLimited || code == http.StatusTooManyRequests ||
+		strings.Contains(message, "rate limit") || strings.Contains(message, "too many requests")
+}
+
+// rateLimitedBody tells whether the single or any batch response of the body is a rate limit error
+func rateLimitedBody(body []byte) bool {
+	type message struct {
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	//most responses carry no error and are not decoded twice
+	if !bytes.Contains(body, []byte(`"error"`)) {
+		return false
+	}
+	var batch []message
+	if err := json.Unmarshal(body, &batch); err != nil {
+		var single message
+		if json.Unmarshal(body, &single) != nil {
+			return false
+		}
+		batch = []message{single}
+	}
+	for _, msg := range batch {
+		if msg.Error != nil && rateLimitedError(msg.Error.Code, msg.Error.Message) {
+			return true
+		}
+	}
+	return false
+}
+
+// readResponse buffers the body of the response so that it can be inspected and still read by the caller
+func readResponse(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return body, err
+}
+
+func (t *rpcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	method := t.usage.count(body)
+
+	ctx := req.Context()
+	delay := t.opts.backoff
+	for attempt := 0; ; attempt++ {
+		t.mu.Lock()
+		index := t.current
+		t.mu.Unlock()
+		endpoint := t.endpoints[index]
+
+		if err := endpoint.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		attemptReq := req.Clone(ctx)
+		attemptReq.URL = endpoint.url
+		attemptReq.Host = endpoint.url.Host
+		attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+		attemptReq.ContentLength = int64(len(body))
+		started := time.Now()
+		resp, err := t.base.RoundTrip(attemptReq)
+		if ctx.Err() == nil {
+			observeRPCCall(t.usage.chain, method, started, resp, err)
+		}
+
+		if err == nil && !retryableStatus(resp.StatusCode) {
+			//rate limits answered with HTTP 200 are only seen in the JSON-RPC error of the body
+			var respBody []byte
+			if respBody, err = readResponse(resp); err == nil && !rateLimitedBody(respBody) {
+				return resp, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return resp, err
+		}
+		if attempt >= t.opts.retries {
+			return nil, retriesExhausted(attempt+1, resp, err)
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		t.failover(index)
+
+		//jitter keeps concurrent workers from retrying in lockstep
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay)+1))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// retriesExhausted is the error of the last attempt with the provider's answer, so that the caller sees the real error
+func retriesExhausted(attempts int, resp *http.Response, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w after %d attempts: %v", errRetriesExhausted, attempts, err)
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	resp.Body.Close()
+	return fmt.Errorf("%w after %d attempts: %s: %s", errRetriesExhausted, attempts, resp.Status, bytes.TrimSpace(body))
+}
+
+// failover switches to the next endpoint unless another request has already done it
+func (t *rpcTransport) failover(failed int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.usage.retried(len(t.endpoints) > 1 && t.current == failed)
+	if t.current == failed {
+		t.current = (t.current + 1) % len(t.endpoints)
+	}
+}
+
+// count records methods of a single or batch JSON-RPC request and returns the method, batch for batches
+func (u *rpcUsage) count(body []byte) string {
+	type message struct {
+		Method string `json:"method"`
+	}
+	var batch []message
+	if err := json.Unmarshal(body, &batch); err != nil {
+		var single message
+		if json.Unmarshal(body, &single) != nil {
+			return "unknown"
+		}
+		batch = []message{single}
+	}
+	method := "batch"
+	if len(batch) == 1 {
+		method = batch[0].Method
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.roundTrips++
+	for _, msg := range batch {
+		u.requests[msg.Method]++
+	}
+	return method
+}
+
+func (u *rpcUsage) retried(failover bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.retries++
+	if failover {
+		u.failovers++
+	}
+}
+
+// reportRPCUsage prints number of requests per method of every chain
+func reportRPCUsage(out io.Writer) {
+	rpcUsages.mu.Lock()
+	defer rpcUsages.mu.Unlock()
+	chains := make([]string, 0, len(rpcUsages.chains))
+	for chain := range rpcUsages.chains {
+		chains = append(chains, chain)
+	}
+	sort.Strings(chains)
+
+	for _, chain := range chains {
+		usage := rpcUsages.chains[chain]
+		usage.mu.Lock()
+		methods := make([]string, 0, len(usage.requests))
+		total := 0
+		for method, count := range usage.requests {
+			methods = append(methods, fmt.Sprintf("%s %d", method, count))
+			total += count
+		}
+		sort.Strings(methods)
+		fmt.Fprintf(out, "[%s] RPC requests: %d (%s) in %d HTTP calls, retries: %d, failovers: %d\n",
+			chain, total, strings.Join(methods, ", "), usage.roundTrips, usage.retries, usage.failovers)
+		usage.mu.Unlock()
+	}
+}
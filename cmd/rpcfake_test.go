@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+var (
+	fakeDAI       = common.HexToAddress("0x6B175474E89094C44Da98b954EedeAC495271d0F")
+	fakeUSDC      = common.HexToAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	fakeWETH      = common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	fakeSushiswap = common.HexToAddress("0xC0AEe478e3658e2610c5F7A4A2E1777cE9e4f2Ac")
+	fakeUniswap   = common.HexToAddress("0x5C69bEe701ef814a2B6a3EDD4B1652CB9cc5aA6f")
+	//fakeRouted has pools of DAI only, USDC/WETH is priced on it through DAI
+	fakeRouted = common.HexToAddress("0x3333333333333333333333333333333333333333")
+
+	fakeTokens = map[common.Address]tokenMeta{fakeDAI: {symbol: "DAI", decimals: 18}, fakeUSDC: {symbol: "USDC", decimals: 6}, fakeWETH: {symbol: "WETH", decimals: 18}}
+)
+
+// fakePool is a pool of a fake factory with constant reserves, token0 has the lower address.
+// A pool with a price logs swaps of USDC for WETH at that price
+type fakePool struct {
+	factory, address   common.Address
+	token0, token1     common.Address
+	reserve0, reserve1 float64
+	price              float64
+}
+
+// fakeNode is a JSON-RPC node of chain id 1 serving just enough of the API for the commands:
+// headers of blocks up to head, symbol/decimals of the tokens, getPair of the factories, reserves and swap logs of the pools
+type fakeNode struct {
+	head uint64
+	t0   uint64
+	//every swapEvery blocks size WETH is swapped for USDC on every pool with a price
+	swapEvery uint64
+	size      float64
+	pools     []fakePool
+}
+
+func newFakeNode(t *testing.T, head uint64) *httptest.Server {
+	node := &fakeNode{
+		head:      head,
+		t0:        uint64(time.Now().Unix()) - head*12,
+		swapEvery: 10,
+		size:      2,
+		pools: []fakePool{
+			{factory: fakeSushiswap, address: common.HexToAddress("0x1111111111111111111111111111111111111111"),
+				token0: fakeUSDC, token1: fakeWETH, reserve0: 3400000, reserve1: 2000, price: 1700},
+			{factory: fakeUniswap, address: common.HexToAddress("0x2222222222222222222222222222222222222222"),
+				token0: fakeUSDC, token1: fakeWETH, reserve0: 3434000, reserve1: 2000, price: 1717},
+			{factory: fakeRouted, address: common.HexToAddress("0x4444444444444444444444444444444444444444"),
+				token0: fakeDAI, token1: fakeUSDC, reserve0: 1e6, reserve1: 1e6},
+			{factory: fakeRouted, address: common.HexToAddress("0x5555555555555555555555555555555555555555"),
+				token0: fakeDAI, token1: fakeWETH, reserve0: 1750000, reserve1: 1000},
+		},
+	}
+	server := httptest.NewServer(node)
+	t.Cleanup(server.Close)
+	return server
+}
+
+type fakeRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type fakeResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *fakeError      `json:"error,omitempty"`
+}
+
+type fakeError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		var batch []fakeRequest
+		json.Unmarshal(body, &batch)
+		responses := make([]fakeResponse, len(batch))
+		for i, req := range batch {
+			responses[i] = n.answer(req)
+		}
+		json.NewEncoder(w).Encode(responses)
+		return
+	}
+	var req fakeRequest
+	json.Unmarshal(body, &req)
+	json.NewEncoder(w).Encode(n.answer(req))
+}
+
+func (n *fakeNode) answer(req fakeRequest) fakeResponse {
+	resp := fakeResponse{JSONRPC: "2.0", ID: req.ID}
+	switch req.Method {
+	case "eth_chainId":
+		resp.Result = "0x1"
+	case "eth_blockNumber":
+		resp.Result = hexutil.EncodeUint64(n.head)
+	case "eth_getBlockByNumber":
+		var tag string
+		json.Unmarshal(req.Params[0], &tag)
+		blockNum := n.head
+		if tag != "latest" {
+			blockNum, _ = hexutil.DecodeUint64(tag)
+		}
+		if blockNum > n.head {
+			//null result, as nodes answer for blocks not produced yet
+			resp.Result = json.RawMessage("null")
+			return resp
+		}
+		resp.Result = n.header(blockNum)
+	case "eth_getCode":
+		resp.Result = "0x6000"
+	case "eth_call":
+		var msg struct {
+			To    common.Address `json:"to"`
+			Data  hexutil.Bytes  `json:"data"`
+			Input hexutil.Bytes  `json:"input"`
+		}
+		json.Unmarshal(req.Params[0], &msg)
+		data := msg.Data
+		if len(data) == 0 {
+			data = msg.Input
+		}
+		output, ok := n.call(msg.To, data)
+		if !ok {
+			resp.Error = &fakeError{Code: 3, Message: "execution reverted"}
+			return resp
+		}
+		resp.Result = hexutil.Bytes(output)
+	case "eth_getLogs":
+		var filter struct {
+			Address   []common.Address `json:"address"`
+			FromBlock string           `json:"fromBlock"`
+			ToBlock   string           `json:"toBlock"`
+			Topics    [][]common.Hash  `json:"topics"`
+		}
+		json.Unmarshal(req.Params[0], &filter)
+		fromBlock, _ := hexutil.DecodeUint64(filter.FromBlock)
+		toBlock, _ := hexutil.DecodeUint64(filter.ToBlock)
+		resp.Result = n.logs(filter.Address, fromBlock, toBlock, filter.Topics)
+	default:
+		resp.Error = &fakeError{Code: -32601, Message: "method not found"}
+	}
+	return resp
+}
+
+func (n *fakeNode) blockHash(blockNum uint64) common.Hash {
+	return common.BigToHash(new(big.Int).SetUint64(blockNum + 1<<32))
+}
+
+func (n *fakeNode) header(blockNum uint64) map[string]interface{} {
+	zero := common.Hash{}
+	parent := zero
+	if blockNum > 0 {
+		parent = n.blockHash(blockNum - 1)
+	}
+	return map[string]interface{}{
+		"number": hexutil.EncodeUint64(blockNum), "hash": n.blockHash(blockNum), "parentHash": parent,
+		"timestamp": hexutil.EncodeUint64(n.t0 + blockNum*12), "sha3Uncles": zero, "miner": common.Address{},
+		"stateRoot": zero, "transactionsRoot": zero, "receiptsRoot": zero, "logsBloom": hexutil.Bytes(make([]byte, 256)),
+		"difficulty": "0x0", "gasLimit": "0x0", "gasUsed": "0x0", "extraData": "0x", "mixHash": zero,
+		"nonce": "0x0000000000000000", "baseFeePerGas": "0x1",
+	}
+}
+
+// word encodes an integer as an ABI word
+func word(value *big.Int) []byte {
+	return common.LeftPadBytes(value.Bytes(), 32)
+}
+
+func abiString(s string) []byte {
+	out := append(word(big.NewInt(32)), word(big.NewInt(int64(len(s))))...)
+	return append(out, common.RightPadBytes([]byte(s), 32)...)
+}
+
+// raw converts an amount of the token to its smallest units
+func raw(amount float64, token common.Address) *big.Int {
+	scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(fakeTokens[token].decimals)), nil))
+	value, _ := new(big.Float).Mul(big.NewFloat(amount), scale).Int(nil)
+	return value
+}
+
+func (n *fakeNode) pool(address common.Address) (fakePool, bool) {
+	for _, pool := range n.pools {
+		if pool.address == address {
+			return pool, true
+		}
+	}
+	return fakePool{}, false
+}
+
+func (n *fakeNode) isFactory(address common.Address) bool {
+	for _, pool := range n.pools {
+		if pool.factory == address {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *fakeNode) call(to common.Address, data []byte) ([]byte, bool) {
+	if len(data) < 4 {
+		return nil, false
+	}
+	pool, isPool := n.pool(to)
+	token, isToken := fakeTokens[to]
+	switch selector := hex.EncodeToString(data[:4]); {
+	case selector == "95d89b41" && isToken: //symbol()
+		return abiString(token.symbol), true
+	case selector == "313ce567" && isToken: //decimals()
+		return word(big.NewInt(int64(token.decimals))), true
+	case selector == "e6a43905" && len(data) >= 68 && n.isFactory(to): //getPair(address,address)
+		tokenA, tokenB := common.BytesToAddress(data[4:36]), common.BytesToAddress(data[36:68])
+		if bytes.Compare(tokenA.Bytes(), tokenB.Bytes()) > 0 {
+			tokenA, tokenB = tokenB, tokenA
+		}
+		for _, pool := range n.pools {
+			if pool.factory == to && pool.token0 == tokenA && pool.token1 == tokenB {
+				return common.LeftPadBytes(pool.address.Bytes(), 32), true
+			}
+		}
+		//factories return the zero address for pairs they never created
+		return word(new(big.Int)), true
+	case selector == "0dfe1681" && isPool: //token0()
+		return common.LeftPadBytes(pool.token0.Bytes(), 32), true
+	case selector == "d21220a7" && isPool: //token1()
+		return common.LeftPadBytes(pool.token1.Bytes(), 32), true
+	case selector == "0902f1ac" && isPool: //getReserves()
+		reserves := append(word(raw(pool.reserve0, pool.token0)), word(raw(pool.reserve1, pool.token1))...)
+		return append(reserves, word(big.NewInt(int64(n.t0)))...), true
+	}
+	return nil, false
+}
+
+func (n *fakeNode) logs(addrs []common.Address, fromBlock, toBlock uint64, topics [][]common.Hash) []map[string]interface{} {
+	logs := make([]map[string]interface{}, 0)
+	if len(topics) == 0 || len(topics[0]) == 0 || topics[0][0] != v2SwapTopic {
+		return logs
+	}
+	for blockNum := fromBlock; blockNum <= toBlock && blockNum <= n.head; blockNum++ {
+		if blockNum%n.swapEvery != 0 {
+			continue
+		}
+		for i, pool := range n.pools {
+			requested := false
+			for _, addr := range addrs {
+				requested = requested || addr == pool.address
+			}
+			if !requested || pool.price == 0 {
+				continue
+			}
+			//USDC goes in and WETH out, a sell of token0
+			zero := word(new(big.Int))
+			data := append(append(append(word(raw(pool.price*n.size, fakeUSDC)), zero...), zero...), word(raw(n.size, fakeWETH))...)
+			logs = append(logs, map[string]interface{}{
+				"address": pool.address, "topics": []common.Hash{v2SwapTopic, {}, {}}, "data": hexutil.Bytes(data),
+				"blockNumber": hexutil.EncodeUint64(blockNum), "blockHash": n.blockHash(blockNum),
+				"transactionHash":  common.BigToHash(new(big.Int).SetUint64(blockNum*10 + uint64(i))),
+				"transactionIndex": hexutil.EncodeUint64(uint64(i)), "logIndex": hexutil.EncodeUint64(uint64(i)), "removed": false,
+			})
+		}
+	}
+	return logs
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultListenAddr    = "127.0.0.1:8080"
+	defaultOpportunities = 20
+	shutdownTimeout      = 5 * time.Second
+)
+
+// apiServer serves trades of the configured pairs as JSON while new final blocks are scanned in the background
+type apiServer struct {
+	*tradeWindow
+}
+
+// apiQuery holds filters common to all endpoints, empty values match everything
+type apiQuery struct {
+	chain string
+	pair  string
+	dex   string
+	//from and to are unix times, to of 0 is not limited
+	from uint64
+	to   uint64
+}
+
+// parseQuery reads filters from URL parameters, times are RFC 3339 or unix seconds
+func parseQuery(r *http.Request) (apiQuery, error) {
+	params := r.URL.Query()
+	q := apiQuery{chain: params.Get("chain"), pair: params.Get("pair"), dex: params.Get("dex")}
+	for _, param := range []struct {
+		name  string
+		value *uint64
+	}{{"from", &q.from}, {"to", &q.to}} {
+		value := params.Get(param.name)
+		if value == "" {
+			continue
+		}
+		if seconds, err := strconv.ParseUint(value, 10, 64); err == nil {
+			*param.value = seconds
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return q, fmt.Errorf("%s: must be RFC 3339 time or unix seconds, got %q", param.name, value)
+		}
+		*param.value = uint64(t.Unix())
+	}
+	if q.to != 0 && q.to < q.from {
+		return q, errors.New("to: must not be before from")
+	}
+	return q, nil
+}
+
+// matchPair tells whether the pair is selected, pairs are named BASE/QUOTE in either order
+func (q apiQuery) matchPair(tokens tokenStruct) bool {
+	return q.pair == "" || matchPairName(q.pair, tokens)
+}
+
+func (q apiQuery) matchTime(blockTime uint64) bool {
+	return blockTime >= q.from && (q.to == 0 || blockTime <= q.to)
+}
+
+// eachPair calls fn for every served pair selected by chain and pair filters, s.mu must be locked
+func (s *apiServer) eachPair(q apiQuery, fn func(chain *servedChain, pair servedPair)) {
+	for _, chain := range s.chains {
+		if q.chain != "" && !strings.EqualFold(q.chain, chain.profile.name) {
+			continue
+		}
+		for _, pair := range chain.pairs {
+			if q.matchPair(pair.tokens) {
+				fn(chain, pair)
+			}
+		}
+	}
+}
+
+// selectVenues returns trades of the venues selected by the dex filter
+func (q apiQuery) selectVenues(venues []venueTrades) []venueTrades {
+	if q.dex == "" {
+		return venues
+	}
+	var selected []venueTrades
+	for _, venue := range venues {
+		if strings.EqualFold(q.dex, venue.name) {
+			selected = append(selected, venue)
+		}
+	}
+	return selected
+}
+
+func (s *apiServer) formatTime(blockTime uint64) string {
+	return time.Unix(int64(blockTime), 0).In(s.cfg.location).Format(time.RFC3339)
+}
+
+type apiToken struct {
+	Address  string `json:"address"`
+	Symbol   string `json:"symbol"`
+	Decimals uint8  `json:"decimals"`
+}
+
+type apiVenue struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Pool string `json:"pool,omitempty"`
+	//Route lists pools of a venue priced through intermediate tokens
+	Route []string `json:"route,omitempty"`
+}
+
+type apiPair struct {
+	Chain  string     `json:"chain"`
+	Pair   string     `json:"pair"`
+	Token0 apiToken   `json:"token0"`
+	Token1 apiToken   `json:"token1"`
+	Venues []apiVenue `json:"venues"`
+}
+
+func (s *apiServer) handlePairs(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pairs := make([]apiPair, 0)
+	s.eachPair(q, func(chain *servedChain, pair servedPair) {
+		tokens := pair.tokens
+		result := apiPair{
+			Chain:  chain.profile.name,
+			Pair:   pairName(tokens),
+			Token0: apiToken{Address: tokens.tkn0Addr.Hex(), Symbol: tokens.tkn0Symbol, Decimals: tokens.tkn0Decimals},
+			Token1: apiToken{Address: tokens.tkn1Addr.Hex(), Symbol: tokens.tkn1Symbol, Decimals: tokens.tkn1Decimals},
+		}
+		for _, pool := range pair.pools {
+			result.Venues = append(result.Venues, apiVenue{Name: pool.name, Type: pool.kind, Pool: pool.adapter.poolAddress().Hex()})
+		}
+		for _, route := range pair.routes {
+			venue := apiVenue{Name: route.name, Type: route.kind}
+			for _, leg := range route.legs {
+				venue.Route = append(venue.Route, leg.adapter.poolAddress().Hex())
+			}
+			result.Venues = append(result.Venues, venue)
+		}
+		pairs = append(pairs, result)
+	})
+	writeJSON(w, http.StatusOK, pairs)
+}
+
+type apiTrade struct {
+	Chain    string  `json:"chain"`
+	Pair     string  `json:"pair"`
+	Dex      string  `json:"dex"`
+	Block    uint64  `json:"block"`
+	Time     string  `json:"time"`
+	LogIndex uint    `json:"log_index"`
+	Side     string  `json:"side"`
+	Price    float64 `json:"price"`
+	Size     float64 `json:"size"`
+	Tx       string  `json:"tx"`
+	Link     string  `json:"link,omitempty"`
+	//Synthetic is set on route quotes, which are not swaps and have no tx
+	Synthetic bool `json:"synthetic,omitempty"`
+}
+
+// handleTrades returns trades ordered by block and log index
+func (s *apiServer) handleTrades(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	trades := make([]apiTrade, 0)
+	s.eachPair(q, func(chain *servedChain, pair servedPair) {
+		for _, venue := range q.selectVenues(pair.venues) {
+			for blockNum, blockTrades := range venue.trades {
+				blockTime := chain.blocksTime[blockNum]
+				if !q.matchTime(blockTime) {
+					continue
+				}
+				for _, trade := range blockTrades {
+					result := apiTrade{
+						Chain: chain.profile.name, Pair: pairName(pair.tokens), Dex: venue.name,
+						Block: blockNum, Time: s.formatTime(blockTime), LogIndex: trade.logIndex,
+						Side: trade.swapSide.String(), Price: trade.price, Size: trade.size, Tx: trade.txHash.Hex(),
+						Synthetic: trade.synthetic(),
+					}
+					if s.cfg.links {
+						result.Link = chain.profile.txLink(trade.txHash.Hex())
+					}
+					trades = append(trades, result)
+				}
+			}
+		}
+	})
+	sort.Slice(trades, func(i, j int) bool {
+		if trades[i].Chain != trades[j].Chain {
+			return trades[i].Chain < trades[j].Chain
+		}
+		if trades[i].Block != trades[j].Block {
+			return trades[i].Block < trades[j].Block
+		}
+		return trades[i].LogIndex < trades[j].LogIndex
+	})
+	writeJSON(w, http.StatusOK, trades)
+}
+
+type apiVenuePrice struct {
+	Dex   string  `json:"dex"`
+	Price float64 `json:"price"`
+	Size  float64 `json:"size"`
+	Tx    string  `json:"tx"`
+}
+
+type apiSpread struct {
+	Chain     string        `json:"chain"`
+	Pair      string        `json:"pair"`
+	Block     uint64        `json:"block"`
+	Time      string        `json:"time"`
+	Side      string        `json:"side"`
+	SpreadBps float64       `json:"spread_bps"`
+	Low       apiVenuePrice `json:"low"`
+	High      apiVenuePrice `json:"high"`
+}
+
+type apiOpportunity struct {
+	Chain     string  `json:"chain"`
+	Pair      string  `json:"pair"`
+	Block     uint64  `json:"block"`
+	Time      string  `json:"time"`
+	Side      string  `json:"side"`
+	SpreadBps float64 `json:"spread_bps"`
+	BuyDex    string  `json:"buy_dex"`
+	BuyPrice  float64 `json:"buy_price"`
+	SellDex   string  `json:"sell_dex"`
+	SellPrice float64 `json:"sell_price"`
+	Size      float64 `json:"size"`
+	//Profit is measured in the quote token of the pair, before fees and gas
+	Profit      float64 `json:"profit"`
+	ProfitToken string  `json:"profit_token"`
+}
+
+// servedSpread is a spread of a served pair
+type servedSpread struct {
+	chain *servedChain
+	pair  servedPair
+	blockSpread
+}
+
+// spreads returns spreads of the selected pairs and venues of at least minSpreadBps, s.mu must be locked
+func (s *apiServer) spreads(q apiQuery, minSpreadBps float64) []servedSpread {
+	var spreads []servedSpread
+	s.eachPair(q, func(chain *servedChain, pair servedPair) {
+		for _, spread := range blockSpreads(q.selectVenues(pair.venues)) {
+			if spread.bps >= minSpreadBps && q.matchTime(chain.blocksTime[spread.blockNum]) {
+				spreads = append(spreads, servedSpread{chain: chain, pair: pair, blockSpread: spread})
+			}
+		}
+	})
+	return spreads
+}
+
+// minSpreadParam reads min_spread in bps, the configured minimum spread is the default
+func (s *apiServer) minSpreadParam(r *http.Request) (float64, error) {
+	value := r.URL.Query().Get("min_spread")
+	if value == "" {
+		return s.cfg.minSpreadBps, nil
+	}
+	minSpread, err := strconv.ParseFloat(value, 64)
+	if err != nil || minSpread < 0 {
+		return 0, fmt.Errorf("min_spread: must be a non-negative number of bps, got %q", value)
+	}
+	return minSpread, nil
+}
+
+func (s *apiServer) handleSpreads(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	minSpread, err := s.minSpreadParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	spreads := make([]apiSpread, 0)
+	for _, spread := range s.spreads(q, minSpread) {
+		spreads = append(spreads, apiSpread{
+			Chain: spread.chain.profile.name, Pair: pairName(spread.pair.tokens),
+			Block: spread.blockNum, Time: s.formatTime(spread.chain.blocksTime[spread.blockNum]),
+			Side: spread.side.String(), SpreadBps: spread.bps,
+			Low:  apiVenuePrice{Dex: spread.low.venue, Price: spread.low.price, Size: spread.low.size, Tx: spread.low.txHash.Hex()},
+			High: apiVenuePrice{Dex: spread.high.venue, Price: spread.high.price, Size: spread.high.size, Tx: spread.high.txHash.Hex()},
+		})
+	}
+	writeJSON(w, http.StatusOK, spreads)
+}
+
+// handleOpportunities returns the most profitable spreads first, limit sets their number
+func (s *apiServer) handleOpportunities(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	minSpread, err := s.minSpreadParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit := defaultOpportunities
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit: must be a positive number, got %q", value))
+			return
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	opportunities := make([]apiOpportunity, 0)
+	for _, spread := range s.spreads(q, minSpread) {
+		if !spread.isOpportunity(minSpread) {
+			continue
+		}
+		size, profit := spread.opportunity()
+		_, quote := spread.pair.tokens.baseQuote()
+		opportunities = append(opportunities, apiOpportunity{
+			Chain: spread.chain.profile.name, Pair: pairName(spread.pair.tokens),
+			Block: spread.blockNum, Time: s.formatTime(spread.chain.blocksTime[spread.blockNum]),
+			Side: spread.side.String(), SpreadBps: spread.bps,
+			BuyDex: spread.low.venue, BuyPrice: spread.low.price, SellDex: spread.high.venue, SellPrice: spread.high.price,
+			Size: size, Profit: profit, ProfitToken: quote,
+		})
+	}
+	sort.SliceStable(opportunities, func(i, j int) bool { return opportunities[i].Profit > opportunities[j].Profit })
+	if len(opportunities) > limit {
+		opportunities = opportunities[:limit]
+	}
+	writeJSON(w, http.StatusOK, opportunities)
+}
+
+type apiChainHealth struct {
+	Chain       string `json:"chain"`
+	SyncedBlock uint64 `json:"synced_block"`
+	HeadBlock   uint64 `json:"head_block"`
+	Syncing     bool   `json:"syncing"`
+	Updated     string `json:"updated,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type apiHealth struct {
+	Status string           `json:"status"`
+	Chains []apiChainHealth `json:"chains"`
+}
+
+// handleHealth reports ok once every chain is scanned without errors, otherwise it responds 503
+func (s *apiServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	health := apiHealth{Status: "ok"}
+	for _, chain := range s.chains {
+		chainHealth := apiChainHealth{
+			Chain:       chain.profile.name,
+			SyncedBlock: chain.syncedTo,
+			HeadBlock:   chain.head,
+			Syncing:     chain.pairs == nil || chain.syncedTo < finalBlock(chain.head, s.cfg.confirmations),
+		}
+		if !chain.updated.IsZero() {
+			chainHealth.Updated = chain.updated.In(s.cfg.location).Format(time.RFC3339)
+		}
+		switch {
+		case chain.err != nil:
+			chainHealth.Error = chain.err.Error()
+			health.Status = "error"
+		case chain.pairs == nil && health.Status == "ok":
+			health.Status = "starting"
+		}
+		health.Chains = append(health.Chains, chainHealth)
+	}
+	status := http.StatusOK
+	if health.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// getOnly rejects requests other than GET and HEAD, the API is read-only
+func getOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", getOnly(s.handleHealth))
+	mux.HandleFunc("/pairs", getOnly(s.handlePairs))
+	mux.HandleFunc("/trades", getOnly(s.handleTrades))
+	mux.HandleFunc("/spreads", getOnly(s.handleSpreads))
+	mux.HandleFunc("/opportunities", getOnly(s.handleOpportunities))
+	return mux
+}
+
+// runServe scans all configured chains in the background and serves their trades over HTTP until interrupted
+func runServe(ctx context.Context, cfg *appConfig, skipValidate bool) {
+	if cfg.offline {
+		fatal("serve reads new blocks from RPC and can not run offline")
+	}
+	if !skipValidate {
+		fmt.Println("Validating configuration")
+		if !validateConfig(ctx, cfg, os.Stdout) {
+			fatal("Fix the problems above or run with -skip-validate")
+		}
+	}
+	targetTimestamp := analysisStart(cfg)
+
+	listener, err := net.Listen("tcp", cfg.listenAddr)
+	if err != nil {
+		fatal(err)
+	}
+	s := &apiServer{newTradeWindow(cfg, time.Since(time.Unix(int64(targetTimestamp), 0)))}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.run(ctx, targetTimestamp)
+	}()
+
+	server := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	//the actual address is printed, so that a random port (:0) can be found by scripts
+	fmt.Printf("Serving API on http://%s\n", listener.Addr())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal(err)
+	}
+	wg.Wait()
+}
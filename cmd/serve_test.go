@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// newTestServer serves the API of a config reading WETH/USDC of two DEXes from the fake node,
+// it returns once the first scan has completed
+func newTestServer(t *testing.T, rpcURL string) *httptest.Server {
+	var fc fileConfig
+	config := `
+chains:
+  ethereum:
+    rpc: ` + rpcURL + `
+    dexes:
+      - {name: Sushiswap, factory: "0xC0AEe478e3658e2610c5F7A4A2E1777cE9e4f2Ac"}
+      - {name: Uniswap, factory: "0x5C69bEe701ef814a2B6a3EDD4B1652CB9cc5aA6f"}
+    pairs:
+      - {token0: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", token1: "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"}
+analysis: {hours: 1, confirmations: 3}
+follow: {poll: 50ms}
+cache: {dir: ""}
+rpc: {multicall: false}
+`
+	if err := yaml.Unmarshal([]byte(config), &fc); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := fc.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &apiServer{newTradeWindow(cfg, time.Hour)}
+	s.logf = func(format string, args ...interface{}) { t.Logf(format, args...) }
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx, analysisStart(cfg))
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	server := httptest.NewServer(s.handler())
+	t.Cleanup(server.Close)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := http.Get(server.URL + "/health")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return server
+			}
+			err = errors.New(resp.Status)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the first scan has not completed, last /health: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestServeEndpoints(t *testing.T) {
+	node := newFakeNode(t, 1000)
+	server := newTestServer(t, node.URL)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		//check inspects the decoded body of successful responses
+		check func(t *testing.T, body []map[string]interface{})
+	}{
+		{name: "pairs", path: "/pairs", status: http.StatusOK, check: func(t *testing.T, body []map[string]interface{}) {
+			if len(body) != 1 || body[0]["pair"] != "WETH/USDC" || len(body[0]["venues"].([]interface{})) != 2 {
+				t.Errorf("want WETH/USDC on two venues, got %v", body)
+			}
+		}},
+		{name: "trades of both venues", path: "/trades", status: http.StatusOK, check: func(t *testing.T, body []map[string]interface{}) {
+			venues := map[interface{}]int{}
+			for _, trade := range body {
+				venues[trade["dex"]]++
+				if trade["side"] != "sell" || trade["size"] != 2.0 {
+					t.Errorf("want sells of USDC for 2 WETH, got %v", trade)
+				}
+				if trade["block"].(float64) > 998 {
+					t.Errorf("block %v has less than 3 confirmations", trade["block"])
+				}
+			}
+			if venues["Sushiswap"] == 0 || venues["Sushiswap"] != venues["Uniswap"] {
+				t.Errorf("want the same number of trades on both venues, got %v", venues)
+			}
+		}},
+		{name: "trades of one dex", path: "/trades?dex=uniswap", status: http.StatusOK, check: func(t *testing.T, body []map[string]interface{}) {
+			for _, trade := range body {
+				if trade["dex"] != "Uniswap" || trade["price"] != 1717.0 {
+					t.Errorf("want Uniswap trades at 1717, got %v", trade)
+				}
+			}
+		}},
+		{name: "spreads", path: "/spreads?min_spread=50", status: http.StatusOK, check: func(t *testing.T, body []map[string]interface{}) {
+			if len(body) == 0 {
+				t.Fatal("want spreads of every block with swaps")
+			}
+			for _, spread := range body {
+				if bps := spread["spread_bps"].(float64); bps < 99 || bps > 101 {
+					t.Errorf("want spread of 100 bps, got %v", bps)
+				}
+			}
+		}},
+		{name: "spreads over the threshold", path: "/spreads?min_spread=150", status: http.StatusOK, check: func(t *testing.T, body []map[string]interface{}) {
+			if len(body) != 0 {
+				t.Errorf("want no spread of 150 bps, got %v", body)
+			}
+		}},
+		{name: "opportunities", path: "/opportunities?limit=2", status: http.StatusOK, check: func(t *testing.T, body []map[string]interface{}) {
+			if len(body) != 2 || body[0]["buy_dex"] != "Sushiswap" || body[0]["sell_dex"] != "Uniswap" || body[0]["profit"] != 34.0 {
+				t.Errorf("want 2 opportunities buying 2 WETH on Sushiswap and selling on Uniswap for 34 USDC, got %v", body)
+			}
+		}},
+		{name: "other chain", path: "/trades?chain=arbitrum", status: http.StatusOK, check: func(t *testing.T, body []map[string]interface{}) {
+			if len(body) != 0 {
+				t.Errorf("want no trades, got %v", body)
+			}
+		}},
+		{name: "invalid time", path: "/trades?from=yesterday", status: http.StatusBadRequest},
+		{name: "invalid limit", path: "/opportunities?limit=0", status: http.StatusBadRequest},
+		{name: "post", method: http.MethodPost, path: "/pairs", status: http.StatusMethodNotAllowed},
+		{name: "unknown", path: "/blocks", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, server.URL+tt.path, strings.NewReader(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("want status %d, got %s", tt.status, resp.Status)
+			}
+			if tt.check == nil {
+				return
+			}
+			var body []map[string]interface{}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, body)
+		})
+	}
+}
+
+func TestServeHealth(t *testing.T) {
+	node := newFakeNode(t, 1000)
+	server := newTestServer(t, node.URL)
+
+	resp, err := http.Get(server.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var health apiHealth
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if len(health.Chains) != 1 {
+		t.Fatalf("want one chain, got %+v", health)
+	}
+	chain := health.Chains[0]
+	if health.Status != "ok" || chain.HeadBlock != 1000 || chain.SyncedBlock != 998 || chain.Syncing {
+		t.Errorf("want ok synced to block 998 of head 1000, got %+v", health)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	defaultStorePath   = "dex-price-reader.db"
+	defaultChunkBlocks = 2000
+)
+
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS pools (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	chain       TEXT NOT NULL,
+	dex         TEXT NOT NULL,
+	token0      TEXT NOT NULL,
+	token1      TEXT NOT NULL,
+	address     TEXT NOT NULL,
+	kind        TEXT NOT NULL,
+	symbol0     TEXT NOT NULL,
+	symbol1     TEXT NOT NULL,
+	decimals0   INTEGER NOT NULL,
+	decimals1   INTEGER NOT NULL,
+	synced_from INTEGER NOT NULL DEFAULT 0,
+	synced_to   INTEGER NOT NULL DEFAULT 0,
+	UNIQUE (chain, dex, token0, token1)
+);
+CREATE TABLE IF NOT EXISTS trades (
+	pool_id      INTEGER NOT NULL REFERENCES pools (id),
+	block_number INTEGER NOT NULL,
+	log_index    INTEGER NOT NULL,
+	tx_hash      TEXT NOT NULL,
+	side         INTEGER NOT NULL,
+	price        REAL NOT NULL,
+	size         REAL NOT NULL,
+	PRIMARY KEY (pool_id, block_number, log_index)
+);
+CREATE TABLE IF NOT EXISTS blocks (
+	chain     TEXT NOT NULL,
+	number    INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL,
+	PRIMARY KEY (chain, number)
+);
+CREATE INDEX IF NOT EXISTS blocks_by_time ON blocks (chain, timestamp);
+`
+
+// tradeStore keeps decoded trades, block timestamps and pool metadata in a local SQLite database,
+// so that repeated analyses of the same period do not need RPC
+type tradeStore struct {
+	db *sql.DB
+}
+
+// storedPool is a venue pool of a pair known to the store. Blocks from syncedFrom to syncedTo
+// (inclusive) have been synced, syncedTo is 0 for pools never synced
+type storedPool struct {
+	id         int64
+	syncedFrom uint64
+	syncedTo   uint64
+}
+
+func openStore(path string) (*tradeStore, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=10000&_foreign_keys=on")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("store %s: %w", path, err)
+	}
+	return &tradeStore{db: db}, nil
+}
+
+func (s *tradeStore) close() error {
+	return s.db.Close()
+}
+
+// pool registers the pool (or refreshes its metadata) and returns its sync state
+func (s *tradeStore) pool(chain string, dex string, kind string, address common.Address, tokens tokenStruct) (storedPool, error) {
+	_, err := s.db.Exec(`
+		INSERT INTO pools (chain, dex, token0, token1, address, kind, symbol0, symbol1, decimals0, decimals1)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chain, dex, token0, token1) DO UPDATE SET
+			address = excluded.address, kind = excluded.kind,
+			symbol0 = excluded.symbol0, symbol1 = excluded.symbol1,
+			decimals0 = excluded.decimals0, decimals1 = excluded.decimals1`,
+		chain, dex, tokens.tkn0Addr.Hex(), tokens.tkn1Addr.Hex(), address.Hex(), kind,
+		tokens.tkn0Symbol, tokens.tkn1Symbol, tokens.tkn0Decimals, tokens.tkn1Decimals)
+	if err != nil {
+		return storedPool{}, err
+	}
+	var pool storedPool
+	err = s.db.QueryRow(`SELECT id, synced_from, synced_to FROM pools WHERE chain = ? AND dex = ? AND token0 = ? AND token1 = ?`,
+		chain, dex, tokens.tkn0Addr.Hex(), tokens.tkn1Addr.Hex()).Scan(&pool.id, &pool.syncedFrom, &pool.syncedTo)
+	return pool, err
+}
+
+// saveTrades stores trades of blocks fromBlock..toBlock of the pool together with their block timestamps
+// and marks the range as synced. Everything is written in one transaction, so an interrupted sync
+// never leaves a range half written
+func (s *tradeStore) saveTrades(chain string, pool storedPool, trades map[uint64][]tradeStruct,
+	blocksTime map[uint64]uint64, fromBlock, toBlock uint64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insertTrade, err := tx.Prepare(`INSERT OR REPLACE INTO trades (pool_id, block_number, log_index, tx_hash, side, price, size)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertTrade.Close()
+	for blockNum, blockTrades := range trades {
+		for _, trade := range blockTrades {
+			if _, err := insertTrade.Exec(pool.id, blockNum, trade.logIndex, trade.txHash.Hex(), trade.swapSide, trade.price, trade.size); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := insertBlockTimes(tx, chain, blocksTime); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE pools SET
+			synced_from = CASE WHEN synced_to = 0 OR ? < synced_from THEN ? ELSE synced_from END,
+			synced_to = MAX(synced_to, ?)
+		WHERE id = ?`, fromBlock, fromBlock, toBlock, pool.id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// saveBlockTimes stores timestamps of blocks without changing sync state of any pool
+func (s *tradeStore) saveBlockTimes(chain string, blocksTime map[uint64]uint64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := insertBlockTimes(tx, chain, blocksTime); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertBlockTimes(tx *sql.Tx, chain string, blocksTime map[uint64]uint64) error {
+	insertBlock, err := tx.Prepare(`INSERT OR IGNORE INTO blocks (chain, number, timestamp) VALUES (?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertBlock.Close()
+	for blockNum, blockTime := range blocksTime {
+		//unknown time is not stored, so that the block is fetched again next time
+		if blockTime == 0 {
+			continue
+		}
+		if _, err := insertBlock.Exec(chain, blockNum, blockTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockTime returns the stored timestamp of the block, ok is false if it is not stored
+func (s *tradeStore) blockTime(chain string, blockNum uint64) (blockTime uint64, ok bool, err error) {
+	err = s.db.QueryRow(`SELECT timestamp FROM blocks WHERE chain = ? AND number = ?`, chain, blockNum).Scan(&blockTime)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return blockTime, err == nil, err
+}
+
+// missingBlockTimes returns blocks of stored trades of the chain which have no timestamp yet
+func (s *tradeStore) missingBlockTimes(chain string) ([]uint64, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT t.block_number FROM trades t
+		JOIN pools p ON p.id = t.pool_id
+		LEFT JOIN blocks b ON b.chain = p.chain AND b.number = t.block_number
+		WHERE p.chain = ? AND b.number IS NULL`, chain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var blockNums []uint64
+	for rows.Next() {
+		var blockNum uint64
+		if err := rows.Scan(&blockNum); err != nil {
+			return nil, err
+		}
+		blockNums = append(blockNums, blockNum)
+	}
+	return blockNums, rows.Err()
+}
+
+// chainTrades reads trades of all configured pairs since targetTimestamp from the store, without any RPC
+func (s *tradeStore) chainTrades(chainCfg chainConfig, targetTimestamp uint64) (*chainTrades, error) {
+	chain := chainCfg.profile.name
+	blocksTime := make(map[uint64]uint64)
+	rows, err := s.db.Query(`SELECT number, timestamp FROM blocks WHERE chain = ? AND timestamp >= ?`, chain, targetTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var blockNum, blockTime uint64
+		if err := rows.Scan(&blockNum, &blockTime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		blocksTime[blockNum] = blockTime
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &chainTrades{
+		profile: chainCfg.profile,
+		blocksTime: func(blockNums []uint64) (map[uint64]uint64, error) {
+			subset := make(map[uint64]uint64, len(blockNums))
+			for _, blockNum := range blockNums {
+				subset[blockNum] = blocksTime[blockNum]
+			}
+			return subset, nil
+		},
+	}
+	for _, pairCfg := range chainCfg.pairs {
+		pair := pairTrades{}
+		//DEXes without a pool of the pair are priced through routes, which sync does not store
+		var missing []string
+		for _, dexCfg := range chainCfg.dexes {
+			var (
+				poolID              int64
+				syncedFrom          uint64
+				syncedTo            uint64
+				symbol0, symbol1    string
+				decimals0, decimal1 uint8
+			)
+			err := s.db.QueryRow(`SELECT id, synced_from, synced_to, symbol0, symbol1, decimals0, decimals1 FROM pools
+				WHERE chain = ? AND dex = ? AND token0 = ? AND token1 = ?`,
+				chain, dexCfg.name, pairCfg.token0.Hex(), pairCfg.token1.Hex()).
+				Scan(&poolID, &syncedFrom, &syncedTo, &symbol0, &symbol1, &decimals0, &decimal1)
+			if err == sql.ErrNoRows && len(pairCfg.routes) > 0 {
+				missing = append(missing, dexCfg.name)
+				continue
+			}
+			if err == sql.ErrNoRows || (err == nil && syncedTo == 0) {
+				return nil, fmt.Errorf("%s pool of %s/%s is not in the store, run sync first",
+					dexCfg.name, pairCfg.token0.Hex(), pairCfg.token1.Hex())
+			}
+			if err != nil {
+				return nil, err
+			}
+			//trades before the synced range are not in the store, the analysis would silently miss them.
+			//Sync saves the time of the block before the range, stores synced by older versions may lack it
+			if syncedFrom > 0 {
+				prevTime, ok, err := s.blockTime(chain, syncedFrom-1)
+				if err != nil {
+					return nil, err
+				}
+				if ok && prevTime >= targetTimestamp {
+					return nil, fmt.Errorf("%s pool of %s/%s is synced from block %d, but the analysis starts at %s, before block %d: run sync with the same -hours first",
+						dexCfg.name, symbol0, symbol1, syncedFrom, time.Unix(int64(targetTimestamp), 0).UTC().Format("2006-01-02 15:04:05 MST"), syncedFrom-1)
+				}
+			}
+			pair.tokens = tokenStruct{
+				tkn0Addr: pairCfg.token0, tkn0Symbol: symbol0, tkn0Decimals: decimals0, tkn0Denominator: tokenDenominator(decimals0),
+				tkn1Addr: pairCfg.token1, tkn1Symbol: symbol1, tkn1Decimals: decimal1, tkn1Denominator: tokenDenominator(decimal1),
+			}
+
+			trades, err := s.poolTrades(chain, poolID, targetTimestamp)
+			if err != nil {
+				return nil, err
+			}
+			pair.venues = append(pair.venues, venueTrades{name: dexCfg.name, trades: trades})
+		}
+		if len(pair.venues) == 0 {
+			return nil, fmt.Errorf("no pool of %s/%s is in the store, run sync first", pairCfg.token0.Hex(), pairCfg.token1.Hex())
+		}
+		if len(missing) > 0 {
+			fmt.Printf("[%s] Warning: %s %s have no pool in the store, their routes are priced from RPC and left out offline\n",
+				chain, pairName(pair.tokens), strings.Join(missing, ", "))
+		}
+		result.pairs = append(result.pairs, pair)
+	}
+	return result, nil
+}
+
+func (s *tradeStore) poolTrades(chain string, poolID int64, targetTimestamp uint64) (map[uint64][]tradeStruct, error) {
+	rows, err := s.db.Query(`SELECT t.block_number, t.log_index, t.tx_hash, t.side, t.price, t.size FROM trades t
+		JOIN blocks b ON b.chain = ? AND b.number = t.block_number
+		WHERE t.pool_id = ? AND b.timestamp >= ?
+		ORDER BY t.block_number, t.log_index`, chain, poolID, targetTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trades := make(map[uint64][]tradeStruct)
+	for rows.Next() {
+		var (
+			blockNum uint64
+			txHash   string
+			trade    tradeStruct
+		)
+		if err := rows.Scan(&blockNum, &trade.logIndex, &txHash, &trade.swapSide, &trade.price, &trade.size); err != nil {
+			return nil, err
+		}
+		trade.txHash = common.HexToHash(txHash)
+		trades[blockNum] = append(trades[blockNum], trade)
+	}
+	return trades, rows.Err()
+}
+
+// tokenDenominator is 10^decimals used to convert raw token amounts
+func tokenDenominator(decimals uint8) *big.Float {
+	return new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+}
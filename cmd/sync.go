@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+)
+
+// syncChain fetches swaps of every pool of the chain newer than its last synced block and saves them to the store.
+// Pools synced for the first time start from targetTimestamp, pools synced from a later time are synced back to it
+func syncChain(ctx context.Context, cfg *appConfig, store *tradeStore, chainCfg chainConfig, targetTimestamp uint64) error {
+	profile := chainCfg.profile
+	fmt.Printf("[%s] Initializing DEX and tokens data\n", profile.name)
+	chain, err := initParams(ctx, chainCfg)
+	if err != nil {
+		return err
+	}
+
+	//only final blocks are saved, the store is never corrected after a reorg
+	head, err := chain.headBlock(ctx)
+	if err != nil {
+		return err
+	}
+	head = finalBlock(head, cfg.confirmations)
+
+	//start block of new pools is searched only once per chain and only if needed
+	var startBlock uint64
+	firstBlock := func() (uint64, error) {
+		if startBlock == 0 {
+			fmt.Printf("[%s] Finding block number by timestamp\n", profile.name)
+			blockNum, err := getBlockByTimestamp(ctx, chain.client, chain.blockTimes, targetTimestamp)
+			if err != nil {
+				return 0, err
+			}
+			startBlock = blockNum.Uint64()
+			//the search is approximate, the window starts exactly at its first block
+			for startBlock < head {
+				startTime, err := blockTime(ctx, chain.client, chain.blockTimes, startBlock)
+				if err != nil {
+					return 0, err
+				}
+				if startTime >= targetTimestamp {
+					break
+				}
+				startBlock++
+			}
+			for startBlock > 1 {
+				prevTime, err := blockTime(ctx, chain.client, chain.blockTimes, startBlock-1)
+				if err != nil {
+					return 0, err
+				}
+				if prevTime < targetTimestamp {
+					break
+				}
+				startBlock--
+			}
+		}
+		return startBlock, nil
+	}
+
+	for _, pair := range chain.pairs {
+		base, quote := pair.tokens.baseQuote()
+		for _, route := range pair.routes {
+			fmt.Printf("[%s] %s/%s on %s is priced through a route, which is not stored\n", profile.name, base, quote, route.name)
+		}
+		for _, venue := range pair.venues {
+			pool, err := store.pool(profile.name, venue.name, venue.kind, venue.adapter.poolAddress(), pair.tokens)
+			if err != nil {
+				return err
+			}
+			tradesCount := 0
+			//syncRange saves one chunk. The time of the block before it is saved too, offline analysis
+			//checks with it that no block of its window precedes the synced range
+			syncRange := func(chunkStart, chunkEnd uint64) error {
+				trades, err := getLogs(ctx, chain.client, venue.adapter, new(big.Int).SetUint64(chunkStart), new(big.Int).SetUint64(chunkEnd))
+				if err != nil {
+					return err
+				}
+				blockNums := make([]uint64, 0, len(trades)+1)
+				if chunkStart > 0 {
+					blockNums = append(blockNums, chunkStart-1)
+				}
+				for blockNum, blockTrades := range trades {
+					blockNums = append(blockNums, blockNum)
+					tradesCount += len(blockTrades)
+				}
+				//trades are saved even if some timestamps failed or the run is interrupted, those are retried below
+				blocksTime, err := getBlocksTime(ctx, chain.rpcClient, chain.blockTimes, blockNums, chainCfg.fetch)
+				if err != nil {
+					fmt.Printf("[%s] Warning: %v\n", profile.name, err)
+				}
+				return store.saveTrades(profile.name, pool, trades, blocksTime, chunkStart, chunkEnd)
+			}
+
+			//a longer -hours than before syncs the blocks before the synced range
+			if pool.syncedTo != 0 && pool.syncedFrom > 0 {
+				prevTime, known, err := store.blockTime(profile.name, pool.syncedFrom-1)
+				if err != nil {
+					return err
+				}
+				//stores synced by older versions lack the time of the block before the synced range
+				if !known {
+					blocksTime, err := getBlocksTime(ctx, chain.rpcClient, chain.blockTimes, []uint64{pool.syncedFrom - 1}, chainCfg.fetch)
+					if err != nil {
+						return err
+					}
+					if err := store.saveBlockTimes(profile.name, blocksTime); err != nil {
+						return err
+					}
+					prevTime = blocksTime[pool.syncedFrom-1]
+				}
+				if prevTime >= targetTimestamp {
+					fromBlock, err := firstBlock()
+					if err != nil {
+						return err
+					}
+					if fromBlock >= pool.syncedFrom {
+						return fmt.Errorf("%s %s/%s: block %d found for the start of the window is not before the synced range", venue.name, base, quote, fromBlock)
+					}
+					//chunks go backwards, so that the synced range stays contiguous if the sync is interrupted
+					for chunkEnd := pool.syncedFrom - 1; ; {
+						chunkStart := fromBlock
+						if chunkEnd-fromBlock >= cfg.chunkBlocks {
+							chunkStart = chunkEnd - cfg.chunkBlocks + 1
+						}
+						if err := syncRange(chunkStart, chunkEnd); interrupted(err) {
+							fmt.Printf("[%s] %s %s/%s interrupted, synced from block %d\n", profile.name, venue.name, base, quote, chunkEnd+1)
+							return err
+						} else if err != nil {
+							return fmt.Errorf("%s blocks %d-%d: %w", venue.name, chunkStart, chunkEnd, err)
+						}
+						if chunkStart == fromBlock {
+							break
+						}
+						chunkEnd = chunkStart - 1
+					}
+					fmt.Printf("[%s] %s %s/%s synced earlier blocks %d-%d\n", profile.name, venue.name, base, quote, fromBlock, pool.syncedFrom-1)
+				}
+			}
+
+			fromBlock := pool.syncedTo + 1
+			if pool.syncedTo == 0 {
+				if fromBlock, err = firstBlock(); err != nil {
+					return err
+				}
+			}
+			if fromBlock > head {
+				fmt.Printf("[%s] %s %s/%s is up to date at block %d\n", profile.name, venue.name, base, quote, pool.syncedTo)
+				continue
+			}
+
+			//logs are read in chunks as providers limit block range of a single eth_getLogs call,
+			//every chunk is committed with its sync state so that an interrupted sync loses at most one chunk
+			for chunkStart := fromBlock; chunkStart <= head; chunkStart += cfg.chunkBlocks {
+				chunkEnd := chunkStart + cfg.chunkBlocks - 1
+				if chunkEnd > head {
+					chunkEnd = head
+				}
+				if err := syncRange(chunkStart, chunkEnd); interrupted(err) {
+					//every completed chunk is already committed, the next sync continues after it
+					fmt.Printf("[%s] %s %s/%s interrupted, synced up to block %d\n", profile.name, venue.name, base, quote, chunkStart-1)
+					return err
+				} else if err != nil {
+					return fmt.Errorf("%s blocks %d-%d: %w", venue.name, chunkStart, chunkEnd, err)
+				}
+			}
+			fmt.Printf("[%s] %s %s/%s synced blocks %d-%d, %d new trades\n", profile.name, venue.name, base, quote, fromBlock, head, tradesCount)
+		}
+	}
+
+	//timestamps that could not be read during sync are retried, trades without them are invisible offline
+	missing, err := store.missingBlockTimes(profile.name)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		fmt.Printf("[%s] Reading %d missing block timestamps\n", profile.name, len(missing))
+		blocksTime, fetchErr := getBlocksTime(ctx, chain.rpcClient, chain.blockTimes, missing, chainCfg.fetch)
+		if err := store.saveBlockTimes(profile.name, blocksTime); err != nil {
+			return err
+		}
+		if fetchErr != nil {
+			return fetchErr
+		}
+	}
+	return nil
+}
+
+// runSync brings the local store up to date for all configured chains
+func runSync(ctx context.Context, cfg *appConfig) {
+	store, err := openStore(cfg.storePath)
+	if err != nil {
+		fatal(err)
+	}
+	defer store.close()
+
+	targetTimestamp := analysisStart(cfg)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(cfg.chains))
+	for i, chainCfg := range cfg.chains {
+		wg.Add(1)
+		go func(i int, chainCfg chainConfig) {
+			defer wg.Done()
+			errs[i] = syncChain(ctx, cfg, store, chainCfg, targetTimestamp)
+		}(i, chainCfg)
+	}
+	wg.Wait()
+
+	failed := false
+	for i, chainCfg := range cfg.chains {
+		if errs[i] != nil && !interrupted(errs[i]) {
+			log.Printf("[%s] %v", chainCfg.profile.name, errs[i])
+			failed = true
+		}
+	}
+	//deferred close does not run on exit, the store is closed explicitly to checkpoint its log
+	store.close()
+	if ctx.Err() != nil {
+		log.Print("Interrupted, completed chunks are saved and the next sync continues from them")
+		exit(130)
+	}
+	if failed {
+		exit(1)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSyncFurtherBack(t *testing.T) {
+	const head = 400
+	server := newFakeNode(t, head)
+	t0 := uint64(time.Now().Unix()) - head*12
+	//windowFrom is the start of a window whose first block is blockNum, halfway from the block before it
+	windowFrom := func(blockNum uint64) uint64 {
+		return t0 + blockNum*12 - 6
+	}
+	var fc fileConfig
+	config := `
+chains:
+  ethereum:
+    rpc: ` + server.URL + `
+    dexes:
+      - {name: Sushiswap, factory: "0xC0AEe478e3658e2610c5F7A4A2E1777cE9e4f2Ac"}
+      - {name: Uniswap, factory: "0x5C69bEe701ef814a2B6a3EDD4B1652CB9cc5aA6f"}
+    pairs:
+      - {token0: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", token1: "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"}
+analysis: {confirmations: 1}
+store: {chunk_blocks: 30}
+cache: {dir: ""}
+rpc: {multicall: false}
+`
+	if err := yaml.Unmarshal([]byte(config), &fc); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := fc.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := openStore(filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	ctx := context.Background()
+
+	//firstBlock is the first block with trades expected for the window
+	steps := []struct {
+		name        string
+		syncFrom    uint64
+		analyseFrom uint64
+		wantErr     string
+		firstBlock  uint64
+	}{
+		{name: "synced window", syncFrom: 200, analyseFrom: 200, firstBlock: 200},
+		{name: "shorter window", analyseFrom: 255, firstBlock: 260},
+		{name: "window before the synced range", analyseFrom: 100, wantErr: "is synced from block 200"},
+		{name: "synced further back", syncFrom: 95, analyseFrom: 95, firstBlock: 100},
+		{name: "synced again", syncFrom: 95, analyseFrom: 150, firstBlock: 150},
+	}
+	for _, step := range steps {
+		if step.syncFrom > 0 {
+			if err := syncChain(ctx, cfg, store, cfg.chains[0], windowFrom(step.syncFrom)); err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+		}
+		trades, err := store.chainTrades(cfg.chains[0], windowFrom(step.analyseFrom))
+		if step.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), step.wantErr) {
+				t.Errorf("%s: want error %q, got %v", step.name, step.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		//a swap every 10 blocks up to the head
+		venue := trades.pairs[0].venues[0]
+		if want := int((head-step.firstBlock)/10 + 1); len(venue.trades) != want || len(venue.trades[step.firstBlock]) != 1 {
+			t.Errorf("%s: want %d blocks with trades from block %d, got %d", step.name, want, step.firstBlock, len(venue.trades))
+		}
+	}
+}
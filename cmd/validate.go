@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const validateTimeout = 30 * time.Second
+
+// validateChain checks on-chain that configuration of the chain makes sense:
+// RPC is reachable and serves the expected chain, DEX contracts exist and pools trade configured pairs
+func validateChain(ctx context.Context, chainCfg chainConfig) []string {
+	client, rpcClient, err := dialChain(chainCfg)
+	if err != nil {
+		return []string{fmt.Sprintf("rpc: cannot connect: %v", err)}
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, validateTimeout)
+	defer cancel()
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return []string{fmt.Sprintf("rpc: not reachable: %v", err)}
+	}
+	//nothing else can be checked on a wrong chain, all addresses would mean different contracts
+	if chainID.Int64() != chainCfg.profile.chainID {
+		return []string{fmt.Sprintf("rpc: serves chain id %d, expected %d (%s)", chainID.Int64(), chainCfg.profile.chainID, chainCfg.profile.name)}
+	}
+
+	var problems []string
+	if chainCfg.fetch.multicall != (common.Address{}) {
+		if ok, err := hasCode(ctx, client, chainCfg.fetch.multicall); err != nil || !ok {
+			return []string{fmt.Sprintf("multicall: no Multicall3 contract at %s, set its address or rpc.multicall: false", chainCfg.fetch.multicall.Hex())}
+		}
+	}
+
+	//pools passing all checks are tested for liquidity at once
+	var pools []venueAdapter
+	var poolFields []string
+	for i, pairCfg := range chainCfg.pairs {
+		pairField := fmt.Sprintf("pairs[%d]", i)
+		tokensOk := true
+		for _, tokenAddr := range []common.Address{pairCfg.token0, pairCfg.token1} {
+			code, err := client.CodeAt(ctx, tokenAddr, nil)
+			switch {
+			case err != nil:
+				problems = append(problems, fmt.Sprintf("%s: cannot read code of %s: %v", pairField, tokenAddr.Hex(), err))
+				tokensOk = false
+			case len(code) == 0:
+				problems = append(problems, fmt.Sprintf("%s: no token contract at %s", pairField, tokenAddr.Hex()))
+				tokensOk = false
+			}
+		}
+		if !tokensOk {
+			continue
+		}
+		tokenAddrs := append([]common.Address{pairCfg.token0, pairCfg.token1}, pairCfg.routeTokens()...)
+		metadata, err := readTokenMeta(ctx, rpcClient, tokenAddrs, chainCfg.fetch)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: cannot read symbol and decimals of tokens: %v", pairField, err))
+			continue
+		}
+		tokens := newTokens(pairCfg.token0, pairCfg.token1, metadata)
+
+		for j, dexCfg := range chainCfg.dexes {
+			field := fmt.Sprintf("dexes[%d] %s, %s %s/%s", j, dexCfg.name, pairField, tokens.tkn0Symbol, tokens.tkn1Symbol)
+			venue, err := newVenue(client, pairCfg.venueFor(dexCfg))
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", field, err))
+				continue
+			}
+			poolProblems, resolveErr := venue.checkPool(ctx, tokens)
+			//without a pool of the pair the DEX is priced through a route, whose pools are checked for liquidity instead
+			if errors.Is(resolveErr, errNoPool) && len(pairCfg.routes) > 0 {
+				route, ok, err := resolveRoute(ctx, client, pairCfg.venueFor(dexCfg), pairCfg.routes, metadata)
+				switch {
+				case err != nil:
+					poolProblems = append(poolProblems, fmt.Sprintf("cannot look up pools of the routes: %v", err))
+				case ok:
+					for _, leg := range route.legs {
+						pools = append(pools, leg.adapter)
+						poolFields = append(poolFields, fmt.Sprintf("dexes[%d] %s, %s %s/%s", j, route.name, pairField, leg.tokens.tkn0Symbol, leg.tokens.tkn1Symbol))
+					}
+					continue
+				default:
+					poolProblems = append(poolProblems, "no pools of any of the routes either")
+				}
+			}
+			for _, problem := range poolProblems {
+				problems = append(problems, fmt.Sprintf("%s: %s", field, problem))
+			}
+			if len(poolProblems) == 0 {
+				pools = append(pools, venue)
+				poolFields = append(poolFields, field)
+			}
+		}
+	}
+
+	reserves, errs, err := poolsReserves(ctx, rpcClient, chainCfg.fetch, pools, nil)
+	if err != nil {
+		return append(problems, fmt.Sprintf("cannot read reserves of pools: %v", err))
+	}
+	for i := range pools {
+		switch {
+		case errs[i] != nil:
+			problems = append(problems, fmt.Sprintf("%s: cannot read reserves of pool: %v", poolFields[i], errs[i]))
+		case reserves[i].reserve0 == 0 || reserves[i].reserve1 == 0:
+			problems = append(problems, fmt.Sprintf("%s: pool has no liquidity", poolFields[i]))
+		}
+	}
+	return problems
+}
+
+// validateConfig checks all configured chains concurrently and prints a report of every problem found.
+// It returns false if analysis should not run
+func validateConfig(ctx context.Context, cfg *appConfig, out io.Writer) bool {
+	var wg sync.WaitGroup
+	problems := make([][]string, len(cfg.chains))
+	for i, chainCfg := range cfg.chains {
+		wg.Add(1)
+		go func(i int, chainCfg chainConfig) {
+			defer wg.Done()
+			problems[i] = validateChain(ctx, chainCfg)
+		}(i, chainCfg)
+	}
+	wg.Wait()
+
+	valid := true
+	for i, chainCfg := range cfg.chains {
+		if len(problems[i]) == 0 {
+			fmt.Fprintf(out, "[%s] ok: %d dexes, %d pairs\n", chainCfg.profile.name, len(chainCfg.dexes), len(chainCfg.pairs))
+			continue
+		}
+		valid = false
+		fmt.Fprintf(out, "[%s] %d problem(s):\n", chainCfg.profile.name, len(problems[i]))
+		for _, problem := range problems[i] {
+			fmt.Fprintf(out, "  %s\n", problem)
+		}
+	}
+	return valid
+}
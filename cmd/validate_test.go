@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestValidateChainRoutes(t *testing.T) {
+	server := newFakeNode(t, 100)
+	tests := []struct {
+		name   string
+		routes string
+		want   []string
+	}{
+		{name: "priced through a route", routes: `[["0x6B175474E89094C44Da98b954EedeAC495271d0F"]]`},
+		{name: "no route", routes: `[]`, want: []string{"dexes[1] Routed, pairs[0] USDC/WETH: pair does not exist"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fc fileConfig
+			config := `
+chains:
+  ethereum:
+    rpc: ` + server.URL + `
+    dexes:
+      - {name: Sushiswap, factory: "0xC0AEe478e3658e2610c5F7A4A2E1777cE9e4f2Ac"}
+      - {name: Routed, factory: "0x3333333333333333333333333333333333333333"}
+    pairs:
+      - token0: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"
+        token1: "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+        routes: ` + tt.routes + `
+cache: {dir: ""}
+rpc: {multicall: false}
+`
+			if err := yaml.Unmarshal([]byte(config), &fc); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := fc.validate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			problems := validateChain(context.Background(), cfg.chains[0])
+			if len(problems) != len(tt.want) {
+				t.Fatalf("want %d problem(s), got %q", len(tt.want), problems)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("want %q, got %q", want, problems[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// venueAdapter hides the specifics of a DEX design (pool lookup, log format, state reads)
+// from the core pipeline, so new forks and AMMs can be added without touching getLogs
+type venueAdapter interface {
+	//resolvePool finds the pool of the token pair and remembers it for the other calls, errNoPool tells there is none
+	resolvePool(ctx context.Context, tokens tokenStruct) error
+	//poolAddress is the contract emitting swap logs of the pool
+	poolAddress() common.Address
+	//logFilter builds the query returning swap logs of the pool
+	logFilter(fromBlock, toBlock *big.Int) ethereum.FilterQuery
+	//decodeLog converts a swap log into a trade, ok is false for logs that should be skipped
+	decodeLog(vLog types.Log) (trade tradeStruct, ok bool, err error)
+	//reservesCall returns the call reading pool balances of token0 and token1 and the decoder of its output,
+	//so that reserves at many blocks can be read in batches
+	reservesCall() (ethereum.CallMsg, reservesDecoder)
+	//fee is the swap fee charged by the pool, e.g. 0.003 for 0.3%
+	fee() float64
+	//checkPool verifies on-chain that contracts of the venue exist and the pool trades the pair,
+	//every problem found is returned as a readable message. resolveErr is the error of resolvePool if the pool
+	//could not be resolved, so that a missing pool (errNoPool) can be told apart from other problems
+	checkPool(ctx context.Context, tokens tokenStruct) (problems []string, resolveErr error)
+}
+
+// reservesLogger is implemented by venues whose pools log their reserves after every change,
+// like Sync of Uniswap V2, so that reserves history is read from logs instead of calls at every block
+type reservesLogger interface {
+	//reservesFilter builds the query returning reserves logs of the pool
+	reservesFilter(fromBlock, toBlock *big.Int) ethereum.FilterQuery
+	decodeReserves(vLog types.Log) (poolReserves, error)
+}
+
+// weightedPool is implemented by venues whose pools may weigh their tokens unequally, like 80/20 Balancer pools.
+// Constant product curves of backtest price only pools weighing token0 and token1 equally
+type weightedPool interface {
+	//equalWeights reports whether the pool weighs token0 and token1 equally, false for pools without weights
+	equalWeights(ctx context.Context) (bool, error)
+}
+
+// errNoPool is returned by resolvePool when the DEX has no pool of the pair, other errors mean the pool could not be looked up
+var errNoPool = errors.New("pair does not exist")
+
+// venueConfig holds venue settings before the pool is resolved
+type venueConfig struct {
+	name    string
+	kind    string
+	factory common.Address
+	vault   common.Address
+	poolID  common.Hash
+	fee     float64
+}
+
+// reservesDecoder converts output of the reserves call into balances of token0 and token1
+type reservesDecoder func(output []byte) (reserve0 float64, reserve1 float64, err error)
+
+type venueConstructor func(client *ethclient.Client, cfg venueConfig) (venueAdapter, error)
+
+var venueTypes = make(map[string]venueConstructor)
+
+// registerVenueType makes a venue implementation available by name (the TYPE setting of a dex)
+func registerVenueType(kind string, constructor venueConstructor) {
+	if _, ok := venueTypes[kind]; ok {
+		panic("venue type " + kind + " registered twice")
+	}
+	venueTypes[kind] = constructor
+}
+
+func venueTypeNames() []string {
+	names := make([]string, 0, len(venueTypes))
+	for kind := range venueTypes {
+		names = append(names, kind)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newVenue(client *ethclient.Client, cfg venueConfig) (venueAdapter, error) {
+	constructor, ok := venueTypes[cfg.kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown dex type %q, supported types: %s", cfg.kind, strings.Join(venueTypeNames(), ", "))
+	}
+	return constructor(client, cfg)
+}
+
+// hasCode reports whether there is a contract deployed at the address
+func hasCode(ctx context.Context, client *ethclient.Client, addr common.Address) (bool, error) {
+	code, err := client.CodeAt(ctx, addr, nil)
+	if err != nil {
+		return false, err
+	}
+	return len(code) > 0, nil
+}
+
+// isReverted tells a call reverted by the contract from a failed request, nodes return reverts as errors of the call
+func isReverted(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "execution reverted")
+}
+
+// toFloat divides raw token amount by token denominator
+func toFloat(amount *big.Int, denominator *big.Float) float64 {
+	value, _ := new(big.Float).Quo(new(big.Float).SetInt(amount), denominator).Float64()
+	return value
+}
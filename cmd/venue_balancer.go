@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"dex-price-reader/contract-api/balancervault"
+)
+
+const venueTypeBalancer = "balancer"
+
+// Balancer V2 Vault is deployed at the same address on all supported chains
+var balancerVaultAddr = common.HexToAddress("0xBA12222222228d8Ba445958a75a0704d566BF2C8")
+
+var balancerSwapTopic = crypto.Keccak256Hash([]byte("Swap(bytes32,address,address,uint256,uint256)"))
+
+// balancerPoolABI covers the part of the pool contract the venue reads, the fee and weights are fixed point numbers with 18 decimals
+const balancerPoolABI = `[{"inputs":[],"name":"getSwapFeePercentage","outputs":[{"internalType":"uint256","name":"","type":"uint256"}],"stateMutability":"view","type":"function"},` +
+	`{"inputs":[],"name":"getNormalizedWeights","outputs":[{"internalType":"uint256[]","name":"","type":"uint256[]"}],"stateMutability":"view","type":"function"}]`
+
+var balancerFeeDenominator = new(big.Float).SetFloat64(1e18)
+
+func init() {
+	registerVenueType(venueTypeBalancer, newBalancerVenue)
+}
+
+// balancerVenue reads swaps of a single Balancer V2 pool, all of which go through the Vault contract
+type balancerVenue struct {
+	client   *ethclient.Client
+	cfg      venueConfig
+	tokens   tokenStruct
+	vault    *balancervault.Balancervault
+	vaultAbi abi.ABI
+	poolAbi  abi.ABI
+	//positions of token0 and token1 in the pool token list
+	tkn0Index int
+	tkn1Index int
+	swapFee   float64
+}
+
+func newBalancerVenue(client *ethclient.Client, cfg venueConfig) (venueAdapter, error) {
+	if cfg.vault == (common.Address{}) {
+		cfg.vault = balancerVaultAddr
+	}
+	if cfg.poolID == (common.Hash{}) {
+		return nil, fmt.Errorf("balancer dex %s requires a pool id", cfg.name)
+	}
+	vaultAbi, err := abi.JSON(strings.NewReader(string(balancervault.BalancervaultABI)))
+	if err != nil {
+		return nil, err
+	}
+	poolAbi, err := abi.JSON(strings.NewReader(balancerPoolABI))
+	if err != nil {
+		return nil, err
+	}
+	vault, err := balancervault.NewBalancervault(cfg.vault, client)
+	if err != nil {
+		return nil, err
+	}
+	return &balancerVenue{client: client, cfg: cfg, vault: vault, vaultAbi: vaultAbi, poolAbi: poolAbi}, nil
+}
+
+func (v *balancerVenue) resolvePool(ctx context.Context, tokens tokenStruct) error {
+	//make sure both tokens are registered in the pool, otherwise no swaps will ever match the filter
+	poolTokens, err := v.vault.GetPoolTokens(&bind.CallOpts{Context: ctx}, v.cfg.poolID)
+	if err != nil {
+		return err
+	}
+	v.tkn0Index, v.tkn1Index = -1, -1
+	for i, tokenAddr := range poolTokens.Tokens {
+		switch tokenAddr {
+		case tokens.tkn0Addr:
+			v.tkn0Index = i
+		case tokens.tkn1Addr:
+			v.tkn1Index = i
+		}
+	}
+	if v.tkn0Index < 0 || v.tkn1Index < 0 {
+		return fmt.Errorf("%w in balancer pool %s, it does not contain both %s and %s",
+			errNoPool, v.cfg.poolID.Hex(), tokens.tkn0Symbol, tokens.tkn1Symbol)
+	}
+	v.tokens = tokens
+	//fee is set per pool and can be changed by its owner, a configured fee takes precedence
+	if v.cfg.fee > 0 {
+		v.swapFee = v.cfg.fee
+		return nil
+	}
+	swapFee, err := v.readSwapFee(ctx)
+	if err != nil {
+		return fmt.Errorf("swap fee of balancer pool %s: %w", v.cfg.poolID.Hex(), err)
+	}
+	v.swapFee = swapFee
+	return nil
+}
+
+// readSwapFee reads the current swap fee of the pool, e.g. 0.003 for 0.3%
+func (v *balancerVenue) readSwapFee(ctx context.Context) (float64, error) {
+	opts := &bind.CallOpts{Context: ctx}
+	pool, _, err := v.vault.GetPool(opts, v.cfg.poolID)
+	if err != nil {
+		return 0, err
+	}
+	var output []interface{}
+	if err := bind.NewBoundContract(pool, v.poolAbi, v.client, nil, nil).Call(opts, &output, "getSwapFeePercentage"); err != nil {
+		return 0, err
+	}
+	if len(output) != 1 {
+		return 0, fmt.Errorf("unexpected getSwapFeePercentage output of pool %s", pool.Hex())
+	}
+	return toFloat(output[0].(*big.Int), balancerFeeDenominator), nil
+}
+
+func (v *balancerVenue) poolAddress() common.Address {
+	return v.cfg.vault
+}
+
+func (v *balancerVenue) logFilter(fromBlock, toBlock *big.Int) ethereum.FilterQuery {
+	//Vault emits swaps of all pools, so filter by pool id and by both directions of the token pair
+	pairTokens := []common.Hash{v.tokens.tkn0Addr.Hash(), v.tokens.tkn1Addr.Hash()}
+	return ethereum.FilterQuery{
+		FromBlock: fromBlock,
+		ToBlock:   toBlock,
+		Addresses: []common.Address{
+			v.cfg.vault,
+		},
+		Topics: [][]common.Hash{
+			{balancerSwapTopic},
+			{v.cfg.poolID},
+			pairTokens,
+			pairTokens,
+		},
+	}
+}
+
+func (v *balancerVenue) decodeLog(vLog types.Log) (tradeStruct, bool, error) {
+	//amountIn and amountOut are two words of data, the pool id and both tokens are indexed
+	if len(vLog.Topics) != 4 || len(vLog.Data) < 64 {
+		return tradeStruct{}, false, fmt.Errorf("unexpected Swap event in log %d of tx %s", vLog.Index, vLog.TxHash.Hex())
+	}
+	tokenIn := common.BytesToAddress(vLog.Topics[2].Bytes())
+	tokenOut := common.BytesToAddress(vLog.Topics[3].Bytes())
+	if tokenIn == tokenOut {
+		return tradeStruct{}, false, nil
+	}
+	swapEvent, err := v.vaultAbi.Unpack("Swap", vLog.Data)
+	if err != nil {
+		return tradeStruct{}, false, err
+	}
+	if len(swapEvent) != 2 {
+		return tradeStruct{}, false, fmt.Errorf("unexpected Swap event in log %d of tx %s", vLog.Index, vLog.TxHash.Hex())
+	}
+	var amount0In, amount1In, amount0Out, amount1Out float64
+	if tokenIn == v.tokens.tkn0Addr {
+		amount0In = toFloat(swapEvent[0].(*big.Int), v.tokens.tkn0Denominator)
+		amount1Out = toFloat(swapEvent[1].(*big.Int), v.tokens.tkn1Denominator)
+	} else {
+		amount1In = toFloat(swapEvent[0].(*big.Int), v.tokens.tkn1Denominator)
+		amount0Out = toFloat(swapEvent[1].(*big.Int), v.tokens.tkn0Denominator)
+	}
+	return newTrade(v.tokens, amount0In, amount1In, amount0Out, amount1Out), true, nil
+}
+
+func (v *balancerVenue) reservesCall() (ethereum.CallMsg, reservesDecoder) {
+	data, _ := v.vaultAbi.Pack("getPoolTokens", v.cfg.poolID)
+	decode := func(output []byte) (float64, float64, error) {
+		poolTokens, err := v.vaultAbi.Unpack("getPoolTokens", output)
+		if err != nil {
+			return 0, 0, err
+		}
+		balances, ok := poolTokens[1].([]*big.Int)
+		if !ok || len(balances) <= v.tkn0Index || len(balances) <= v.tkn1Index {
+			return 0, 0, fmt.Errorf("unexpected getPoolTokens output of pool %s", v.cfg.poolID.Hex())
+		}
+		return toFloat(balances[v.tkn0Index], v.tokens.tkn0Denominator), toFloat(balances[v.tkn1Index], v.tokens.tkn1Denominator), nil
+	}
+	return ethereum.CallMsg{To: &v.cfg.vault, Data: data}, decode
+}
+
+func (v *balancerVenue) fee() float64 {
+	return v.swapFee
+}
+
+func (v *balancerVenue) checkPool(ctx context.Context, tokens tokenStruct) ([]string, error) {
+	vaultCode, err := hasCode(ctx, v.client, v.cfg.vault)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot read code of vault %s: %v", v.cfg.vault.Hex(), err)}, nil
+	}
+	if !vaultCode {
+		return []string{fmt.Sprintf("vault %s has no contract code, check the address and the chain", v.cfg.vault.Hex())}, nil
+	}
+	//getPool reverts for ids never registered in the vault
+	pool, _, err := v.vault.GetPool(&bind.CallOpts{Context: ctx}, v.cfg.poolID)
+	if err != nil {
+		return []string{fmt.Sprintf("pool id %s is not registered in vault %s", v.cfg.poolID.Hex(), v.cfg.vault.Hex())}, nil
+	}
+	poolCode, err := hasCode(ctx, v.client, pool)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot read code of pool %s: %v", pool.Hex(), err)}, nil
+	}
+	if !poolCode {
+		return []string{fmt.Sprintf("pool %s has no contract code", pool.Hex())}, nil
+	}
+	if err := v.resolvePool(ctx, tokens); err != nil {
+		return []string{err.Error()}, err
+	}
+	return nil, nil
+}
+
+// equalWeights reads normalized weights of the pool, stable and other pools without weights revert the call
+func (v *balancerVenue) equalWeights(ctx context.Context) (bool, error) {
+	opts := &bind.CallOpts{Context: ctx}
+	pool, _, err := v.vault.GetPool(opts, v.cfg.poolID)
+	if err != nil {
+		return false, err
+	}
+	var output []interface{}
+	err = bind.NewBoundContract(pool, v.poolAbi, v.client, nil, nil).Call(opts, &output, "getNormalizedWeights")
+	if isReverted(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	weights, ok := output[0].([]*big.Int)
+	if !ok || len(weights) <= v.tkn0Index || len(weights) <= v.tkn1Index {
+		return false, fmt.Errorf("unexpected getNormalizedWeights output of pool %s", pool.Hex())
+	}
+	return weights[v.tkn0Index].Cmp(weights[v.tkn1Index]) == 0, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"dex-price-reader/contract-api/unifactory"
+	"dex-price-reader/contract-api/unipair"
+)
+
+const (
+	venueTypeUniswapV2   = "uniswapv2"
+	venueTypePancakeSwap = "pancakeswap"
+)
+
+var (
+	v2SwapTopic = crypto.Keccak256Hash([]byte("Swap(address,uint256,uint256,uint256,uint256,address)"))
+	v2SyncTopic = crypto.Keccak256Hash([]byte("Sync(uint112,uint112)"))
+)
+
+func init() {
+	registerVenueType(venueTypeUniswapV2, newUniswapV2Venue(0.003))
+	//PancakeSwap V2 is a Uniswap V2 fork with 0.25% fee
+	registerVenueType(venueTypePancakeSwap, newUniswapV2Venue(0.0025))
+}
+
+// uniswapV2Venue reads Uniswap V2 style pairs (Uniswap, Sushiswap and other forks)
+type uniswapV2Venue struct {
+	client     *ethclient.Client
+	cfg        venueConfig
+	tokens     tokenStruct
+	pairAddr   common.Address
+	pairAbi    abi.ABI
+	swapFee    float64
+	pairCaller *unipair.Unipair
+}
+
+func newUniswapV2Venue(defaultFee float64) venueConstructor {
+	return func(client *ethclient.Client, cfg venueConfig) (venueAdapter, error) {
+		pairAbi, err := abi.JSON(strings.NewReader(string(unipair.UnipairABI)))
+		if err != nil {
+			return nil, err
+		}
+		venue := &uniswapV2Venue{client: client, cfg: cfg, pairAbi: pairAbi, swapFee: defaultFee}
+		if cfg.fee > 0 {
+			venue.swapFee = cfg.fee
+		}
+		return venue, nil
+	}
+}
+
+func (v *uniswapV2Venue) resolvePool(ctx context.Context, tokens tokenStruct) error {
+	//factory contract instance is needed to find respective pair pool address
+	factory, err := unifactory.NewUnifactory(v.cfg.factory, v.client)
+	if err != nil {
+		return err
+	}
+	pairAddr, err := factory.GetPair(&bind.CallOpts{Context: ctx}, tokens.tkn0Addr, tokens.tkn1Addr)
+	if err != nil {
+		return err
+	}
+	//factory returns zero address for pairs it has never created, reading its logs would silently return nothing
+	if pairAddr == (common.Address{}) {
+		return fmt.Errorf("%w at factory %s", errNoPool, v.cfg.factory.Hex())
+	}
+	pairCaller, err := unipair.NewUnipair(pairAddr, v.client)
+	if err != nil {
+		return err
+	}
+	v.tokens, v.pairAddr, v.pairCaller = tokens, pairAddr, pairCaller
+	return nil
+}
+
+func (v *uniswapV2Venue) poolAddress() common.Address {
+	return v.pairAddr
+}
+
+func (v *uniswapV2Venue) logFilter(fromBlock, toBlock *big.Int) ethereum.FilterQuery {
+	//Query all Swap events (without filterting by sender/to) for a given pair pool address
+	return ethereum.FilterQuery{
+		FromBlock: fromBlock,
+		ToBlock:   toBlock,
+		Addresses: []common.Address{
+			v.pairAddr,
+		},
+		Topics: [][]common.Hash{
+			{v2SwapTopic},
+		},
+	}
+}
+
+func (v *uniswapV2Venue) decodeLog(vLog types.Log) (tradeStruct, bool, error) {
+	swapEvent, err := v.pairAbi.Unpack("Swap", vLog.Data)
+	if err != nil {
+		return tradeStruct{}, false, err
+	}
+	if len(swapEvent) != 4 {
+		return tradeStruct{}, false, fmt.Errorf("unexpected Swap event in log %d of tx %s", vLog.Index, vLog.TxHash.Hex())
+	}
+	//Below we cast amounts to big.Float, divide them using token denominator and then cast to float64
+	amount0In := toFloat(swapEvent[0].(*big.Int), v.tokens.tkn0Denominator)
+	amount1In := toFloat(swapEvent[1].(*big.Int), v.tokens.tkn1Denominator)
+	amount0Out := toFloat(swapEvent[2].(*big.Int), v.tokens.tkn0Denominator)
+	amount1Out := toFloat(swapEvent[3].(*big.Int), v.tokens.tkn1Denominator)
+	return newTrade(v.tokens, amount0In, amount1In, amount0Out, amount1Out), true, nil
+}
+
+func (v *uniswapV2Venue) reservesCall() (ethereum.CallMsg, reservesDecoder) {
+	data, _ := v.pairAbi.Pack("getReserves")
+	decode := func(output []byte) (float64, float64, error) {
+		pairReserves, err := v.pairAbi.Unpack("getReserves", output)
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(pairReserves) != 3 {
+			return 0, 0, fmt.Errorf("unexpected getReserves output of pair %s", v.pairAddr.Hex())
+		}
+		return toFloat(pairReserves[0].(*big.Int), v.tokens.tkn0Denominator), toFloat(pairReserves[1].(*big.Int), v.tokens.tkn1Denominator), nil
+	}
+	return ethereum.CallMsg{To: &v.pairAddr, Data: data}, decode
+}
+
+func (v *uniswapV2Venue) reservesFilter(fromBlock, toBlock *big.Int) ethereum.FilterQuery {
+	//every swap, mint and burn ends with Sync carrying the new reserves
+	return ethereum.FilterQuery{
+		FromBlock: fromBlock,
+		ToBlock:   toBlock,
+		Addresses: []common.Address{v.pairAddr},
+		Topics:    [][]common.Hash{{v2SyncTopic}},
+	}
+}
+
+func (v *uniswapV2Venue) decodeReserves(vLog types.Log) (poolReserves, error) {
+	syncEvent, err := v.pairAbi.Unpack("Sync", vLog.Data)
+	if err != nil {
+		return poolReserves{}, err
+	}
+	if len(syncEvent) != 2 {
+		return poolReserves{}, fmt.Errorf("unexpected Sync event in log %d of tx %s", vLog.Index, vLog.TxHash.Hex())
+	}
+	return poolReserves{
+		reserve0: toFloat(syncEvent[0].(*big.Int), v.tokens.tkn0Denominator),
+		reserve1: toFloat(syncEvent[1].(*big.Int), v.tokens.tkn1Denominator),
+	}, nil
+}
+
+func (v *uniswapV2Venue) fee() float64 {
+	return v.swapFee
+}
+
+func (v *uniswapV2Venue) checkPool(ctx context.Context, tokens tokenStruct) ([]string, error) {
+	factoryCode, err := hasCode(ctx, v.client, v.cfg.factory)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot read code of factory %s: %v", v.cfg.factory.Hex(), err)}, nil
+	}
+	if !factoryCode {
+		return []string{fmt.Sprintf("factory %s has no contract code, check the address and the chain", v.cfg.factory.Hex())}, nil
+	}
+	if err := v.resolvePool(ctx, tokens); err != nil {
+		return []string{err.Error()}, err
+	}
+	pairCode, err := hasCode(ctx, v.client, v.pairAddr)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot read code of pair %s: %v", v.pairAddr.Hex(), err)}, nil
+	}
+	if !pairCode {
+		return []string{fmt.Sprintf("pair %s returned by factory has no contract code", v.pairAddr.Hex())}, nil
+	}
+
+	var problems []string
+	pairToken0, err := v.pairCaller.Token0(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("token0() of pair %s failed: %v", v.pairAddr.Hex(), err))
+	} else if pairToken0 != tokens.tkn0Addr {
+		problems = append(problems, fmt.Sprintf("token0() of pair %s is %s, expected %s (%s)",
+			v.pairAddr.Hex(), pairToken0.Hex(), tokens.tkn0Addr.Hex(), tokens.tkn0Symbol))
+	}
+	pairToken1, err := v.pairCaller.Token1(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("token1() of pair %s failed: %v", v.pairAddr.Hex(), err))
+	} else if pairToken1 != tokens.tkn1Addr {
+		problems = append(problems, fmt.Sprintf("token1() of pair %s is %s, expected %s (%s)",
+			v.pairAddr.Hex(), pairToken1.Hex(), tokens.tkn1Addr.Hex(), tokens.tkn1Symbol))
+	}
+	return problems, nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// spreadHistory is the number of mid-price spread samples kept per pair
+const spreadHistory = 240
+
+// servedPair is a pair with its pools and the trades of its recent blocks
+type servedPair struct {
+	pairTrades
+	pools  []venueStruct
+	routes []routeStruct
+	//midPrices of every pool at the head block and the history of the spread between them, see tradeWindow.midPrices
+	midPrices []float64
+	spreads   []float64
+}
+
+// servedChain is the state of one chain kept up to date by the background scan
+type servedChain struct {
+	profile chainProfile
+	//pairs is nil until DEX and token data of the chain are read
+	pairs      []servedPair
+	blocksTime map[uint64]uint64
+	//syncedTo is the last final block whose swaps are served
+	syncedTo uint64
+	head     uint64
+	updated  time.Time
+	err      error
+}
+
+// tradeWindow keeps trades of recent final blocks of all configured chains in memory.
+// Chains are scanned in the background, readers lock mu
+type tradeWindow struct {
+	cfg *appConfig
+	//window is how long trades are kept
+	window time.Duration
+	//midPrices enables reading reserves of all pools at the head block on every poll
+	midPrices bool
+	//logf prints progress and warnings of the scan
+	logf   func(format string, args ...interface{})
+	mu     sync.RWMutex
+	chains []*servedChain
+}
+
+// newTradeWindow prepares an empty window of every configured chain
+func newTradeWindow(cfg *appConfig, window time.Duration) *tradeWindow {
+	w := &tradeWindow{cfg: cfg, window: window, logf: func(format string, args ...interface{}) { fmt.Printf(format+"\n", args...) }}
+	for _, chainCfg := range cfg.chains {
+		w.chains = append(w.chains, &servedChain{profile: chainCfg.profile, blocksTime: make(map[uint64]uint64)})
+	}
+	return w
+}
+
+// run scans all chains until ctx is cancelled
+func (w *tradeWindow) run(ctx context.Context, targetTimestamp uint64) {
+	var wg sync.WaitGroup
+	for i, chainCfg := range w.cfg.chains {
+		wg.Add(1)
+		go func(served *servedChain, chainCfg chainConfig) {
+			defer wg.Done()
+			w.scanChain(ctx, served, chainCfg, targetTimestamp)
+		}(w.chains[i], chainCfg)
+	}
+	wg.Wait()
+}
+
+// initChain reads DEX and token data of the chain and returns the block the scan starts from
+func (w *tradeWindow) initChain(ctx context.Context, served *servedChain, chainCfg chainConfig, targetTimestamp uint64) (*chainStruct, uint64, error) {
+	profile := chainCfg.profile
+	w.logf("[%s] Initializing DEX and tokens data", profile.name)
+	chain, err := initParams(ctx, chainCfg)
+	if err != nil {
+		return nil, 0, err
+	}
+	w.logf("[%s] Finding block number by timestamp", profile.name)
+	startBlock, err := getBlockByTimestamp(ctx, chain.client, chain.blockTimes, targetTimestamp)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, pair := range chain.pairs {
+		servedPair := servedPair{pairTrades: pairTrades{tokens: pair.tokens}, pools: pair.venues, routes: pair.routes}
+		for _, venue := range pair.venues {
+			servedPair.venues = append(servedPair.venues, venueTrades{name: venue.name, trades: make(map[uint64][]tradeStruct)})
+		}
+		//routes are served after the pools, in the order of pair.routes
+		for _, route := range pair.routes {
+			servedPair.venues = append(servedPair.venues, venueTrades{name: route.name, trades: make(map[uint64][]tradeStruct)})
+		}
+		served.pairs = append(served.pairs, servedPair)
+	}
+	served.syncedTo = startBlock.Uint64() - 1
+	return chain, startBlock.Uint64(), nil
+}
+
+// scanChain reads swaps since targetTimestamp and then the blocks finalized since the previous poll.
+// Failures are shown by the health endpoint and retried on the next tick
+func (w *tradeWindow) scanChain(ctx context.Context, served *servedChain, chainCfg chainConfig, targetTimestamp uint64) {
+	var (
+		chain     *chainStruct
+		nextBlock uint64
+		err       error
+	)
+	ticker := time.NewTicker(w.cfg.pollInterval)
+	defer ticker.Stop()
+	for {
+		if chain == nil {
+			chain, nextBlock, err = w.initChain(ctx, served, chainCfg, targetTimestamp)
+		}
+		if chain != nil {
+			err = w.scanNewBlocks(ctx, served, chain, chainCfg, &nextBlock)
+		}
+		if chain != nil && err == nil && w.midPrices {
+			err = w.readMidPrices(ctx, served, chain, chainCfg)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.logf("[%s] Warning: %v", chainCfg.profile.name, err)
+		}
+		w.mu.Lock()
+		served.err = err
+		w.mu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scanNewBlocks reads swaps of final blocks from nextBlock on. Every chunk is served as soon as it is read,
+// so that a long first scan shows progress
+func (w *tradeWindow) scanNewBlocks(ctx context.Context, served *servedChain, chain *chainStruct, chainCfg chainConfig, nextBlock *uint64) error {
+	head, err := chain.headBlock(ctx)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	served.head = head
+	w.mu.Unlock()
+
+	final := finalBlock(head, w.cfg.confirmations)
+	for chunkStart := *nextBlock; chunkStart <= final; chunkStart += w.cfg.chunkBlocks {
+		chunkEnd := chunkStart + w.cfg.chunkBlocks - 1
+		if chunkEnd > final {
+			chunkEnd = final
+		}
+		//trades by pair and venue index, routes follow the pools
+		chunkTrades := make([][]map[uint64][]tradeStruct, len(chain.pairs))
+		blocks := make(map[uint64]bool)
+		for i, pair := range chain.pairs {
+			for _, venue := range pair.venues {
+				trades, err := getLogs(ctx, chain.client, venue.adapter, new(big.Int).SetUint64(chunkStart), new(big.Int).SetUint64(chunkEnd))
+				if err != nil {
+					return fmt.Errorf("%s blocks %d-%d: %w", venue.name, chunkStart, chunkEnd, err)
+				}
+				for blockNum := range trades {
+					blocks[blockNum] = true
+				}
+				chunkTrades[i] = append(chunkTrades[i], trades)
+			}
+			direct := make([]venueTrades, len(pair.venues))
+			for j, venue := range pair.venues {
+				direct[j] = venueTrades{name: venue.name, trades: chunkTrades[i][j]}
+			}
+			for _, route := range pair.routes {
+				trades, err := readRouteTrades(ctx, chain.rpcClient, chainCfg.fetch, pair.tokens, route, direct)
+				if err != nil {
+					return fmt.Errorf("%s blocks %d-%d: %w", route.name, chunkStart, chunkEnd, err)
+				}
+				chunkTrades[i] = append(chunkTrades[i], trades)
+			}
+		}
+		//every served trade has its time, so the whole chunk is read again if some timestamps are missing
+		blockNums := make([]uint64, 0, len(blocks))
+		for blockNum := range blocks {
+			blockNums = append(blockNums, blockNum)
+		}
+		blocksTime, err := getBlocksTime(ctx, chain.rpcClient, chain.blockTimes, blockNums, chainCfg.fetch)
+		if err != nil {
+			return err
+		}
+
+		w.mu.Lock()
+		for i, venues := range chunkTrades {
+			for j, trades := range venues {
+				for blockNum, blockTrades := range trades {
+					served.pairs[i].venues[j].trades[blockNum] = blockTrades
+				}
+			}
+		}
+		for blockNum, blockTime := range blocksTime {
+			served.blocksTime[blockNum] = blockTime
+		}
+		served.syncedTo = chunkEnd
+		served.updated = time.Now()
+		w.prune(served)
+		w.mu.Unlock()
+
+		*nextBlock = chunkEnd + 1
+		if len(blockNums) > 0 {
+			w.logf("[%s] Blocks %d-%d scanned, %d blocks with swaps", chainCfg.profile.name, chunkStart, chunkEnd, len(blockNums))
+		}
+	}
+	return nil
+}
+
+// prune drops trades older than the window, w.mu must be locked
+func (w *tradeWindow) prune(served *servedChain) {
+	cutoff := uint64(time.Now().Add(-w.window).Unix())
+	for blockNum, blockTime := range served.blocksTime {
+		if blockTime >= cutoff {
+			continue
+		}
+		for _, pair := range served.pairs {
+			for _, venue := range pair.venues {
+				delete(venue.trades, blockNum)
+			}
+		}
+		delete(served.blocksTime, blockNum)
+	}
+}
+
+// readMidPrices reads reserves of all pools at the head block and records mid-prices and their spread.
+// Pools whose reserves could not be read have no mid-price until the next poll
+func (w *tradeWindow) readMidPrices(ctx context.Context, served *servedChain, chain *chainStruct, chainCfg chainConfig) error {
+	var adapters []venueAdapter
+	for _, pair := range chain.pairs {
+		for _, venue := range pair.venues {
+			adapters = append(adapters, venue.adapter)
+		}
+	}
+	reserves, errs, err := poolsReserves(ctx, chain.rpcClient, chainCfg.fetch, adapters, nil)
+	if err != nil {
+		return fmt.Errorf("reading reserves: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, pair := range chain.pairs {
+		prices := make([]float64, len(pair.venues))
+		for j := range pair.venues {
+			if errs[j] == nil {
+				prices[j] = reserves[j].midPrice(pair.tokens)
+			}
+		}
+		reserves, errs = reserves[len(pair.venues):], errs[len(pair.venues):]
+
+		servedPair := &served.pairs[i]
+		servedPair.midPrices = prices
+		if low, high, ok := priceRange(prices); ok {
+			servedPair.spreads = append(servedPair.spreads, spreadBps(low, high))
+			if len(servedPair.spreads) > spreadHistory {
+				servedPair.spreads = servedPair.spreads[len(servedPair.spreads)-spreadHistory:]
+			}
+		}
+	}
+	return nil
+}
+
+// priceRange returns the lowest and the highest of the known prices, ok is false unless there are two of them
+func priceRange(prices []float64) (low, high float64, ok bool) {
+	priced := 0
+	for _, price := range prices {
+		if price <= 0 {
+			continue
+		}
+		if priced == 0 || price < low {
+			low = price
+		}
+		if priced == 0 || price > high {
+			high = price
+		}
+		priced++
+	}
+	return low, high, priced > 1
+}
@@ -0,0 +1,426 @@
+// Code generated - DO NOT EDIT.
+// This file is a generated binding and any manual changes will be lost.
+
+package balancervault
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var (
+	_ = errors.New
+	_ = big.NewInt
+	_ = strings.NewReader
+	_ = ethereum.NotFound
+	_ = bind.Bind
+	_ = common.Big1
+	_ = types.BloomLookup
+	_ = event.NewSubscription
+)
+
+// BalancervaultMetaData contains all meta data concerning the Balancervault contract.
+var BalancervaultMetaData = &bind.MetaData{
+	ABI: "[{\"anonymous\":false,\"inputs\":[{\"indexed\":true,\"internalType\":\"bytes32\",\"name\":\"poolId\",\"type\":\"bytes32\"},{\"indexed\":true,\"internalType\":\"contractIERC20\",\"name\":\"tokenIn\",\"type\":\"address\"},{\"indexed\":true,\"internalType\":\"contractIERC20\",\"name\":\"tokenOut\",\"type\":\"address\"},{\"indexed\":false,\"internalType\":\"uint256\",\"name\":\"amountIn\",\"type\":\"uint256\"},{\"indexed\":false,\"internalType\":\"uint256\",\"name\":\"amountOut\",\"type\":\"uint256\"}],\"name\":\"Swap\",\"type\":\"event\"},{\"inputs\":[{\"internalType\":\"bytes32\",\"name\":\"poolId\",\"type\":\"bytes32\"}],\"name\":\"getPool\",\"outputs\":[{\"internalType\":\"address\",\"name\":\"\",\"type\":\"address\"},{\"internalType\":\"enumIVault.PoolSpecialization\",\"name\":\"\",\"type\":\"uint8\"}],\"stateMutability\":\"view\",\"type\":\"function\"},{\"inputs\":[{\"internalType\":\"bytes32\",\"name\":\"poolId\",\"type\":\"bytes32\"}],\"name\":\"getPoolTokens\",\"outputs\":[{\"internalType\":\"contractIERC20[]\",\"name\":\"tokens\",\"type\":\"address[]\"},{\"internalType\":\"uint256[]\",\"name\":\"balances\",\"type\":\"uint256[]\"},{\"internalType\":\"uint256\",\"name\":\"lastChangeBlock\",\"type\":\"uint256\"}],\"stateMutability\":\"view\",\"type\":\"function\"}]",
+}
+
+// BalancervaultABI is the input ABI used to generate the binding from.
+// Deprecated: Use BalancervaultMetaData.ABI instead.
+var BalancervaultABI = BalancervaultMetaData.ABI
+
+// Balancervault is an auto generated Go binding around an Ethereum contract.
+type Balancervault struct {
+	BalancervaultCaller     // Read-only binding to the contract
+	BalancervaultTransactor // Write-only binding to the contract
+	BalancervaultFilterer   // Log filterer for contract events
+}
+
+// BalancervaultCaller is an auto generated read-only Go binding around an Ethereum contract.
+type BalancervaultCaller struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// BalancervaultTransactor is an auto generated write-only Go binding around an Ethereum contract.
+type BalancervaultTransactor struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// BalancervaultFilterer is an auto generated log filtering Go binding around an Ethereum contract events.
+type BalancervaultFilterer struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// BalancervaultSession is an auto generated Go binding around an Ethereum contract,
+// with pre-set call and transact options.
+type BalancervaultSession struct {
+	Contract     *Balancervault    // Generic contract binding to set the session for
+	CallOpts     bind.CallOpts     // Call options to use throughout this session
+	TransactOpts bind.TransactOpts // Transaction auth options to use throughout this session
+}
+
+// BalancervaultCallerSession is an auto generated read-only Go binding around an Ethereum contract,
+// with pre-set call options.
+type BalancervaultCallerSession struct {
+	Contract *BalancervaultCaller // Generic contract caller binding to set the session for
+	CallOpts bind.CallOpts        // Call options to use throughout this session
+}
+
+// BalancervaultTransactorSession is an auto generated write-only Go binding around an Ethereum contract,
+// with pre-set transact options.
+type BalancervaultTransactorSession struct {
+	Contract     *BalancervaultTransactor // Generic contract transactor binding to set the session for
+	TransactOpts bind.TransactOpts        // Transaction auth options to use throughout this session
+}
+
+// BalancervaultRaw is an auto generated low-level Go binding around an Ethereum contract.
+type BalancervaultRaw struct {
+	Contract *Balancervault // Generic contract binding to access the raw methods on
+}
+
+// BalancervaultCallerRaw is an auto generated low-level read-only Go binding around an Ethereum contract.
+type BalancervaultCallerRaw struct {
+	Contract *BalancervaultCaller // Generic read-only contract binding to access the raw methods on
+}
+
+// BalancervaultTransactorRaw is an auto generated low-level write-only Go binding around an Ethereum contract.
+type BalancervaultTransactorRaw struct {
+	Contract *BalancervaultTransactor // Generic write-only contract binding to access the raw methods on
+}
+
+// NewBalancervault creates a new instance of Balancervault, bound to a specific deployed contract.
+func NewBalancervault(address common.Address, backend bind.ContractBackend) (*Balancervault, error) {
+	contract, err := bindBalancervault(address, backend, backend, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &Balancervault{BalancervaultCaller: BalancervaultCaller{contract: contract}, BalancervaultTransactor: BalancervaultTransactor{contract: contract}, BalancervaultFilterer: BalancervaultFilterer{contract: contract}}, nil
+}
+
+// NewBalancervaultCaller creates a new read-only instance of Balancervault, bound to a specific deployed contract.
+func NewBalancervaultCaller(address common.Address, caller bind.ContractCaller) (*BalancervaultCaller, error) {
+	contract, err := bindBalancervault(address, caller, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &BalancervaultCaller{contract: contract}, nil
+}
+
+// NewBalancervaultTransactor creates a new write-only instance of Balancervault, bound to a specific deployed contract.
+func NewBalancervaultTransactor(address common.Address, transactor bind.ContractTransactor) (*BalancervaultTransactor, error) {
+	contract, err := bindBalancervault(address, nil, transactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &BalancervaultTransactor{contract: contract}, nil
+}
+
+// NewBalancervaultFilterer creates a new log filterer instance of Balancervault, bound to a specific deployed contract.
+func NewBalancervaultFilterer(address common.Address, filterer bind.ContractFilterer) (*BalancervaultFilterer, error) {
+	contract, err := bindBalancervault(address, nil, nil, filterer)
+	if err != nil {
+		return nil, err
+	}
+	return &BalancervaultFilterer{contract: contract}, nil
+}
+
+// bindBalancervault binds a generic wrapper to an already deployed contract.
+func bindBalancervault(address common.Address, caller bind.ContractCaller, transactor bind.ContractTransactor, filterer bind.ContractFilterer) (*bind.BoundContract, error) {
+	parsed, err := abi.JSON(strings.NewReader(BalancervaultABI))
+	if err != nil {
+		return nil, err
+	}
+	return bind.NewBoundContract(address, parsed, caller, transactor, filterer), nil
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_Balancervault *BalancervaultRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _Balancervault.Contract.BalancervaultCaller.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_Balancervault *BalancervaultRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _Balancervault.Contract.BalancervaultTransactor.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_Balancervault *BalancervaultRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _Balancervault.Contract.BalancervaultTransactor.contract.Transact(opts, method, params...)
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_Balancervault *BalancervaultCallerRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _Balancervault.Contract.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_Balancervault *BalancervaultTransactorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _Balancervault.Contract.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_Balancervault *BalancervaultTransactorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _Balancervault.Contract.contract.Transact(opts, method, params...)
+}
+
+// GetPool is a free data retrieval call binding the contract method 0xf6c00927.
+//
+// Solidity: function getPool(bytes32 poolId) view returns(address, uint8)
+func (_Balancervault *BalancervaultCaller) GetPool(opts *bind.CallOpts, poolId [32]byte) (common.Address, uint8, error) {
+	var out []interface{}
+	err := _Balancervault.contract.Call(opts, &out, "getPool", poolId)
+
+	if err != nil {
+		return *new(common.Address), *new(uint8), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new(common.Address)).(*common.Address)
+	out1 := *abi.ConvertType(out[1], new(uint8)).(*uint8)
+
+	return out0, out1, err
+
+}
+
+// GetPool is a free data retrieval call binding the contract method 0xf6c00927.
+//
+// Solidity: function getPool(bytes32 poolId) view returns(address, uint8)
+func (_Balancervault *BalancervaultSession) GetPool(poolId [32]byte) (common.Address, uint8, error) {
+	return _Balancervault.Contract.GetPool(&_Balancervault.CallOpts, poolId)
+}
+
+// GetPool is a free data retrieval call binding the contract method 0xf6c00927.
+//
+// Solidity: function getPool(bytes32 poolId) view returns(address, uint8)
+func (_Balancervault *BalancervaultCallerSession) GetPool(poolId [32]byte) (common.Address, uint8, error) {
+	return _Balancervault.Contract.GetPool(&_Balancervault.CallOpts, poolId)
+}
+
+// GetPoolTokens is a free data retrieval call binding the contract method 0xf94d4668.
+//
+// Solidity: function getPoolTokens(bytes32 poolId) view returns(address[] tokens, uint256[] balances, uint256 lastChangeBlock)
+func (_Balancervault *BalancervaultCaller) GetPoolTokens(opts *bind.CallOpts, poolId [32]byte) (struct {
+	Tokens          []common.Address
+	Balances        []*big.Int
+	LastChangeBlock *big.Int
+}, error) {
+	var out []interface{}
+	err := _Balancervault.contract.Call(opts, &out, "getPoolTokens", poolId)
+
+	outstruct := new(struct {
+		Tokens          []common.Address
+		Balances        []*big.Int
+		LastChangeBlock *big.Int
+	})
+	if err != nil {
+		return *outstruct, err
+	}
+
+	outstruct.Tokens = *abi.ConvertType(out[0], new([]common.Address)).(*[]common.Address)
+	outstruct.Balances = *abi.ConvertType(out[1], new([]*big.Int)).(*[]*big.Int)
+	outstruct.LastChangeBlock = *abi.ConvertType(out[2], new(*big.Int)).(**big.Int)
+
+	return *outstruct, err
+
+}
+
+// GetPoolTokens is a free data retrieval call binding the contract method 0xf94d4668.
+//
+// Solidity: function getPoolTokens(bytes32 poolId) view returns(address[] tokens, uint256[] balances, uint256 lastChangeBlock)
+func (_Balancervault *BalancervaultSession) GetPoolTokens(poolId [32]byte) (struct {
+	Tokens          []common.Address
+	Balances        []*big.Int
+	LastChangeBlock *big.Int
+}, error) {
+	return _Balancervault.Contract.GetPoolTokens(&_Balancervault.CallOpts, poolId)
+}
+
+// GetPoolTokens is a free data retrieval call binding the contract method 0xf94d4668.
+//
+// Solidity: function getPoolTokens(bytes32 poolId) view returns(address[] tokens, uint256[] balances, uint256 lastChangeBlock)
+func (_Balancervault *BalancervaultCallerSession) GetPoolTokens(poolId [32]byte) (struct {
+	Tokens          []common.Address
+	Balances        []*big.Int
+	LastChangeBlock *big.Int
+}, error) {
+	return _Balancervault.Contract.GetPoolTokens(&_Balancervault.CallOpts, poolId)
+}
+
+// BalancervaultSwapIterator is returned from FilterSwap and is used to iterate over the raw logs and unpacked data for Swap events raised by the Balancervault contract.
+type BalancervaultSwapIterator struct {
+	Event *BalancervaultSwap // Event containing the contract specifics and raw log
+
+	contract *bind.BoundContract // Generic contract to use for unpacking event data
+	event    string              // Event name to use for unpacking event data
+
+	logs chan types.Log        // Log channel receiving the found contract events
+	sub  ethereum.Subscription // Subscription for errors, completion and termination
+	done bool                  // Whether the subscription completed delivering logs
+	fail error                 // Occurred error to stop iteration
+}
+
+// Next advances the iterator to the subsequent event, returning whether there
+// are any more events found. In case of a retrieval or parsing error, false is
+// returned and Error() can be queried for the exact failure.
+func (it *BalancervaultSwapIterator) Next() bool {
+	// If the iterator failed, stop iterating
+	if it.fail != nil {
+		return false
+	}
+	// If the iterator completed, deliver directly whatever's available
+	if it.done {
+		select {
+		case log := <-it.logs:
+			it.Event = new(BalancervaultSwap)
+			if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+				it.fail = err
+				return false
+			}
+			it.Event.Raw = log
+			return true
+
+		default:
+			return false
+		}
+	}
+	// Iterator still in progress, wait for either a data or an error event
+	select {
+	case log := <-it.logs:
+		it.Event = new(BalancervaultSwap)
+		if err := it.contract.UnpackLog(it.Event, it.event, log); err != nil {
+			it.fail = err
+			return false
+		}
+		it.Event.Raw = log
+		return true
+
+	case err := <-it.sub.Err():
+		it.done = true
+		it.fail = err
+		return it.Next()
+	}
+}
+
+// Error returns any retrieval or parsing error occurred during filtering.
+func (it *BalancervaultSwapIterator) Error() error {
+	return it.fail
+}
+
+// Close terminates the iteration process, releasing any pending underlying
+// resources.
+func (it *BalancervaultSwapIterator) Close() error {
+	it.sub.Unsubscribe()
+	return nil
+}
+
+// BalancervaultSwap represents a Swap event raised by the Balancervault contract.
+type BalancervaultSwap struct {
+	PoolId    [32]byte
+	TokenIn   common.Address
+	TokenOut  common.Address
+	AmountIn  *big.Int
+	AmountOut *big.Int
+	Raw       types.Log // Blockchain specific contextual infos
+}
+
+// FilterSwap is a free log retrieval operation binding the contract event 0x2170c741c41531aec20e7c107c24eecfdd15e69c9bb0a8dd37b1840b9e0b207b.
+//
+// Solidity: event Swap(bytes32 indexed poolId, address indexed tokenIn, address indexed tokenOut, uint256 amountIn, uint256 amountOut)
+func (_Balancervault *BalancervaultFilterer) FilterSwap(opts *bind.FilterOpts, poolId [][32]byte, tokenIn []common.Address, tokenOut []common.Address) (*BalancervaultSwapIterator, error) {
+
+	var poolIdRule []interface{}
+	for _, poolIdItem := range poolId {
+		poolIdRule = append(poolIdRule, poolIdItem)
+	}
+	var tokenInRule []interface{}
+	for _, tokenInItem := range tokenIn {
+		tokenInRule = append(tokenInRule, tokenInItem)
+	}
+	var tokenOutRule []interface{}
+	for _, tokenOutItem := range tokenOut {
+		tokenOutRule = append(tokenOutRule, tokenOutItem)
+	}
+
+	logs, sub, err := _Balancervault.contract.FilterLogs(opts, "Swap", poolIdRule, tokenInRule, tokenOutRule)
+	if err != nil {
+		return nil, err
+	}
+	return &BalancervaultSwapIterator{contract: _Balancervault.contract, event: "Swap", logs: logs, sub: sub}, nil
+}
+
+// WatchSwap is a free log subscription operation binding the contract event 0x2170c741c41531aec20e7c107c24eecfdd15e69c9bb0a8dd37b1840b9e0b207b.
+//
+// Solidity: event Swap(bytes32 indexed poolId, address indexed tokenIn, address indexed tokenOut, uint256 amountIn, uint256 amountOut)
+func (_Balancervault *BalancervaultFilterer) WatchSwap(opts *bind.WatchOpts, sink chan<- *BalancervaultSwap, poolId [][32]byte, tokenIn []common.Address, tokenOut []common.Address) (event.Subscription, error) {
+
+	var poolIdRule []interface{}
+	for _, poolIdItem := range poolId {
+		poolIdRule = append(poolIdRule, poolIdItem)
+	}
+	var tokenInRule []interface{}
+	for _, tokenInItem := range tokenIn {
+		tokenInRule = append(tokenInRule, tokenInItem)
+	}
+	var tokenOutRule []interface{}
+	for _, tokenOutItem := range tokenOut {
+		tokenOutRule = append(tokenOutRule, tokenOutItem)
+	}
+
+	logs, sub, err := _Balancervault.contract.WatchLogs(opts, "Swap", poolIdRule, tokenInRule, tokenOutRule)
+	if err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case log := <-logs:
+				// New log arrived, parse the event and forward to the user
+				event := new(BalancervaultSwap)
+				if err := _Balancervault.contract.UnpackLog(event, "Swap", log); err != nil {
+					return err
+				}
+				event.Raw = log
+
+				select {
+				case sink <- event:
+				case err := <-sub.Err():
+					return err
+				case <-quit:
+					return nil
+				}
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	}), nil
+}
+
+// ParseSwap is a log parse operation binding the contract event 0x2170c741c41531aec20e7c107c24eecfdd15e69c9bb0a8dd37b1840b9e0b207b.
+//
+// Solidity: event Swap(bytes32 indexed poolId, address indexed tokenIn, address indexed tokenOut, uint256 amountIn, uint256 amountOut)
+func (_Balancervault *BalancervaultFilterer) ParseSwap(log types.Log) (*BalancervaultSwap, error) {
+	event := new(BalancervaultSwap)
+	if err := _Balancervault.contract.UnpackLog(event, "Swap", log); err != nil {
+		return nil, err
+	}
+	event.Raw = log
+	return event, nil
+}